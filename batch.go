@@ -0,0 +1,67 @@
+package main
+
+import "context"
+
+// batchKey is the context key of the Batch that Store calls join.
+type batchKey struct{}
+
+// batch is an open Batch: one read-write transaction shared by every Store
+// call made with its context.
+type batch struct {
+	store   *taskStore
+	tx      txn
+	err     error          // First failed change; the batch cannot commit after it
+	pending []batchChanges // Passed to the watchers once the batch commits
+}
+
+// batchChanges are the history entries of one change in a batch, with the
+// context of the call that made it.
+type batchChanges struct {
+	ctx     context.Context
+	changes []HistoryEntry
+}
+
+// batchFrom returns the batch of this store that ctx belongs to, if any.
+func (s *taskStore) batchFrom(ctx context.Context) *batch {
+	if b, ok := ctx.Value(batchKey{}).(*batch); ok && b.store == s {
+		return b
+	}
+	return nil
+}
+
+// Batch runs fn in one transaction: the changes fn makes through the store
+// with the context it is given are committed together when fn returns nil,
+// and discarded otherwise. The store is locked for the duration, so fn
+// should not wait on other callers. A failed change fails the batch even if
+// fn carries on, since its partial writes cannot be rolled back on their own.
+// Watchers see the changes once the batch commits, each with the context of
+// the call that made it.
+func (s *taskStore) Batch(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.batchFrom(ctx) != nil {
+		return fn(ctx) // Nested batches join the outer one
+	}
+	b := &batch{store: s}
+	err := s.backend.update(ctx, "batch", func(tx txn) error {
+		b.tx = tx
+		if err := fn(context.WithValue(ctx, batchKey{}, b)); err != nil {
+			return err
+		}
+		return b.err
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range b.pending {
+		s.notify(p.ctx, p.changes)
+	}
+	return nil
+}
+
+// view runs fn in a read-only transaction, or in the batch ctx belongs to,
+// whose uncommitted changes it then sees.
+func (s *taskStore) view(ctx context.Context, fn func(tx txn) error) error {
+	if b := s.batchFrom(ctx); b != nil {
+		return fn(b.tx)
+	}
+	return s.backend.view(ctx, fn)
+}
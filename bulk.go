@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// POST /batch runs many task operations in one request. Atomic batches run
+// in a single Store transaction and apply all of their operations or none;
+// other batches run each operation on its own and report which succeeded.
+
+// maxBatchOperations bounds the operations in one batch.
+const maxBatchOperations = 1000
+
+// batchRequest is the body of POST /batch.
+type batchRequest struct {
+	Atomic     bool              `json:"atomic"`
+	Operations []json.RawMessage `json:"operations"`
+}
+
+// batchOperation is one operation of a batch, shaped like a WebSocket command.
+type batchOperation struct {
+	Op      string          `json:"op"`      // add, update, delete or complete
+	ID      int             `json:"id"`      // update, delete and complete
+	Task    json.RawMessage `json:"task"`    // add and update, as in POST /todos and PUT /todos/{id}
+	Version int             `json:"version"` // Like If-Match: apply only to this version of the task
+	Force   bool            `json:"force"`   // complete even while blocked
+}
+
+// batchResult reports the outcome of one operation with the status code
+// the equivalent request would have returned.
+type batchResult struct {
+	Index  int             `json:"index"`
+	Op     string          `json:"op,omitempty"`
+	ID     int             `json:"id,omitempty"`
+	Status int             `json:"status"`
+	Task   *ToDo           `json:"task,omitempty"`
+	Error  *problemDetails `json:"error,omitempty"`
+}
+
+// batchResponse is the body answering POST /batch. Committed is false when
+// an atomic batch was rolled back.
+type batchResponse struct {
+	Atomic    bool          `json:"atomic"`
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchOps are the operations a batch may contain.
+var batchOps = map[string]bool{"add": true, "update": true, "delete": true, "complete": true}
+
+// errInvalidOperation is reported for an operation that is not valid.
+var errInvalidOperation = errors.New("invalid operation")
+
+// batchItem is a decoded operation, ready to run.
+type batchItem struct {
+	index int
+	op    batchOperation
+	todo  ToDo  // The validated task document of add and update
+	err   error // Why the operation is invalid
+}
+
+// batchHandler runs the operations of a batch in order. The undo log records
+// each operation as its own entry, for atomic batches too, so a single
+// POST /undo after a batch reverts only its last operation.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "batch")))
+	}()
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "batchHandler")
+	defer span.End()
+	ctx = withClient(ctx, r) // Recorded in the task history and undo log
+
+	var req batchRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		handleBodyError(ctx, w, r, "batch", err)
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		handleError(ctx, w, r, "batch", problemInvalidBody, fmt.Errorf("a batch must have between 1 and %d operations", maxBatchOperations))
+		return
+	}
+	span.SetAttributes(attribute.Bool("batch.atomic", req.Atomic), attribute.Int("batch.operations", len(req.Operations)))
+
+	items := make([]batchItem, len(req.Operations))
+	invalid := 0
+	for i, raw := range req.Operations {
+		items[i] = decodeBatchItem(i, raw)
+		if items[i].err != nil {
+			invalid++
+		}
+	}
+
+	resp := batchResponse{Atomic: req.Atomic, Results: make([]batchResult, len(items))}
+	switch {
+	case !req.Atomic:
+		for i, item := range items {
+			resp.Results[i] = runBatchItem(ctx, item)
+		}
+		resp.Committed = true
+	case invalid > 0:
+		// Nothing is applied; report the invalid operations without running the others
+		failed := slices.IndexFunc(items, func(item batchItem) bool { return item.err != nil })
+		for i, item := range items {
+			if item.err != nil {
+				resp.Results[i] = runBatchItem(ctx, item)
+			} else {
+				resp.Results[i] = abortedResult(ctx, item, failed)
+			}
+		}
+	default:
+		failed := -1
+		err := store.Batch(ctx, func(ctx context.Context) error {
+			for i, item := range items {
+				resp.Results[i] = runBatchItem(ctx, item)
+				if resp.Results[i].Error != nil {
+					failed = i
+					return errBatchItemFailed
+				}
+			}
+			return nil
+		})
+		switch {
+		case err == nil:
+			resp.Committed = true
+		case failed >= 0:
+			for i, item := range items {
+				if i != failed {
+					resp.Results[i] = abortedResult(ctx, item, failed)
+				}
+			}
+		default:
+			handleTaskStoreError(ctx, w, r, "batch", err) // The commit itself failed
+			return
+		}
+	}
+
+	succeeded, status := 0, http.StatusOK
+	for _, res := range resp.Results {
+		if res.Error == nil {
+			succeeded++
+		} else if status == http.StatusOK && res.Status != http.StatusFailedDependency {
+			status = res.Status
+		}
+	}
+	switch {
+	case succeeded == len(resp.Results):
+		status = http.StatusOK
+	case resp.Committed:
+		status = http.StatusMultiStatus // Some operations failed, the others were applied
+	}
+	if !resp.Committed || succeeded < len(resp.Results) {
+		span.SetStatus(codes.Error, "batch had failed operations")
+	}
+	span.SetAttributes(attribute.Int("batch.succeeded", succeeded), attribute.Bool("batch.committed", resp.Committed))
+	logWithTrace(ctx).Str("event", "batch").Bool("atomic", req.Atomic).Bool("committed", resp.Committed).
+		Int("operations", len(items)).Int("succeeded", succeeded).Msg("Ran batch")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// errBatchItemFailed aborts an atomic batch whose operation failed; the
+// failure itself is in the operation's result.
+var errBatchItemFailed = errors.New("batch operation failed")
+
+// decodeBatchItem decodes and validates one operation.
+func decodeBatchItem(index int, raw json.RawMessage) batchItem {
+	item := batchItem{index: index}
+	if err := decodeStrict(raw, &item.op); err != nil {
+		item.err = fmt.Errorf("%w: %w", errInvalidOperation, err)
+		return item
+	}
+	if !batchOps[item.op.Op] {
+		item.err = fmt.Errorf("%w: unknown op %q", errInvalidOperation, item.op.Op)
+		return item
+	}
+	if item.op.Op != "add" && item.op.ID <= 0 {
+		item.err = errInvalidTaskID
+		return item
+	}
+	if item.op.Op == "add" || item.op.Op == "update" {
+		var in taskInput
+		if item.op.Task != nil {
+			if err := decodeStrict(item.op.Task, &in); err != nil {
+				if !errors.As(err, new(validationErrors)) {
+					err = fmt.Errorf("%w: task: %w", errInvalidOperation, err)
+				}
+				item.err = err
+				return item
+			}
+		}
+		pathID := 0
+		if item.op.Op == "update" {
+			pathID = item.op.ID
+		}
+		item.todo, item.err = in.task(pathID)
+	}
+	return item
+}
+
+// runBatchItem runs one operation in a child span of the batch.
+func runBatchItem(ctx context.Context, item batchItem) batchResult {
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "batch."+batchOpName(item.op.Op), oteltrace.WithAttributes(attribute.Int("batch.index", item.index)))
+	defer span.End()
+	res := batchResult{Index: item.index, Op: item.op.Op, ID: item.op.ID}
+	if item.op.ID > 0 {
+		span.SetAttributes(attribute.Int("todo.id", item.op.ID))
+	}
+	if item.err != nil {
+		return failBatchItem(ctx, span, res, item.err)
+	}
+
+	switch item.op.Op {
+	case "add":
+		added, err := store.Add(ctx, item.todo)
+		if err != nil {
+			return failBatchItem(ctx, span, res, err)
+		}
+		taskCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("source", "batch")))
+		countTagOperation(ctx, "add", added.Tags...)
+		span.SetAttributes(attribute.Int("todo.id", added.ID))
+		logWithTrace(ctx).Str("event", "task_added").Int("todo_id", added.ID).Str("todo_text", added.Text).Msg("Added task")
+		res.ID, res.Status, res.Task = added.ID, http.StatusCreated, &added
+	case "update":
+		updated, err := store.Update(ctx, item.todo, item.op.Version)
+		if err != nil {
+			return failBatchItem(ctx, span, res, err)
+		}
+		logWithTrace(ctx).Str("event", "update_task").Int("todo_id", updated.ID).Str("todo_text", updated.Text).Msg("Updated task")
+		res.Status, res.Task = http.StatusOK, &updated
+	case "delete":
+		if err := store.Delete(ctx, item.op.ID, item.op.Version); err != nil {
+			return failBatchItem(ctx, span, res, err)
+		}
+		logWithTrace(ctx).Str("event", "delete_task").Int("todo_id", item.op.ID).Msg("Moved task to trash")
+		res.Status = http.StatusNoContent
+	case "complete":
+		completed, err := store.Complete(ctx, item.op.ID, actorFrom(ctx), item.op.Force, item.op.Version)
+		if err != nil {
+			return failBatchItem(ctx, span, res, err)
+		}
+		logWithTrace(ctx).Str("event", "complete_task").Int("todo_id", completed.ID).Str("actor", completed.CompletedBy).Msg("Completed task")
+		res.Status, res.Task = http.StatusOK, &completed
+	}
+	return res
+}
+
+// failBatchItem logs and counts a failed operation like handleError and
+// returns its result, mapping the error as the HTTP handlers do.
+func failBatchItem(ctx context.Context, span oteltrace.Span, res batchResult, err error) batchResult {
+	var problem problemType
+	var fieldErrs []fieldError
+	var invalid validationErrors
+	switch {
+	case errors.As(err, &invalid):
+		problem, fieldErrs = problemValidation, invalid
+	case errors.Is(err, errInvalidOperation):
+		problem = problemInvalidBody
+	case errors.Is(err, errInvalidTaskID):
+		problem = problemInvalidID
+	default:
+		problem, fieldErrs = taskStoreProblem(err)
+	}
+	span.SetStatus(codes.Error, problem.title)
+	body := newProblemDetails(ctx, problem, recordProblem(ctx, "batch", problem, err, fieldErrs), batchInstance(res.Index), fieldErrs)
+	res.Status, res.Error = problem.status, &body
+	return res
+}
+
+// abortedResult reports an operation of an atomic batch that was not
+// applied because the operation at index failed.
+func abortedResult(ctx context.Context, item batchItem, failed int) batchResult {
+	detail := fmt.Sprintf("the batch was rolled back because operation %d failed", failed)
+	body := newProblemDetails(ctx, problemBatchAborted, detail, batchInstance(item.index), nil)
+	return batchResult{Index: item.index, Op: item.op.Op, ID: item.op.ID, Status: problemBatchAborted.status, Error: &body}
+}
+
+// batchInstance identifies an operation as the problem instance.
+func batchInstance(index int) string {
+	return "/batch#/operations/" + strconv.Itoa(index)
+}
+
+// batchOpName names an operation's span, "invalid" for unknown ops.
+func batchOpName(op string) string {
+	if batchOps[op] {
+		return op
+	}
+	return "invalid"
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// postBatch runs a batch against batchHandler as user alice.
+func postBatch(t *testing.T, body string) (int, batchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", "alice")
+	rr := httptest.NewRecorder()
+	batchHandler(rr, req)
+	var resp batchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", rr.Body.String(), err)
+	}
+	return rr.Code, resp
+}
+
+// batchStatuses lists the per-operation status codes of a batch response.
+func batchStatuses(resp batchResponse) []int {
+	statuses := make([]int, len(resp.Results))
+	for i, res := range resp.Results {
+		statuses[i] = res.Status
+	}
+	return statuses
+}
+
+func TestBatchHandler_Atomic(t *testing.T) {
+	setupTest()
+	ctx := context.Background()
+
+	code, resp := postBatch(t, `{"atomic": true, "operations": [
+		{"op": "add", "task": {"text": "Write the report", "tags": ["Work"]}},
+		{"op": "add", "task": {"text": "Buy milk"}},
+		{"op": "update", "id": 2, "version": 1, "task": {"text": "Buy oat milk"}},
+		{"op": "complete", "id": 1},
+		{"op": "delete", "id": 2}
+	]}`)
+	if code != http.StatusOK || !resp.Committed {
+		t.Fatalf("status = %d, committed = %v, results %+v", code, resp.Committed, resp.Results)
+	}
+	if got := batchStatuses(resp); !slices.Equal(got, []int{201, 201, 200, 200, 204}) {
+		t.Errorf("statuses = %v", got)
+	}
+	if task := resp.Results[3].Task; task == nil || !task.Completed || task.CompletedBy != "alice" {
+		t.Errorf("completed task = %+v", task)
+	}
+	if todos, _ := store.List(ctx, ListFilter{}); len(todos) != 1 {
+		t.Errorf("store has %d tasks, want 1", len(todos))
+	}
+
+	// The update of task 1 fails, so the add before it is rolled back as well
+	code, resp = postBatch(t, `{"atomic": true, "operations": [
+		{"op": "add", "task": {"text": "Call the bank"}},
+		{"op": "update", "id": 1, "version": 1, "task": {"text": "Stale"}},
+		{"op": "delete", "id": 1}
+	]}`)
+	if code != http.StatusPreconditionFailed || resp.Committed {
+		t.Fatalf("status = %d, committed = %v", code, resp.Committed)
+	}
+	if got := batchStatuses(resp); !slices.Equal(got, []int{424, 412, 424}) {
+		t.Errorf("statuses = %v", got)
+	}
+	if resp.Results[0].Error.Type != problemBatchAborted.URI() || resp.Results[0].Error.Instance != "/batch#/operations/0" {
+		t.Errorf("aborted result = %+v", resp.Results[0].Error)
+	}
+	if todos, _ := store.List(ctx, ListFilter{}); len(todos) != 1 || todos[0].Text != "Write the report" {
+		t.Errorf("store after rollback = %+v", todos)
+	}
+
+	// An invalid operation fails the batch before anything runs
+	code, resp = postBatch(t, `{"atomic": true, "operations": [
+		{"op": "delete", "id": 1},
+		{"op": "add", "task": {"text": ""}}
+	]}`)
+	if code != http.StatusUnprocessableEntity || resp.Committed {
+		t.Fatalf("status = %d, committed = %v", code, resp.Committed)
+	}
+	if got := batchStatuses(resp); !slices.Equal(got, []int{424, 422}) {
+		t.Errorf("statuses = %v", got)
+	}
+	if _, err := store.Get(ctx, 1); err != nil {
+		t.Errorf("task 1 was deleted: %v", err)
+	}
+
+	// Only the committed batch was recorded, as one undo step per operation
+	results, err := undos.undo(ctx, "alice", 5)
+	if err != nil || len(results) != 5 {
+		t.Fatalf("undo = %d results, %v", len(results), err)
+	}
+	if _, err := undos.undo(ctx, "alice", 1); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("undo after the batch = %v, want %v", err, ErrNothingToUndo)
+	}
+}
+
+func TestBatchHandler_BestEffort(t *testing.T) {
+	setupTest()
+
+	code, resp := postBatch(t, `{"operations": [
+		{"op": "add", "task": {"text": "Buy milk"}},
+		{"op": "complete", "id": 42},
+		{"op": "rename", "id": 1},
+		{"op": "update", "task": {"text": "No id"}},
+		{"op": "add", "task": {"text": "Walk the dog", "priority": "urgent"}},
+		{"op": "complete", "id": 1}
+	]}`)
+	if code != http.StatusMultiStatus || !resp.Committed {
+		t.Fatalf("status = %d, committed = %v", code, resp.Committed)
+	}
+	if got := batchStatuses(resp); !slices.Equal(got, []int{201, 404, 400, 400, 422, 200}) {
+		t.Errorf("statuses = %v", got)
+	}
+	if errs := resp.Results[4].Error.Errors; len(errs) != 1 || errs[0].Path != "/priority" {
+		t.Errorf("validation errors = %+v", errs)
+	}
+	if todos, _ := store.List(context.Background(), ListFilter{}); len(todos) != 1 || !todos[0].Completed {
+		t.Errorf("store = %+v", todos)
+	}
+}
+
+func TestBatchHandler_InvalidBatch(t *testing.T) {
+	setupTest()
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"No operations", `{"operations": []}`, http.StatusBadRequest},
+		{"Too many operations", `{"operations": [` + strings.Repeat(`{"op": "delete", "id": 1},`, maxBatchOperations) + `{"op": "delete", "id": 1}]}`, http.StatusBadRequest},
+		{"Unknown field", `{"operations": [{"op": "delete", "id": 1}], "mode": "atomic"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			batchHandler(rr, req)
+			if rr.Code != tt.wantCode || rr.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("status = %d, content type %q, want %d", rr.Code, rr.Header().Get("Content-Type"), tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"slices"
+)
+
+var (
+	// ErrBlockerNotFound is returned when an item is blocked by an item that does not exist.
+	ErrBlockerNotFound = errors.New("blocking task not found")
+	// ErrDependencyCycle is returned when a dependency would make an item (transitively) block itself.
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+	// ErrBlocked is returned when completing an item that open items still block.
+	ErrBlocked = errors.New("task is blocked")
+)
+
+// The dependency graph is stored with the items: each lists the items it is
+// blocked by, and the store keeps the derived Blocked flag current as
+// blockers are completed, reopened or deleted.
+
+// refreshBlocked recomputes the Blocked flag: whether any of the item's
+// blockers is still open. Missing blockers are left to checkBlockers.
+func refreshBlocked(tx txn, todo *ToDo) error {
+	todo.Blocked = false
+	for _, id := range todo.BlockedBy {
+		blocker, err := tx.get(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !blocker.Completed {
+			todo.Blocked = true
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkBlockers fails unless every blocker exists and none of them is, or
+// is transitively blocked by, the item id.
+func checkBlockers(tx txn, id int, blockers []int) error {
+	seen := map[int]bool{}
+	stack := slices.Clone(blockers)
+	for _, b := range blockers {
+		if _, err := tx.get(b); errors.Is(err, ErrNotFound) {
+			return ErrBlockerNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+	for len(stack) > 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if b == id {
+			return ErrDependencyCycle
+		}
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		blocker, err := tx.get(b)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stack = append(stack, blocker.BlockedBy...)
+	}
+	return nil
+}
+
+// refreshDependents updates the items blocked by id after its completion
+// changed or, with drop, after it was deleted, removing it from their
+// dependencies.
+func refreshDependents(tx txn, id int, drop bool) error {
+	filter := ListFilter{DependsOn: &id}
+	todos, err := listCandidates(tx, filter)
+	if err != nil {
+		return err
+	}
+	for _, current := range todos {
+		if !filter.matches(current) {
+			continue
+		}
+		todo := current
+		if drop {
+			todo.BlockedBy = slices.DeleteFunc(slices.Clone(todo.BlockedBy), func(b int) bool { return b == id })
+			if len(todo.BlockedBy) == 0 {
+				todo.BlockedBy = nil
+			}
+		}
+		if err := refreshBlocked(tx, &todo); err != nil {
+			return err
+		}
+		if !drop && todo.Blocked == current.Blocked {
+			continue
+		}
+		todo.Version++
+		if err := tx.put(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topoSort orders open items so that each comes after the items in the set
+// that block it, breaking ties with compare. Items caught in a cycle (which
+// the store prevents) are appended in compare order.
+func topoSort(todos []ToDo, compare func(a, b ToDo) int) []ToDo {
+	byID := make(map[int]ToDo, len(todos))
+	for _, todo := range todos {
+		byID[todo.ID] = todo
+	}
+	waiting := map[int]int{}     // Number of blockers in the set not yet emitted
+	unblocks := map[int][]ToDo{} // Items each item blocks
+	var ready []ToDo
+	for _, todo := range todos {
+		for _, b := range todo.BlockedBy {
+			if _, ok := byID[b]; ok {
+				waiting[todo.ID]++
+				unblocks[b] = append(unblocks[b], todo)
+			}
+		}
+		if waiting[todo.ID] == 0 {
+			ready = append(ready, todo)
+		}
+	}
+
+	sorted := make([]ToDo, 0, len(todos))
+	emitted := map[int]bool{}
+	for len(ready) > 0 {
+		next := slices.MinFunc(ready, compare)
+		ready = slices.DeleteFunc(ready, func(todo ToDo) bool { return todo.ID == next.ID })
+		sorted = append(sorted, next)
+		emitted[next.ID] = true
+		for _, todo := range unblocks[next.ID] {
+			if waiting[todo.ID]--; waiting[todo.ID] == 0 {
+				ready = append(ready, todo)
+			}
+		}
+	}
+	if len(sorted) < len(todos) {
+		var rest []ToDo
+		for _, todo := range todos {
+			if !emitted[todo.ID] {
+				rest = append(rest, todo)
+			}
+		}
+		slices.SortFunc(rest, compare)
+		sorted = append(sorted, rest...)
+	}
+	return sorted
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the strong entity tag of a ToDo, derived from its version.
+func etag(todo ToDo) string {
+	return versionTag(todo.Version)
+}
+
+// versionTag is the strong entity tag for a resource version.
+func versionTag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag advertises the version of the ToDo being returned.
+func setETag(w http.ResponseWriter, todo ToDo) {
+	w.Header().Set("ETag", etag(todo))
+}
+
+// ifMatchVersion evaluates the If-Match header against the stored item and
+// returns the version the mutation must be conditioned on, or 0 when the
+// request is unconditional. A missing item fails the precondition (RFC 9110).
+func ifMatchVersion(ctx context.Context, r *http.Request, id int) (int, error) {
+	return ifMatch(r, ErrNotFound, func() (int, error) {
+		current, err := store.Get(ctx, id)
+		return current.Version, err
+	})
+}
+
+// ifMatchProjectVersion is ifMatchVersion for projects.
+func ifMatchProjectVersion(ctx context.Context, r *http.Request, id int) (int, error) {
+	return ifMatch(r, ErrProjectNotFound, func() (int, error) {
+		current, err := store.GetProject(ctx, id)
+		return current.Version, err
+	})
+}
+
+// ifMatch evaluates If-Match against the version load returns; notFound is
+// the error load reports for a missing resource.
+func ifMatch(r *http.Request, notFound error, load func() (int, error)) (int, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	version, err := load()
+	if errors.Is(err, notFound) {
+		return 0, ErrVersionMismatch
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !etagMatches(header, versionTag(version), false) {
+		return 0, ErrVersionMismatch
+	}
+	return version, nil
+}
+
+// etagMatches reports whether an If-Match (strong comparison) or
+// If-None-Match (weak comparison) header value matches tag.
+func etagMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// feedConfig controls the change feed.
+type feedConfig struct {
+	Buffer    int           // Most recent events kept for Last-Event-ID resume
+	Queue     int           // Events queued per subscriber before it is dropped
+	Heartbeat time.Duration // Idle streams get a comment this often
+}
+
+func defaultFeedConfig() feedConfig {
+	return feedConfig{Buffer: 1000, Queue: 64, Heartbeat: 15 * time.Second}
+}
+
+// loadFeedConfig applies TODO_FEED_BUFFER over the defaults.
+func loadFeedConfig(getenv func(string) string) (feedConfig, error) {
+	cfg := defaultFeedConfig()
+	if v := getenv("TODO_FEED_BUFFER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("TODO_FEED_BUFFER must be a positive integer, got %q", v)
+		}
+		cfg.Buffer = n
+	}
+	return cfg, nil
+}
+
+// feedEvent is one task change as sent to subscribers. IDs increase by one
+// per event and restart with the service.
+type feedEvent struct {
+	ID     int64
+	Type   string // add, update, complete or delete
+	Data   []byte // JSON feedPayload
+	Origin oteltrace.SpanContext
+}
+
+// feedPayload is the data of a feedEvent. Traceparent is the W3C trace
+// context of the span that made the change, so consumers can link to it.
+type feedPayload struct {
+	TaskID      int       `json:"task_id"`
+	Op          string    `json:"op"` // Store operation, as in the task history
+	Actor       string    `json:"actor,omitempty"`
+	At          time.Time `json:"at"`
+	Task        *ToDo     `json:"task"` // The task after the change, or as it was deleted
+	TraceID     string    `json:"trace_id,omitempty"`
+	Traceparent string    `json:"traceparent,omitempty"`
+}
+
+// feedSubscriber receives events until done is closed, which happens when
+// it unsubscribes, falls a full queue behind or the feed closes.
+type feedSubscriber struct {
+	events chan feedEvent
+	done   chan struct{}
+}
+
+// feed fans task changes out to subscribers and keeps the most recent ones
+// so a reconnecting subscriber can resume where it left off. Publishing
+// never blocks on subscribers: one whose queue is full is dropped and is
+// expected to reconnect with its Last-Event-ID.
+type feed struct {
+	cfg feedConfig
+
+	mu     sync.Mutex
+	seq    int64
+	events []feedEvent // Oldest first, at most cfg.Buffer
+	subs   map[*feedSubscriber]struct{}
+	closed bool
+
+	published   metric.Int64Counter
+	dropped     metric.Int64Counter
+	subscribers metric.Int64UpDownCounter
+}
+
+func newFeed(cfg feedConfig) (*feed, error) {
+	f := &feed{cfg: cfg, subs: map[*feedSubscriber]struct{}{}}
+	m := otel.Meter("todo-service")
+	var err error
+	f.published, err = m.Int64Counter(
+		"todo_feed_events_total",
+		metric.WithDescription("Total number of task change events published to the feed"),
+		metric.WithUnit("{events}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	f.dropped, err = m.Int64Counter(
+		"todo_feed_dropped_subscribers_total",
+		metric.WithDescription("Total number of feed subscribers dropped for falling behind"),
+		metric.WithUnit("{subscribers}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	f.subscribers, err = m.Int64UpDownCounter(
+		"todo_feed_subscribers",
+		metric.WithDescription("Current number of feed subscribers"),
+		metric.WithUnit("{subscribers}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// eventType classifies a history entry for the feed.
+func eventType(entry HistoryEntry) string {
+	switch {
+	case entry.Before == nil:
+		return "add"
+	case entry.After == nil:
+		return "delete"
+	case entry.After.Completed && !entry.Before.Completed:
+		return "complete"
+	default:
+		return "update"
+	}
+}
+
+// publish sends the changes of one commit to every subscriber; it is
+// registered with Store.Watch.
+func (f *feed) publish(ctx context.Context, changes []HistoryEntry) {
+	origin := oteltrace.SpanContextFromContext(ctx)
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	for _, entry := range changes {
+		payload := feedPayload{
+			TaskID: entry.TaskID, Op: entry.Op, Actor: entry.Actor, At: entry.At, Task: entry.After,
+			TraceID: entry.TraceID, Traceparent: carrier.Get("traceparent"),
+		}
+		if payload.Task == nil {
+			payload.Task = entry.Before
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			logWithTrace(ctx).Err(err).Int("todo_id", entry.TaskID).Msg("Failed to encode feed event")
+			continue
+		}
+		f.seq++
+		event := feedEvent{ID: f.seq, Type: eventType(entry), Data: data, Origin: origin}
+		f.events = append(f.events, event)
+		if len(f.events) > f.cfg.Buffer {
+			f.events = f.events[len(f.events)-f.cfg.Buffer:]
+		}
+		for sub := range f.subs {
+			select {
+			case sub.events <- event:
+			default:
+				f.drop(sub)
+				f.dropped.Add(ctx, 1)
+			}
+		}
+	}
+	f.published.Add(ctx, int64(len(changes)))
+}
+
+// subscribe registers a subscriber. With lastID >= 0 it also returns the
+// buffered events after lastID, or reset when some of them are no longer
+// buffered (or lastID is from before a restart) and the subscriber must
+// reload its state.
+func (f *feed) subscribe(ctx context.Context, lastID int64) (sub *feedSubscriber, backlog []feedEvent, reset bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub = &feedSubscriber{events: make(chan feedEvent, f.cfg.Queue), done: make(chan struct{})}
+	if f.closed {
+		close(sub.done)
+		return sub, nil, false
+	}
+	if lastID >= 0 {
+		oldest := f.seq - int64(len(f.events)) + 1
+		if lastID > f.seq || lastID < oldest-1 {
+			reset = true
+		} else {
+			backlog = append(backlog, f.events[lastID-oldest+1:]...)
+		}
+	}
+	f.subs[sub] = struct{}{}
+	f.subscribers.Add(ctx, 1)
+	return sub, backlog, reset
+}
+
+// unsubscribe removes a subscriber; it is a no-op for one already dropped.
+func (f *feed) unsubscribe(sub *feedSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop(sub)
+}
+
+// drop ends a subscription. The caller must hold mu.
+func (f *feed) drop(sub *feedSubscriber) {
+	if _, ok := f.subs[sub]; !ok {
+		return
+	}
+	delete(f.subs, sub)
+	close(sub.done)
+	f.subscribers.Add(context.Background(), -1)
+}
+
+// close ends every subscription, so streams finish before the server shuts down.
+func (f *feed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for sub := range f.subs {
+		f.drop(sub)
+	}
+}
+
+// writeEvent writes an event in text/event-stream format.
+func writeEvent(ctx context.Context, w io.Writer, e feedEvent) error {
+	_, span := startEventSpan(ctx, "feed.send", e)
+	defer span.End()
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+	return err
+}
+
+// startEventSpan starts the span delivering an event to a subscriber,
+// linked to the span that made the change.
+func startEventSpan(ctx context.Context, name string, e feedEvent) (context.Context, oteltrace.Span) {
+	opts := []oteltrace.SpanStartOption{oteltrace.WithAttributes(
+		attribute.Int64("feed.event_id", e.ID),
+		attribute.String("feed.event_type", e.Type),
+	)}
+	if e.Origin.IsValid() {
+		opts = append(opts, oteltrace.WithLinks(oteltrace.Link{SpanContext: e.Origin}))
+	}
+	return otel.Tracer("todo-service").Start(ctx, name, opts...)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestFeed(t *testing.T) {
+	ctx := context.Background()
+	f, err := newFeed(feedConfig{Buffer: 3, Queue: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMemoryStore()
+	s.Watch(f.publish)
+
+	traceID := oteltrace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	spanID := oteltrace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+	traced := oteltrace.ContextWithSpanContext(ctx, oteltrace.NewSpanContext(oteltrace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: oteltrace.FlagsSampled}))
+	todo, _ := s.Add(traced, ToDo{Text: "Water plants"})
+	s.Complete(ctx, todo.ID, "alice", false, 0)
+	s.Delete(ctx, todo.ID, 0)
+
+	sub, backlog, reset := f.subscribe(ctx, 0)
+	if reset || len(backlog) != 3 || backlog[0].Type != "add" || backlog[1].Type != "complete" || backlog[2].Type != "delete" {
+		t.Fatalf("resume from 0 = %+v, reset %v", backlog, reset)
+	}
+	var payload feedPayload
+	json.Unmarshal(backlog[0].Data, &payload)
+	if payload.TaskID != todo.ID || payload.Task.Text != "Water plants" || payload.Traceparent != "00-"+traceID.String()+"-"+spanID.String()+"-01" || backlog[0].Origin.SpanID() != spanID {
+		t.Errorf("add event = %+v", payload)
+	}
+	if _, backlog, reset := f.subscribe(ctx, 2); reset || len(backlog) != 1 || backlog[0].ID != 3 {
+		t.Errorf("resume from 2 = %+v, reset %v", backlog, reset)
+	}
+
+	// Events beyond the buffer are gone, and so are IDs from before a restart.
+	s.Add(ctx, ToDo{Text: "Feed cat"})
+	if _, _, reset := f.subscribe(ctx, 0); !reset {
+		t.Error("resume past the buffer did not reset")
+	}
+	if _, _, reset := f.subscribe(ctx, 99); !reset {
+		t.Error("resume from an unknown ID did not reset")
+	}
+
+	// A subscriber that falls a full queue behind is dropped.
+	s.Add(ctx, ToDo{Text: "Feed dog"})
+	select {
+	case <-sub.done:
+		t.Fatal("subscriber dropped with room in its queue")
+	default:
+	}
+	s.Add(ctx, ToDo{Text: "Feed fish"})
+	<-sub.done
+	f.unsubscribe(sub) // No-op for a dropped subscriber
+
+	f.close()
+	if sub, _, _ := f.subscribe(ctx, -1); sub == nil {
+		t.Fatal("subscribe after close returned no subscriber")
+	} else {
+		<-sub.done
+	}
+}
+
+func TestLoadFeedConfig(t *testing.T) {
+	env := map[string]string{"TODO_FEED_BUFFER": "50"}
+	if cfg, err := loadFeedConfig(func(key string) string { return env[key] }); err != nil || cfg.Buffer != 50 {
+		t.Errorf("config = %+v, %v", cfg, err)
+	}
+	env["TODO_FEED_BUFFER"] = "-1"
+	if _, err := loadFeedConfig(func(key string) string { return env[key] }); err == nil {
+		t.Error("negative feed buffer was accepted")
+	}
+}
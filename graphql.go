@@ -0,0 +1,780 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// The GraphQL API (POST /graphql) lets clients fetch exactly the task fields
+// they need. Its queries and mutations run the same Store operations and
+// validation as the HTTP handlers, each resolver in a span of its own, and
+// operations whose estimated cost exceeds graphqlConfig.MaxComplexity are
+// rejected before they run.
+
+// graphqlConfig bounds the cost of GraphQL operations.
+type graphqlConfig struct {
+	MaxComplexity int // Highest accepted estimate of queryComplexity
+}
+
+func defaultGraphQLConfig() graphqlConfig {
+	return graphqlConfig{MaxComplexity: 5000}
+}
+
+// loadGraphQLConfig applies TODO_GRAPHQL_MAX_COMPLEXITY over the defaults.
+func loadGraphQLConfig(getenv func(string) string) (graphqlConfig, error) {
+	cfg := defaultGraphQLConfig()
+	if v := getenv("TODO_GRAPHQL_MAX_COMPLEXITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("TODO_GRAPHQL_MAX_COMPLEXITY must be a positive integer, got %q", v)
+		}
+		cfg.MaxComplexity = n
+	}
+	return cfg, nil
+}
+
+// graphqlAPI is the executable schema behind POST /graphql.
+type graphqlAPI struct {
+	schema graphql.Schema
+	cfg    graphqlConfig
+}
+
+func newGraphQLAPI(cfg graphqlConfig) (*graphqlAPI, error) {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		return nil, err
+	}
+	return &graphqlAPI{schema: schema, cfg: cfg}, nil
+}
+
+// graphqlRequest is the body of POST /graphql.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+	Extensions    map[string]any `json:"extensions"` // Sent by some clients; ignored
+}
+
+// graphqlInstance is the problem instance reported in GraphQL errors.
+const graphqlInstance = "/graphql"
+
+var (
+	// errInvalidArgument is reported for an argument the resolver rejects.
+	errInvalidArgument = errors.New("invalid argument")
+	// errInvalidTaskID is reported for a task id that is not positive.
+	errInvalidTaskID = errors.New("id must be a positive integer")
+)
+
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "graphql")))
+	}()
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "graphqlHandler")
+	defer span.End()
+	ctx = withClient(ctx, r) // Mutations are recorded in the task history and undo log
+
+	var req graphqlRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		handleBodyError(ctx, w, r, "graphql", err)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		handleError(ctx, w, r, "graphql", problemInvalidBody, errors.New("query is required"))
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		writeGraphQLRequestError(ctx, w, problemInvalidQuery, err, gqlerrors.FormatErrors(err))
+		return
+	}
+	if result := graphql.ValidateDocument(&graphQL.schema, doc, nil); !result.IsValid {
+		writeGraphQLRequestError(ctx, w, problemInvalidQuery, result.Errors[0], result.Errors)
+		return
+	}
+	op := findOperation(doc, req.OperationName)
+	if op == nil {
+		err := fmt.Errorf("unknown operation %q", req.OperationName)
+		if req.OperationName == "" {
+			err = errors.New("operationName is required when the document has several operations")
+		}
+		writeGraphQLRequestError(ctx, w, problemInvalidQuery, err, gqlerrors.FormatErrors(err))
+		return
+	}
+	complexity := queryComplexity(&graphQL.schema, doc, op, req.Variables)
+	span.SetAttributes(
+		attribute.String("graphql.operation.name", req.OperationName),
+		attribute.String("graphql.operation.type", op.Operation),
+		attribute.Int("graphql.complexity", complexity),
+	)
+	if complexity > graphQL.cfg.MaxComplexity {
+		err := fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, graphQL.cfg.MaxComplexity)
+		writeGraphQLRequestError(ctx, w, problemQueryTooComplex, err, gqlerrors.FormatErrors(err))
+		return
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphQL.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+	span.SetAttributes(attribute.Int("graphql.errors", len(result.Errors)))
+	logWithTrace(ctx).Str("event", "graphql").Str("operation", op.Operation).Str("operation_name", req.OperationName).
+		Int("complexity", complexity).Int("errors", len(result.Errors)).Msg("Executed GraphQL operation")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeGraphQLRequestError answers a request that cannot run with 400 and a
+// GraphQL errors list, each entry extended with the problem's members.
+func writeGraphQLRequestError(ctx context.Context, w http.ResponseWriter, problem problemType, err error, errs []gqlerrors.FormattedError) {
+	oteltrace.SpanFromContext(ctx).SetStatus(codes.Error, problem.title)
+	ext := graphqlExtensions(ctx, problem, recordProblem(ctx, "graphql", problem, err, nil), nil)
+	for i := range errs {
+		errs[i].Extensions = ext
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(problem.status)
+	json.NewEncoder(w).Encode(graphql.Result{Errors: errs})
+}
+
+// graphqlError is a resolver failure; graphql-go reports its extensions
+// with the error.
+type graphqlError struct {
+	message    string
+	extensions map[string]any
+}
+
+func (e graphqlError) Error() string              { return e.message }
+func (e graphqlError) Extensions() map[string]any { return e.extensions }
+
+// newGraphQLError logs and counts a failed resolver like handleError and
+// returns its error, mapping err as the HTTP handlers do.
+func newGraphQLError(ctx context.Context, field string, err error) graphqlError {
+	var problem problemType
+	var fieldErrs []fieldError
+	var invalid validationErrors
+	switch {
+	case errors.As(err, &invalid):
+		problem, fieldErrs = problemValidation, invalid
+	case errors.Is(err, errInvalidArgument):
+		problem = problemInvalidParameter
+	case errors.Is(err, errInvalidTaskID):
+		problem = problemInvalidID
+	default:
+		problem, fieldErrs = taskStoreProblem(err)
+	}
+	for i, fe := range fieldErrs {
+		fieldErrs[i].Path = graphqlFieldPath(fe.Path)
+	}
+	detail := recordProblem(ctx, "graphql_"+field, problem, err, fieldErrs)
+	message := detail
+	if message == "" {
+		message = problem.title
+	}
+	return graphqlError{message: message, extensions: graphqlExtensions(ctx, problem, detail, fieldErrs)}
+}
+
+// graphqlExtensions renders the problem body the HTTP API would return as
+// error extensions, plus its slug as the conventional code member.
+func graphqlExtensions(ctx context.Context, problem problemType, detail string, fieldErrs []fieldError) map[string]any {
+	ext := map[string]any{}
+	data, _ := json.Marshal(newProblemDetails(ctx, problem, detail, graphqlInstance, fieldErrs))
+	json.Unmarshal(data, &ext)
+	ext["code"] = problem.slug
+	return ext
+}
+
+// traced wraps a resolver that does work in a span and latency record of its
+// own, and reports its errors. Fields read from the parent are not traced.
+func traced(typeName, field string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		start := time.Now()
+		defer func() {
+			duration := time.Since(start).Milliseconds()
+			handlerLatency.Record(p.Context, float64(duration), metric.WithAttributes(attribute.String("handler", "graphql_"+field)))
+		}()
+		tr := otel.Tracer("todo-service")
+		ctx, span := tr.Start(p.Context, "graphql."+typeName+"."+field, oteltrace.WithAttributes(
+			attribute.String("graphql.field.path", graphqlPath(p.Info.Path)),
+		))
+		defer span.End()
+		p.Context = ctx
+		v, err := resolve(p)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, newGraphQLError(ctx, field, err)
+		}
+		return v, nil
+	}
+}
+
+// graphqlPath renders a response path such as tasks.tasks.0.subtasks.
+func graphqlPath(path *graphql.ResponsePath) string {
+	var parts []string
+	for ; path != nil; path = path.Prev {
+		parts = append(parts, fmt.Sprint(path.Key))
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, ".")
+}
+
+// taskPage is a page of the tasks query.
+type taskPage struct {
+	Tasks      []ToDo
+	NextCursor string
+}
+
+// newGraphQLSchema builds the schema. Task fields are named as in GraphQL
+// convention; inputs are converted to the JSON documents of the HTTP API.
+func newGraphQLSchema() (graphql.Schema, error) {
+	progressType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TaskProgress",
+		Fields: graphql.Fields{
+			"done":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"total":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"percent": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	var taskType *graphql.Object
+	taskType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Task",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":             taskField(graphql.NewNonNull(graphql.Int), func(t ToDo) any { return t.ID }),
+				"text":           taskField(graphql.NewNonNull(graphql.String), func(t ToDo) any { return t.Text }),
+				"version":        taskField(graphql.NewNonNull(graphql.Int), func(t ToDo) any { return t.Version }),
+				"createdAt":      taskField(graphql.NewNonNull(graphql.DateTime), func(t ToDo) any { return t.CreatedAt }),
+				"completed":      taskField(graphql.NewNonNull(graphql.Boolean), func(t ToDo) any { return t.Completed }),
+				"completedAt":    taskField(graphql.DateTime, func(t ToDo) any { return t.CompletedAt }),
+				"completedBy":    taskField(graphql.String, func(t ToDo) any { return nonZero(t.CompletedBy) }),
+				"due":            taskField(graphql.DateTime, func(t ToDo) any { return t.Due }),
+				"dueZone":        taskField(graphql.String, func(t ToDo) any { return nonZero(t.DueZone) }),
+				"overdue":        taskField(graphql.NewNonNull(graphql.Boolean), func(t ToDo) any { return t.Overdue }),
+				"priority":       taskField(graphql.String, func(t ToDo) any { return nonZero(t.Priority) }),
+				"position":       taskField(graphql.String, func(t ToDo) any { return nonZero(t.Position) }),
+				"tags":           taskField(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))), func(t ToDo) any { return append([]string{}, t.Tags...) }),
+				"project":        taskField(graphql.Int, func(t ToDo) any { return nonZero(t.Project) }),
+				"parent":         taskField(graphql.Int, func(t ToDo) any { return nonZero(t.Parent) }),
+				"progress":       taskField(progressType, func(t ToDo) any { return t.Progress }),
+				"blockedBy":      taskField(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))), func(t ToDo) any { return append([]int{}, t.BlockedBy...) }),
+				"blocked":        taskField(graphql.NewNonNull(graphql.Boolean), func(t ToDo) any { return t.Blocked }),
+				"recurrence":     taskField(graphql.String, func(t ToDo) any { return nonZero(t.Recurrence) }),
+				"occurrence":     taskField(graphql.Int, func(t ToDo) any { return nonZero(t.Occurrence) }),
+				"nextOccurrence": taskField(graphql.Int, func(t ToDo) any { return nonZero(t.NextOccurrence) }),
+				"subtasks": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taskType))),
+					Description: "The task's direct subtasks.",
+					Resolve: traced("Task", "subtasks", func(p graphql.ResolveParams) (any, error) {
+						parent := p.Source.(ToDo).ID
+						return store.List(p.Context, ListFilter{Parent: &parent})
+					}),
+				},
+			}
+		}),
+	})
+
+	pageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TaskPage",
+		Fields: graphql.Fields{
+			"tasks": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taskType))),
+				Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(taskPage).Tasks, nil },
+			},
+			"nextCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Pass as after to fetch the next page; null on the last page.",
+				Resolve:     func(p graphql.ResolveParams) (any, error) { return nonZero(p.Source.(taskPage).NextCursor), nil },
+			},
+		},
+	})
+
+	stringList := graphql.NewList(graphql.NewNonNull(graphql.String))
+	filterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "TaskFilter",
+		Description: "Narrows a listing like the query parameters of GET /todos; 0 selects no project or parent.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"status":    &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "open, done or all (the default)"},
+			"project":   &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"parent":    &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"overdue":   &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+			"blocked":   &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+			"dueBefore": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"dueAfter":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"tagsAny":   &graphql.InputObjectFieldConfig{Type: stringList},
+			"tagsAll":   &graphql.InputObjectFieldConfig{Type: stringList},
+			"tagsNone":  &graphql.InputObjectFieldConfig{Type: stringList},
+		},
+	})
+	inputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "TaskInput",
+		Description: "A task document as in POST /todos and PUT /todos/{id}.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"text":       &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"due":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"dueZone":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"priority":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"tags":       &graphql.InputObjectFieldConfig{Type: stringList},
+			"project":    &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"parent":     &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"blockedBy":  &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+			"recurrence": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"task": &graphql.Field{
+				Type: taskType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: traced("Query", "task", func(p graphql.ResolveParams) (any, error) {
+					id, err := graphqlTaskID(p)
+					if err != nil {
+						return nil, err
+					}
+					return store.Get(p.Context, id)
+				}),
+			},
+			"tasks": &graphql.Field{
+				Type: graphql.NewNonNull(pageType),
+				Args: graphql.FieldConfigArgument{
+					"filter": {Type: filterType},
+					"sort":   {Type: graphql.String, Description: "As the sort query parameter of GET /todos"},
+					"first":  {Type: graphql.Int, Description: fmt.Sprintf("Page size, 1 to %d (default %d)", maxPageSize, defaultPageSize)},
+					"after":  {Type: graphql.String, Description: "The nextCursor of the previous page"},
+				},
+				Resolve: traced("Query", "tasks", resolveTasks),
+			},
+			"search": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taskType))),
+				Args: graphql.FieldConfigArgument{
+					"query":  {Type: graphql.NewNonNull(graphql.String)},
+					"filter": {Type: filterType},
+					"sort":   {Type: graphql.String},
+				},
+				Resolve: traced("Query", "search", resolveSearch),
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"addTask": &graphql.Field{
+				Type: graphql.NewNonNull(taskType),
+				Args: graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(inputType)}},
+				Resolve: traced("Mutation", "addTask", func(p graphql.ResolveParams) (any, error) {
+					todo, err := graphqlTaskInput(p.Args["input"], 0)
+					if err != nil {
+						return nil, err
+					}
+					added, err := store.Add(p.Context, todo)
+					if err != nil {
+						return nil, err
+					}
+					taskCounter.Add(p.Context, 1, metric.WithAttributes(attribute.String("source", "graphql")))
+					countTagOperation(p.Context, "add", added.Tags...)
+					logWithTrace(p.Context).Str("event", "task_added").Int("todo_id", added.ID).Str("todo_text", added.Text).Msg("Added task")
+					return added, nil
+				}),
+			},
+			"updateTask": &graphql.Field{
+				Type: graphql.NewNonNull(taskType),
+				Args: graphql.FieldConfigArgument{
+					"id":      {Type: graphql.NewNonNull(graphql.Int)},
+					"input":   {Type: graphql.NewNonNull(inputType)},
+					"version": {Type: graphql.Int, Description: "Like If-Match: apply only to this version of the task"},
+				},
+				Resolve: traced("Mutation", "updateTask", func(p graphql.ResolveParams) (any, error) {
+					id, err := graphqlTaskID(p)
+					if err != nil {
+						return nil, err
+					}
+					todo, err := graphqlTaskInput(p.Args["input"], id)
+					if err != nil {
+						return nil, err
+					}
+					version, _ := p.Args["version"].(int)
+					updated, err := store.Update(p.Context, todo, version)
+					if err != nil {
+						return nil, err
+					}
+					logWithTrace(p.Context).Str("event", "update_task").Int("todo_id", updated.ID).Str("todo_text", updated.Text).Msg("Updated task")
+					return updated, nil
+				}),
+			},
+			"deleteTask": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Moves the task to the trash.",
+				Args: graphql.FieldConfigArgument{
+					"id":      {Type: graphql.NewNonNull(graphql.Int)},
+					"version": {Type: graphql.Int},
+				},
+				Resolve: traced("Mutation", "deleteTask", func(p graphql.ResolveParams) (any, error) {
+					id, err := graphqlTaskID(p)
+					if err != nil {
+						return nil, err
+					}
+					version, _ := p.Args["version"].(int)
+					if err := store.Delete(p.Context, id, version); err != nil {
+						return nil, err
+					}
+					logWithTrace(p.Context).Str("event", "delete_task").Int("todo_id", id).Msg("Moved task to trash")
+					return true, nil
+				}),
+			},
+			"completeTask": &graphql.Field{
+				Type: graphql.NewNonNull(taskType),
+				Args: graphql.FieldConfigArgument{
+					"id":      {Type: graphql.NewNonNull(graphql.Int)},
+					"force":   {Type: graphql.Boolean, Description: "Complete the task even while it is blocked"},
+					"version": {Type: graphql.Int},
+				},
+				Resolve: traced("Mutation", "completeTask", func(p graphql.ResolveParams) (any, error) {
+					id, err := graphqlTaskID(p)
+					if err != nil {
+						return nil, err
+					}
+					force, _ := p.Args["force"].(bool)
+					version, _ := p.Args["version"].(int)
+					completed, err := store.Complete(p.Context, id, actorFrom(p.Context), force, version)
+					if err != nil {
+						return nil, err
+					}
+					logWithTrace(p.Context).Str("event", "complete_task").Int("todo_id", completed.ID).Str("actor", completed.CompletedBy).Msg("Completed task")
+					return completed, nil
+				}),
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+}
+
+// taskField is a Task field read from the parent task.
+func taskField(typ graphql.Output, get func(ToDo) any) *graphql.Field {
+	return &graphql.Field{Type: typ, Resolve: func(p graphql.ResolveParams) (any, error) {
+		return get(p.Source.(ToDo)), nil
+	}}
+}
+
+// nonZero returns v, or nil (null) for the zero value of an optional field.
+func nonZero[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+func resolveTasks(p graphql.ResolveParams) (any, error) {
+	query := graphqlFilterQuery(p.Args["filter"])
+	if sort, ok := p.Args["sort"].(string); ok {
+		query.Set("sort", sort)
+	}
+	if first, ok := p.Args["first"].(int); ok {
+		query.Set("limit", strconv.Itoa(first))
+	}
+	if after, ok := p.Args["after"].(string); ok {
+		query.Set("cursor", after)
+	}
+	filter, err := parseListFilter(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidArgument, err)
+	}
+	page, err := parsePageRequest(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidArgument, err)
+	}
+	todos, err := store.List(p.Context, filter)
+	if err != nil {
+		return nil, err
+	}
+	todos, next := paginate(todos, page)
+	oteltrace.SpanFromContext(p.Context).SetAttributes(attribute.Int("todo.list.result_count", len(todos)), attribute.Bool("todo.list.has_more", next != ""))
+	logWithTrace(p.Context).Str("event", "list_tasks").Int("count", len(todos)).Msg("Listed tasks")
+	return taskPage{Tasks: todos, NextCursor: next}, nil
+}
+
+func resolveSearch(p graphql.ResolveParams) (any, error) {
+	query, _ := p.Args["query"].(string)
+	if query == "" {
+		return nil, fmt.Errorf("%w: query must not be empty", errInvalidArgument)
+	}
+	filter, err := parseListFilter(graphqlFilterQuery(p.Args["filter"]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidArgument, err)
+	}
+	sort, _ := p.Args["sort"].(string)
+	order, err := parseSortOrder(sort)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidArgument, err)
+	}
+	results, err := store.Search(p.Context, query, filter)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(results, order.compare)
+	oteltrace.SpanFromContext(p.Context).SetAttributes(attribute.String("search.query", query), attribute.Int("search.results", len(results)))
+	logWithTrace(p.Context).Str("event", "search_tasks").Str("query", query).Int("count", len(results)).Msg("Searched tasks")
+	return results, nil
+}
+
+// graphqlTaskID returns the id argument of a resolver.
+func graphqlTaskID(p graphql.ResolveParams) (int, error) {
+	id, _ := p.Args["id"].(int)
+	if id <= 0 {
+		return 0, errInvalidTaskID
+	}
+	oteltrace.SpanFromContext(p.Context).SetAttributes(attribute.Int("todo.id", id))
+	return id, nil
+}
+
+// graphqlInputNames maps TaskInput and TaskFilter fields to the members
+// and query parameters of the HTTP API.
+var graphqlInputNames = map[string]string{
+	"dueZone": "due_zone", "blockedBy": "blocked_by",
+	"dueBefore": "due_before", "dueAfter": "due_after",
+	"tagsAny": "tags_any", "tagsAll": "tags_all", "tagsNone": "tags_none",
+}
+
+// graphqlName returns the HTTP API's name for an input field.
+func graphqlName(field string) string {
+	if name, ok := graphqlInputNames[field]; ok {
+		return name
+	}
+	return field
+}
+
+// graphqlFieldPath rewrites a field error's JSON Pointer from the HTTP API's
+// member names to the TaskInput field names.
+func graphqlFieldPath(path string) string {
+	first, rest, nested := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	for field, name := range graphqlInputNames {
+		if name == first {
+			first = field
+		}
+	}
+	if nested {
+		return "/" + first + "/" + rest
+	}
+	return "/" + first
+}
+
+// graphqlTaskInput validates a TaskInput argument as the body of POST /todos
+// (id 0) or PUT /todos/{id}.
+func graphqlTaskInput(arg any, id int) (ToDo, error) {
+	input, _ := arg.(map[string]any)
+	doc := map[string]any{}
+	for field, v := range input {
+		if v != nil {
+			doc[graphqlName(field)] = v
+		}
+	}
+	return taskFromDocument(doc, id)
+}
+
+// graphqlFilterQuery renders a TaskFilter argument as the query parameters
+// of GET /todos, so it is parsed by parseListFilter.
+func graphqlFilterQuery(arg any) url.Values {
+	query := url.Values{}
+	filter, _ := arg.(map[string]any)
+	for field, v := range filter {
+		name := graphqlName(field)
+		switch v := v.(type) {
+		case string:
+			query.Set(name, v)
+		case bool:
+			query.Set(name, strconv.FormatBool(v))
+		case int:
+			if v == 0 {
+				query.Set(name, "none")
+			} else {
+				query.Set(name, strconv.Itoa(v))
+			}
+		case []any:
+			tags := make([]string, len(v))
+			for i, tag := range v {
+				tags[i] = fmt.Sprint(tag)
+			}
+			query.Set(name, strings.Join(tags, ","))
+		}
+	}
+	return query
+}
+
+// findOperation returns the operation of doc to run: the one named, or the
+// only one when name is empty.
+func findOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" && found != nil {
+			return nil
+		}
+		if name == "" || (op.Name != nil && op.Name.Value == name) {
+			found = op
+		}
+	}
+	return found
+}
+
+// graphqlListSizes are the items expected from list fields, counted when
+// estimating complexity. A first argument takes precedence.
+var graphqlListSizes = map[string]int{
+	"Query.tasks":   defaultPageSize,
+	"Query.search":  defaultPageSize,
+	"Task.subtasks": 10,
+}
+
+// queryComplexity estimates the cost of an operation: one per field, with
+// the selections under a list field counted once per expected item, so
+// nesting subtasks multiplies the cost. Introspection is not counted. doc
+// must have been validated.
+func queryComplexity(schema *graphql.Schema, doc *ast.Document, op *ast.OperationDefinition, variables map[string]any) int {
+	c := complexityWalker{op: op, fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			c.fragments[frag.Name.Value] = frag
+		}
+	}
+	c.schema = schema
+	root := schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+	return c.selectionCost(op.SelectionSet, root)
+}
+
+type complexityWalker struct {
+	schema    *graphql.Schema
+	op        *ast.OperationDefinition
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+}
+
+func (c complexityWalker) selectionCost(set *ast.SelectionSet, parent *graphql.Object) int {
+	cost := 0
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			cost += c.fieldCost(sel, parent)
+		case *ast.InlineFragment:
+			cost += c.selectionCost(sel.SelectionSet, c.object(sel.TypeCondition, parent))
+		case *ast.FragmentSpread:
+			if frag, ok := c.fragments[sel.Name.Value]; ok {
+				cost += c.selectionCost(frag.SelectionSet, c.object(frag.TypeCondition, parent))
+			}
+		}
+	}
+	return cost
+}
+
+func (c complexityWalker) fieldCost(f *ast.Field, parent *graphql.Object) int {
+	def, ok := parent.Fields()[f.Name.Value]
+	if !ok {
+		return 0 // Introspection
+	}
+	if f.SelectionSet == nil {
+		return 1
+	}
+	typ := def.Type
+	for {
+		if wrapped, ok := typ.(*graphql.NonNull); ok {
+			typ = wrapped.OfType
+		} else if wrapped, ok := typ.(*graphql.List); ok {
+			typ = wrapped.OfType
+		} else {
+			break
+		}
+	}
+	obj, ok := typ.(*graphql.Object)
+	if !ok {
+		return 1
+	}
+	items := 1
+	if n, ok := c.first(f); ok {
+		items = n
+	} else if n, ok := graphqlListSizes[parent.Name()+"."+f.Name.Value]; ok {
+		items = n
+	}
+	return 1 + items*c.selectionCost(f.SelectionSet, obj)
+}
+
+// first returns the first argument of a field, if it is set, clamped to the
+// page sizes the resolvers accept so that an out-of-range value cannot lower
+// the estimate. A value that cannot be determined counts as the largest page.
+func (c complexityWalker) first(f *ast.Field) (int, bool) {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value == "first" {
+			return c.pageSize(arg.Value), true
+		}
+	}
+	return 0, false
+}
+
+// pageSize resolves a first argument, taking a variable the request omits
+// from its declared default.
+func (c complexityWalker) pageSize(value ast.Value) int {
+	switch v := value.(type) {
+	case *ast.IntValue:
+		if n, err := strconv.Atoi(v.Value); err == nil {
+			return min(max(n, 1), maxPageSize)
+		}
+	case *ast.Variable:
+		if n, ok := c.variables[v.Name.Value]; ok {
+			if n, ok := n.(float64); ok { // Decoded from JSON
+				return int(min(max(n, 1), maxPageSize))
+			}
+			break
+		}
+		for _, def := range c.op.VariableDefinitions {
+			if def.Variable.Name.Value == v.Name.Value && def.DefaultValue != nil {
+				if _, ok := def.DefaultValue.(*ast.Variable); !ok {
+					return c.pageSize(def.DefaultValue)
+				}
+			}
+		}
+	}
+	return maxPageSize
+}
+
+// object returns the type named by a fragment's type condition.
+func (c complexityWalker) object(cond *ast.Named, parent *graphql.Object) *graphql.Object {
+	if cond == nil {
+		return parent
+	}
+	if obj, ok := c.schema.Type(cond.Name.Value).(*graphql.Object); ok {
+		return obj
+	}
+	return parent
+}
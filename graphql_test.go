@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphqlResponse is the body of a POST /graphql response.
+type graphqlResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// postGraphQL runs an operation against graphqlHandler as user alice.
+func postGraphQL(t *testing.T, query string, variables map[string]any) (int, graphqlResponse) {
+	t.Helper()
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("X-User", "alice")
+	rr := httptest.NewRecorder()
+	graphqlHandler(rr, req)
+	var resp graphqlResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", rr.Body.String(), err)
+	}
+	return rr.Code, resp
+}
+
+func setupGraphQLTest(t *testing.T) {
+	t.Helper()
+	setupTest()
+	var err error
+	if graphQL, err = newGraphQLAPI(defaultGraphQLConfig()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGraphQLQueriesAndMutations(t *testing.T) {
+	setupGraphQLTest(t)
+
+	code, resp := postGraphQL(t, `mutation($input: TaskInput!) { addTask(input: $input) { id text priority tags dueZone createdAt } }`,
+		map[string]any{"input": map[string]any{"text": "Write the report", "priority": "P1", "tags": []string{"Work"}}})
+	if code != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("addTask: %d %+v", code, resp.Errors)
+	}
+	var added struct {
+		ID       int      `json:"id"`
+		Text     string   `json:"text"`
+		Priority string   `json:"priority"`
+		Tags     []string `json:"tags"`
+		DueZone  *string  `json:"dueZone"`
+	}
+	json.Unmarshal(resp.Data["addTask"], &added)
+	if added.ID == 0 || added.Priority != "P1" || len(added.Tags) != 1 || added.Tags[0] != "work" || added.DueZone != nil {
+		t.Fatalf("addTask = %s", resp.Data["addTask"])
+	}
+	postGraphQL(t, `mutation { addTask(input: {text: "Draft outline", parent: 1}) { id } }`, nil)
+	postGraphQL(t, `mutation { addTask(input: {text: "Buy milk"}) { id } }`, nil)
+
+	_, resp = postGraphQL(t, `{ task(id: 1) { text subtasks { text } progress { done total } } }`, nil)
+	if got := string(resp.Data["task"]); got != `{"progress":{"done":0,"total":1},"subtasks":[{"text":"Draft outline"}],"text":"Write the report"}` {
+		t.Errorf("task = %s, errors %+v", got, resp.Errors)
+	}
+
+	_, resp = postGraphQL(t, `{ tasks(first: 2, filter: {parent: 0}) { tasks { id } nextCursor } }`, nil)
+	var page struct {
+		Tasks      []struct{ ID int } `json:"tasks"`
+		NextCursor *string            `json:"nextCursor"`
+	}
+	json.Unmarshal(resp.Data["tasks"], &page)
+	if len(page.Tasks) != 2 || page.NextCursor != nil {
+		t.Errorf("tasks = %s, errors %+v", resp.Data["tasks"], resp.Errors)
+	}
+
+	_, resp = postGraphQL(t, `{ search(query: "milk") { text } }`, nil)
+	if got := string(resp.Data["search"]); got != `[{"text":"Buy milk"}]` {
+		t.Errorf("search = %s, errors %+v", got, resp.Errors)
+	}
+
+	_, resp = postGraphQL(t, `mutation { updateTask(id: 3, version: 1, input: {text: "Buy oat milk"}) { text version } }`, nil)
+	if got := string(resp.Data["updateTask"]); got != `{"text":"Buy oat milk","version":2}` {
+		t.Errorf("updateTask = %s, errors %+v", got, resp.Errors)
+	}
+	_, resp = postGraphQL(t, `mutation { completeTask(id: 3) { completed completedBy } }`, nil)
+	if got := string(resp.Data["completeTask"]); got != `{"completed":true,"completedBy":"alice"}` {
+		t.Errorf("completeTask = %s, errors %+v", got, resp.Errors)
+	}
+	_, resp = postGraphQL(t, `mutation { deleteTask(id: 3) }`, nil)
+	if got := string(resp.Data["deleteTask"]); got != `true` {
+		t.Errorf("deleteTask = %s, errors %+v", got, resp.Errors)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	setupGraphQLTest(t)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantSlug  string
+		wantField string
+	}{
+		{"Syntax error", `{ tasks {`, http.StatusBadRequest, "invalid-query", ""},
+		{"Unknown field", `{ tasks { nope } }`, http.StatusBadRequest, "invalid-query", ""},
+		{"Too complex", `{ tasks(first: 1000) { tasks { id subtasks { id subtasks { id } } } } }`, http.StatusBadRequest, "query-too-complex", ""},
+		{"Negative first", `{ a: tasks(first: -100000) { tasks { id } } b: tasks(first: 1000) { tasks { id subtasks { id subtasks { id } } } } }`, http.StatusBadRequest, "query-too-complex", ""},
+		{"Not found", `{ task(id: 42) { id } }`, http.StatusOK, "not-found", ""},
+		{"Invalid ID", `{ task(id: 0) { id } }`, http.StatusOK, "invalid-id", ""},
+		{"Invalid filter", `{ tasks(filter: {status: "finished"}) { tasks { id } } }`, http.StatusOK, "invalid-parameter", ""},
+		{"Validation", `mutation { addTask(input: {text: "x", dueZone: "Mars/Base"}) { id } }`, http.StatusOK, "validation-failed", "/dueZone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := postGraphQL(t, tt.query, nil)
+			if code != tt.wantCode {
+				t.Errorf("status = %d, want %d", code, tt.wantCode)
+			}
+			if len(resp.Errors) == 0 {
+				t.Fatal("no errors")
+			}
+			ext := resp.Errors[0].Extensions
+			if ext["code"] != tt.wantSlug || ext["type"] != problemTypeBase+tt.wantSlug {
+				t.Errorf("extensions = %v, want code %q", ext, tt.wantSlug)
+			}
+			if tt.wantField != "" {
+				errs, _ := ext["errors"].([]any)
+				if len(errs) == 0 || errs[0].(map[string]any)["path"] != tt.wantField {
+					t.Errorf("field errors = %v, want %s", ext["errors"], tt.wantField)
+				}
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": ""}`))
+	rr := httptest.NewRecorder()
+	graphqlHandler(rr, req)
+	if rr.Code != http.StatusBadRequest || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("empty query: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestQueryComplexity(t *testing.T) {
+	setupGraphQLTest(t)
+	tests := []struct {
+		query     string
+		variables map[string]any
+		want      int
+	}{
+		{`{ task(id: 1) { id text } }`, nil, 3},
+		{`{ task(id: 1) { id subtasks { id } } }`, nil, 1 + 1 + (1 + 10*1)},
+		{`{ tasks { nextCursor tasks { id } } }`, nil, 1 + defaultPageSize*(1+1+1)},
+		{`query($n: Int) { tasks(first: $n) { tasks { ...f } } } fragment f on Task { id text }`, map[string]any{"n": float64(5)}, 1 + 5*(1+2)},
+		{`mutation { addTask(input: {text: "x"}) { id } }`, nil, 2},
+		{`{ __schema { types { name } } }`, nil, 0},
+		{`{ tasks(first: -100000) { tasks { id } } }`, nil, 1 + 1*(1+1)},
+		{`query($n: Int) { tasks(first: $n) { tasks { id } } }`, map[string]any{"n": float64(0)}, 1 + 1*(1+1)},
+		{`{ tasks(first: 5000) { tasks { id } } }`, nil, 1 + maxPageSize*(1+1)},
+		{`query($n: Int = 1000) { tasks(first: $n) { tasks { subtasks { id } } } }`, nil, 1 + 1000*(1+(1+10*1))},
+		{`query($n: Int = 5) { tasks(first: $n) { tasks { id } } }`, map[string]any{"n": float64(2)}, 1 + 2*(1+1)},
+		{`query($n: Int) { tasks(first: $n) { tasks { id } } }`, nil, 1 + maxPageSize*(1+1)},
+	}
+	for _, tt := range tests {
+		doc, err := parser.Parse(parser.ParseParams{Source: tt.query})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got := queryComplexity(&graphQL.schema, doc, findOperation(doc, ""), tt.variables); got != tt.want {
+			t.Errorf("queryComplexity(%s) = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLoadGraphQLConfig(t *testing.T) {
+	env := map[string]string{"TODO_GRAPHQL_MAX_COMPLEXITY": "200"}
+	cfg, err := loadGraphQLConfig(func(k string) string { return env[k] })
+	if err != nil || cfg.MaxComplexity != 200 {
+		t.Errorf("loadGraphQLConfig = %+v, %v", cfg, err)
+	}
+	env["TODO_GRAPHQL_MAX_COMPLEXITY"] = "0"
+	if _, err := loadGraphQLConfig(func(k string) string { return env[k] }); err == nil {
+		t.Error("loadGraphQLConfig accepted 0")
+	}
+}
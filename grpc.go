@@ -0,0 +1,466 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	todov1 "todo-otel/api/todo/v1"
+)
+
+// grpcServer implements the gRPC TodoService (api/todo/v1/todo.proto) on
+// the global store. Requests are converted to the HTTP API's inputs, so
+// both APIs validate them by the same rules.
+type grpcServer struct {
+	todov1.UnimplementedTodoServiceServer
+}
+
+// grpcAddr returns the gRPC listen address, TODO_GRPC_ADDR or :50051.
+func grpcAddr(getenv func(string) string) string {
+	if addr := getenv("TODO_GRPC_ADDR"); addr != "" {
+		return addr
+	}
+	return ":50051"
+}
+
+// newGRPCServer creates the gRPC server. otelgrpc traces every call, so its
+// spans join the same pipeline as the HTTP server's.
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			defer recordGRPCLatency(ctx, info.FullMethod, time.Now())
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			defer recordGRPCLatency(ss.Context(), info.FullMethod, time.Now())
+			return handler(srv, ss)
+		}),
+	)
+	todov1.RegisterTodoServiceServer(s, grpcServer{})
+	return s
+}
+
+// recordGRPCLatency records a call's duration like the HTTP handlers do.
+func recordGRPCLatency(ctx context.Context, fullMethod string, start time.Time) {
+	duration := time.Since(start).Milliseconds()
+	handlerLatency.Record(ctx, float64(duration), metric.WithAttributes(attribute.String("handler", "grpc_"+path.Base(fullMethod))))
+}
+
+// startGRPCServerAsync serves gRPC on addr in a goroutine.
+func startGRPCServerAsync(s *grpc.Server, addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal().Err(err).Str("addr", addr).Msg("gRPC server failed to listen")
+	}
+	go func() {
+		log.Info().Msgf("gRPC server starting on %s", addr)
+		if err := s.Serve(lis); err != nil {
+			log.Fatal().Err(err).Msg("gRPC server failed")
+		}
+	}()
+}
+
+// grpcClient attributes a call's store changes like withClient does for
+// HTTP requests, using the x-user and x-session-id metadata.
+func grpcClient(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	actor := first("x-user")
+	if actor == "" {
+		actor = "anonymous"
+	}
+	session := first("x-session-id")
+	if session == "" {
+		session = actor
+	}
+	return withSession(withActor(ctx, actor), session)
+}
+
+func (grpcServer) CreateTask(ctx context.Context, req *todov1.CreateTaskRequest) (*todov1.Task, error) {
+	ctx = grpcClient(ctx)
+	todo, err := taskFromInput(req.GetTask(), 0)
+	if err != nil {
+		return nil, grpcStoreError(ctx, "CreateTask", err)
+	}
+	added, err := store.Add(ctx, todo)
+	if err != nil {
+		return nil, grpcStoreError(ctx, "CreateTask", err)
+	}
+	taskCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("source", "grpc")))
+	countTagOperation(ctx, "add", added.Tags...)
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int("todo.id", added.ID))
+	logWithTrace(ctx).Str("event", "task_added").Int("todo_id", added.ID).Str("todo_text", added.Text).Msg("Added task")
+	return protoTask(added), nil
+}
+
+func (grpcServer) GetTask(ctx context.Context, req *todov1.GetTaskRequest) (*todov1.Task, error) {
+	id, err := grpcTaskID(ctx, "GetTask", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	todo, err := store.Get(ctx, id)
+	if err != nil {
+		return nil, grpcStoreError(ctx, "GetTask", err)
+	}
+	logWithTrace(ctx).Str("event", "get_task").Int("todo_id", id).Msg("Retrieved task")
+	return protoTask(todo), nil
+}
+
+func (grpcServer) ListTasks(ctx context.Context, req *todov1.ListTasksRequest) (*todov1.ListTasksResponse, error) {
+	query := filterQuery(req.GetFilter())
+	query.Set("sort", req.GetSort())
+	if req.GetPageSize() != 0 {
+		query.Set("limit", strconv.Itoa(int(req.GetPageSize())))
+	}
+	query.Set("cursor", req.GetPageToken())
+	filter, err := parseListFilter(query)
+	if err != nil {
+		return nil, grpcError(ctx, "ListTasks", problemInvalidParameter, err, nil)
+	}
+	page, err := parsePageRequest(query)
+	if err != nil {
+		return nil, grpcError(ctx, "ListTasks", problemInvalidParameter, err, nil)
+	}
+	todos, err := store.List(ctx, filter)
+	if err != nil {
+		return nil, grpcStoreError(ctx, "ListTasks", err)
+	}
+	todos, next := paginate(todos, page)
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int("todo.list.result_count", len(todos)), attribute.Bool("todo.list.has_more", next != ""))
+	logWithTrace(ctx).Str("event", "list_tasks").Int("count", len(todos)).Msg("Listed tasks")
+	return &todov1.ListTasksResponse{Tasks: protoTasks(todos), NextPageToken: next}, nil
+}
+
+func (grpcServer) UpdateTask(ctx context.Context, req *todov1.UpdateTaskRequest) (*todov1.Task, error) {
+	ctx = grpcClient(ctx)
+	id, err := grpcTaskID(ctx, "UpdateTask", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	todo, err := taskFromInput(req.GetTask(), id)
+	if err != nil {
+		return nil, grpcStoreError(ctx, "UpdateTask", err)
+	}
+	updated, err := store.Update(ctx, todo, int(req.GetVersion()))
+	if err != nil {
+		return nil, grpcStoreError(ctx, "UpdateTask", err)
+	}
+	logWithTrace(ctx).Str("event", "update_task").Int("todo_id", updated.ID).Str("todo_text", updated.Text).Msg("Updated task")
+	return protoTask(updated), nil
+}
+
+func (grpcServer) DeleteTask(ctx context.Context, req *todov1.DeleteTaskRequest) (*todov1.DeleteTaskResponse, error) {
+	ctx = grpcClient(ctx)
+	id, err := grpcTaskID(ctx, "DeleteTask", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Delete(ctx, id, int(req.GetVersion())); err != nil {
+		return nil, grpcStoreError(ctx, "DeleteTask", err)
+	}
+	logWithTrace(ctx).Str("event", "delete_task").Int("todo_id", id).Msg("Moved task to trash")
+	return &todov1.DeleteTaskResponse{}, nil
+}
+
+func (grpcServer) CompleteTask(ctx context.Context, req *todov1.CompleteTaskRequest) (*todov1.Task, error) {
+	ctx = grpcClient(ctx)
+	id, err := grpcTaskID(ctx, "CompleteTask", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	completed, err := store.Complete(ctx, id, actorFrom(ctx), req.GetForce(), int(req.GetVersion()))
+	if err != nil {
+		return nil, grpcStoreError(ctx, "CompleteTask", err)
+	}
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Bool("todo.complete.forced", req.GetForce()))
+	logWithTrace(ctx).Str("event", "complete_task").Int("todo_id", completed.ID).Str("actor", completed.CompletedBy).Msg("Completed task")
+	return protoTask(completed), nil
+}
+
+func (grpcServer) SearchTasks(ctx context.Context, req *todov1.SearchTasksRequest) (*todov1.SearchTasksResponse, error) {
+	if req.GetQuery() == "" {
+		return nil, grpcError(ctx, "SearchTasks", problemInvalidParameter, errors.New("query is required"), nil)
+	}
+	filter, err := parseListFilter(filterQuery(req.GetFilter()))
+	if err != nil {
+		return nil, grpcError(ctx, "SearchTasks", problemInvalidParameter, err, nil)
+	}
+	order, err := parseSortOrder(req.GetSort())
+	if err != nil {
+		return nil, grpcError(ctx, "SearchTasks", problemInvalidParameter, err, nil)
+	}
+	results, err := store.Search(ctx, req.GetQuery(), filter)
+	if err != nil {
+		return nil, grpcStoreError(ctx, "SearchTasks", err)
+	}
+	slices.SortFunc(results, order.compare)
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.String("search.query", req.GetQuery()), attribute.Int("search.results", len(results)))
+	logWithTrace(ctx).Str("event", "search_tasks").Str("query", req.GetQuery()).Int("count", len(results)).Msg("Searched tasks")
+	return &todov1.SearchTasksResponse{Tasks: protoTasks(results)}, nil
+}
+
+// WatchTasks streams the change feed until the client cancels or the
+// service shuts down, which ends the stream with Unavailable; the client
+// should then resume with the last event_id it received.
+func (grpcServer) WatchTasks(req *todov1.WatchTasksRequest, stream grpc.ServerStreamingServer[todov1.TaskEvent]) error {
+	ctx := stream.Context()
+	lastID := int64(-1)
+	if req.LastEventId != nil {
+		lastID = req.GetLastEventId()
+	}
+	sub, backlog, reset := changeFeed.subscribe(ctx, lastID)
+	defer changeFeed.unsubscribe(sub)
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int64("feed.last_event_id", lastID), attribute.Int("feed.backlog", len(backlog)), attribute.Bool("feed.reset", reset))
+	logWithTrace(ctx).Str("event", "feed_subscribe").Int64("last_event_id", lastID).Int("backlog", len(backlog)).Bool("reset", reset).Msg("gRPC watcher connected")
+
+	if reset {
+		if err := stream.Send(&todov1.TaskEvent{Type: "reset"}); err != nil {
+			return err
+		}
+	}
+	for _, e := range backlog {
+		if err := sendTaskEvent(ctx, stream, e); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.done:
+			return status.Error(codes.Unavailable, "event stream ended; resume with last_event_id")
+		case e := <-sub.events:
+			if err := sendTaskEvent(ctx, stream, e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendTaskEvent sends an event in a span linked to the one that made the change.
+func sendTaskEvent(ctx context.Context, stream grpc.ServerStreamingServer[todov1.TaskEvent], e feedEvent) error {
+	_, span := startEventSpan(ctx, "grpc.event", e)
+	defer span.End()
+	var payload feedPayload
+	if err := json.Unmarshal(e.Data, &payload); err != nil {
+		return err
+	}
+	event := &todov1.TaskEvent{
+		EventId: e.ID, Type: e.Type, TaskId: int64(payload.TaskID), Op: payload.Op, Actor: payload.Actor,
+		At: timestamppb.New(payload.At), TraceId: payload.TraceID, Traceparent: payload.Traceparent,
+	}
+	if payload.Task != nil {
+		event.Task = protoTask(*payload.Task)
+	}
+	return stream.Send(event)
+}
+
+// grpcTaskID validates the task ID of a request.
+func grpcTaskID(ctx context.Context, method string, id int64) (int, error) {
+	if id <= 0 {
+		return 0, grpcError(ctx, method, problemInvalidID, errors.New("id must be a positive integer"), nil)
+	}
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int("todo.id", int(id)))
+	return int(id), nil
+}
+
+// grpcStoreError reports a validation or Store error as the HTTP handlers
+// would, converted to a gRPC status.
+func grpcStoreError(ctx context.Context, method string, err error) error {
+	var invalid validationErrors
+	if errors.As(err, &invalid) {
+		return grpcError(ctx, method, problemValidation, err, invalid)
+	}
+	problem, fieldErrs := taskStoreProblem(err)
+	return grpcError(ctx, method, problem, err, fieldErrs)
+}
+
+// grpcError logs and counts a failed call like handleFieldErrors and
+// returns its status. The details name the problem type, and list field
+// errors as a BadRequest.
+func grpcError(ctx context.Context, method string, problem problemType, err error, fieldErrs []fieldError) error {
+	detail := recordProblem(ctx, "grpc_"+method, problem, err, fieldErrs)
+	if detail == "" {
+		detail = problem.title
+	}
+	st := status.New(grpcCode(problem), detail)
+	info := &errdetails.ErrorInfo{Reason: problem.slug, Domain: "todo-otel"}
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		info.Metadata = map[string]string{"trace_id": sc.TraceID().String()}
+	}
+	var badRequest *errdetails.BadRequest
+	if len(fieldErrs) > 0 {
+		badRequest = &errdetails.BadRequest{}
+		for _, fe := range fieldErrs {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field: fe.Path, Description: fe.Message, Reason: fe.Code,
+			})
+		}
+	}
+	withDetails, derr := st.WithDetails(info)
+	if derr == nil && badRequest != nil {
+		withDetails, derr = withDetails.WithDetails(badRequest)
+	}
+	if derr != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// grpcCode maps a problem's HTTP status onto the closest gRPC code.
+func grpcCode(problem problemType) codes.Code {
+	switch problem.status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusPreconditionFailed:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
+
+// taskFromInput validates a TaskInput as the body of POST /todos (id 0) or
+// PUT /todos/{id}. Unset fields are left out of the document, so they are
+// cleared by an update.
+func taskFromInput(in *todov1.TaskInput, id int) (ToDo, error) {
+	doc := map[string]any{"text": in.GetText()}
+	if in.GetDue() != nil {
+		doc["due"] = in.GetDue().AsTime().Format(time.RFC3339Nano)
+	}
+	for name, v := range map[string]string{"due_zone": in.GetDueZone(), "priority": in.GetPriority(), "recurrence": in.GetRecurrence()} {
+		if v != "" {
+			doc[name] = v
+		}
+	}
+	for name, v := range map[string]int64{"project": in.GetProject(), "parent": in.GetParent()} {
+		if v != 0 {
+			doc[name] = v
+		}
+	}
+	if len(in.GetTags()) > 0 {
+		doc["tags"] = in.GetTags()
+	}
+	if len(in.GetBlockedBy()) > 0 {
+		doc["blocked_by"] = in.GetBlockedBy()
+	}
+	return taskFromDocument(doc, id)
+}
+
+// filterQuery renders a TaskFilter as the query parameters of GET /todos,
+// so it is parsed by parseListFilter.
+func filterQuery(f *todov1.TaskFilter) url.Values {
+	query := url.Values{}
+	if f == nil {
+		return query
+	}
+	query.Set("status", f.GetStatus())
+	id := func(name string, v *int64) {
+		switch {
+		case v == nil:
+		case *v == 0:
+			query.Set(name, "none")
+		default:
+			query.Set(name, strconv.FormatInt(*v, 10))
+		}
+	}
+	id("project", f.Project)
+	id("parent", f.Parent)
+	flag := func(name string, v *bool) {
+		if v != nil {
+			query.Set(name, strconv.FormatBool(*v))
+		}
+	}
+	flag("overdue", f.Overdue)
+	flag("blocked", f.Blocked)
+	if f.GetDueBefore() != nil {
+		query.Set("due_before", f.GetDueBefore().AsTime().Format(time.RFC3339Nano))
+	}
+	if f.GetDueAfter() != nil {
+		query.Set("due_after", f.GetDueAfter().AsTime().Format(time.RFC3339Nano))
+	}
+	query.Set("tags_any", strings.Join(f.GetTagsAny(), ","))
+	query.Set("tags_all", strings.Join(f.GetTagsAll(), ","))
+	query.Set("tags_none", strings.Join(f.GetTagsNone(), ","))
+	return query
+}
+
+func protoTasks(todos []ToDo) []*todov1.Task {
+	tasks := make([]*todov1.Task, len(todos))
+	for i, todo := range todos {
+		tasks[i] = protoTask(todo)
+	}
+	return tasks
+}
+
+// protoTask converts a task to its protobuf form.
+func protoTask(todo ToDo) *todov1.Task {
+	timestamp := func(t *time.Time) *timestamppb.Timestamp {
+		if t == nil {
+			return nil
+		}
+		return timestamppb.New(*t)
+	}
+	task := &todov1.Task{
+		Id:             int64(todo.ID),
+		Text:           todo.Text,
+		Version:        int64(todo.Version),
+		CreatedAt:      timestamppb.New(todo.CreatedAt),
+		Completed:      todo.Completed,
+		CompletedAt:    timestamp(todo.CompletedAt),
+		CompletedBy:    todo.CompletedBy,
+		Due:            timestamp(todo.Due),
+		DueZone:        todo.DueZone,
+		Overdue:        todo.Overdue,
+		Priority:       todo.Priority,
+		Position:       todo.Position,
+		Tags:           todo.Tags,
+		Project:        int64(todo.Project),
+		Parent:         int64(todo.Parent),
+		Blocked:        todo.Blocked,
+		Recurrence:     todo.Recurrence,
+		Occurrence:     int64(todo.Occurrence),
+		NextOccurrence: int64(todo.NextOccurrence),
+		DeletedAt:      timestamp(todo.DeletedAt),
+	}
+	for _, id := range todo.BlockedBy {
+		task.BlockedBy = append(task.BlockedBy, int64(id))
+	}
+	if p := todo.Progress; p != nil {
+		task.Progress = &todov1.TaskProgress{Done: int64(p.Done), Total: int64(p.Total), Percent: int64(p.Percent)}
+	}
+	return task
+}
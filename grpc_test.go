@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	todov1 "todo-otel/api/todo/v1"
+)
+
+// dialGRPC serves the TodoService in memory and returns a client for it.
+func dialGRPC(t *testing.T) todov1.TodoServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return todov1.NewTodoServiceClient(conn)
+}
+
+func TestGRPCService(t *testing.T) {
+	setupTest()
+	client := dialGRPC(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-user", "alice")
+
+	created, err := client.CreateTask(ctx, &todov1.CreateTaskRequest{Task: &todov1.TaskInput{Text: "Write the report", Priority: "P1", Tags: []string{"work"}}})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	if created.GetId() == 0 || created.GetPriority() != "P1" || len(created.GetTags()) != 1 || created.GetCreatedAt() == nil {
+		t.Fatalf("CreateTask = %+v", created)
+	}
+	if _, err := client.CreateTask(ctx, &todov1.CreateTaskRequest{Task: &todov1.TaskInput{Text: "Buy milk"}}); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	got, err := client.GetTask(ctx, &todov1.GetTaskRequest{Id: created.GetId()})
+	if err != nil || got.GetText() != "Write the report" {
+		t.Fatalf("GetTask = %+v, %v", got, err)
+	}
+
+	updated, err := client.UpdateTask(ctx, &todov1.UpdateTaskRequest{Id: created.GetId(), Version: created.GetVersion(), Task: &todov1.TaskInput{Text: "Write the final report"}})
+	if err != nil || updated.GetText() != "Write the final report" || updated.GetPriority() != "" {
+		t.Fatalf("UpdateTask = %+v, %v", updated, err)
+	}
+	_, err = client.UpdateTask(ctx, &todov1.UpdateTaskRequest{Id: created.GetId(), Version: created.GetVersion(), Task: &todov1.TaskInput{Text: "Stale"}})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("UpdateTask with a stale version: got %v, want Aborted", err)
+	}
+
+	completed, err := client.CompleteTask(ctx, &todov1.CompleteTaskRequest{Id: created.GetId()})
+	if err != nil || !completed.GetCompleted() || completed.GetCompletedBy() != "alice" {
+		t.Fatalf("CompleteTask = %+v, %v", completed, err)
+	}
+
+	list, err := client.ListTasks(ctx, &todov1.ListTasksRequest{Filter: &todov1.TaskFilter{Status: "open"}})
+	if err != nil || len(list.GetTasks()) != 1 || list.GetTasks()[0].GetText() != "Buy milk" {
+		t.Fatalf("ListTasks = %+v, %v", list, err)
+	}
+	page, err := client.ListTasks(ctx, &todov1.ListTasksRequest{PageSize: 1})
+	if err != nil || len(page.GetTasks()) != 1 || page.GetNextPageToken() == "" {
+		t.Fatalf("ListTasks page = %+v, %v", page, err)
+	}
+	rest, err := client.ListTasks(ctx, &todov1.ListTasksRequest{PageSize: 1, PageToken: page.GetNextPageToken()})
+	if err != nil || len(rest.GetTasks()) != 1 || rest.GetTasks()[0].GetId() == page.GetTasks()[0].GetId() {
+		t.Fatalf("ListTasks next page = %+v, %v", rest, err)
+	}
+
+	found, err := client.SearchTasks(ctx, &todov1.SearchTasksRequest{Query: "report"})
+	if err != nil || len(found.GetTasks()) != 1 {
+		t.Fatalf("SearchTasks = %+v, %v", found, err)
+	}
+
+	if _, err := client.DeleteTask(ctx, &todov1.DeleteTaskRequest{Id: created.GetId()}); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	_, err = client.GetTask(ctx, &todov1.GetTaskRequest{Id: created.GetId()})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetTask after delete: got %v, want NotFound", err)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	setupTest()
+	client := dialGRPC(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.CreateTask(ctx, &todov1.CreateTaskRequest{Task: &todov1.TaskInput{Priority: "urgent"}})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("CreateTask with invalid fields: got %v, want InvalidArgument", err)
+	}
+	var reason string
+	fields := map[string]bool{}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.GetReason()
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				fields[v.GetField()] = true
+			}
+		}
+	}
+	if reason != problemValidation.slug || !fields["/text"] || !fields["/priority"] {
+		t.Errorf("details: reason %q, fields %v", reason, fields)
+	}
+
+	for name, call := range map[string]func() error{
+		"GetTask with id 0": func() error {
+			_, err := client.GetTask(ctx, &todov1.GetTaskRequest{})
+			return err
+		},
+		"ListTasks with a bad filter": func() error {
+			_, err := client.ListTasks(ctx, &todov1.ListTasksRequest{Filter: &todov1.TaskFilter{Status: "finished"}})
+			return err
+		},
+		"SearchTasks without a query": func() error {
+			_, err := client.SearchTasks(ctx, &todov1.SearchTasksRequest{})
+			return err
+		},
+	} {
+		if code := status.Code(call()); code != codes.InvalidArgument {
+			t.Errorf("%s: got %v, want InvalidArgument", name, code)
+		}
+	}
+}
+
+func TestGRPCWatchTasks(t *testing.T) {
+	setupTest()
+	client := dialGRPC(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before, err := client.CreateTask(ctx, &todov1.CreateTaskRequest{Task: &todov1.TaskInput{Text: "Before"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastID := int64(0)
+	stream, err := client.WatchTasks(ctx, &todov1.WatchTasksRequest{LastEventId: &lastID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil || event.GetType() != "add" || event.GetTask().GetId() != before.GetId() || event.GetEventId() != 1 {
+		t.Fatalf("backlog event = %+v, %v", event, err)
+	}
+
+	if _, err := client.CompleteTask(ctx, &todov1.CompleteTaskRequest{Id: before.GetId()}); err != nil {
+		t.Fatal(err)
+	}
+	event, err = stream.Recv()
+	if err != nil || event.GetType() != "complete" || event.GetOp() != "complete" || !event.GetTask().GetCompleted() {
+		t.Fatalf("live event = %+v, %v", event, err)
+	}
+
+	changeFeed.close()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Recv after the feed closed: got %v, want Unavailable", err)
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
-// Note: These handlers assume global variables 'store', 'handlerLatency', 'errorCounter', 'taskCounter'
+// Note: These handlers assume global variables 'store', 'handlerLatency', 'errorCounter', 'taskCounter', 'tagCounter'
 // and functions 'logWithTrace', 'handleError', 'contains' are accessible within the 'main' package.
+// Errors are reported as application/problem+json using the catalogue in problems.go.
 
 func addHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
@@ -24,6 +31,7 @@ func addHandler(w http.ResponseWriter, r *http.Request) {
 	tr := otel.Tracer("todo-service")
 	ctx, span := tr.Start(ctx, "addHandler")
 	defer span.End()
+	ctx = withClient(ctx, r) // Recorded in the task history and undo log
 
 	span.SetAttributes(
 		attribute.String("http.method", r.Method),
@@ -31,19 +39,25 @@ func addHandler(w http.ResponseWriter, r *http.Request) {
 		attribute.String("http.client_ip", r.RemoteAddr),
 	)
 
-	var todo ToDo
-	if err := json.NewDecoder(r.Body).Decode(&todo); err != nil {
-		handleError(ctx, w, http.StatusBadRequest, "Invalid JSON", err)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "add")))
+	todo, err := decodeTaskInput(w, r, 0)
+	if err != nil {
+		handleBodyError(ctx, w, r, "add", err)
 		return
 	}
-	span.SetAttributes(attribute.String("todo.text", todo.Text))
+	span.SetAttributes(attribute.String("todo.text", todo.Text), attribute.StringSlice("todo.tags", todo.Tags))
+	setProjectAttribute(span, todo.Project)
 
 	// Using the global store instance
-	added := store.Add(todo)
+	added, err := store.Add(ctx, todo)
+	if err != nil {
+		handleTaskStoreError(ctx, w, r, "add", err)
+		return
+	}
 	taskCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("source", "http")))
+	countTagOperation(ctx, "add", added.Tags...)
 
 	logWithTrace(ctx).Str("event", "task_added").Int("todo_id", added.ID).Str("todo_text", added.Text).Msg("Added task")
+	setETag(w, added)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated) // Use 201 Created for successful additions
 	json.NewEncoder(w).Encode(added)
@@ -60,10 +74,41 @@ func listHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, span := tr.Start(ctx, "listHandler")
 	defer span.End()
 
+	status := r.URL.Query().Get("status")
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		handleError(ctx, w, r, "list", problemInvalidParameter, err)
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		handleError(ctx, w, r, "list", problemInvalidParameter, err)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("todo.list.status", status),
+		attribute.Bool("todo.list.overdue_filter", filter.Overdue != nil),
+		attribute.Bool("todo.list.due_filter", filter.DueBefore != nil || filter.DueAfter != nil),
+		attribute.String("todo.list.sort", page.order.String()),
+		attribute.Int("todo.list.page_size", page.limit),
+		attribute.Bool("todo.list.has_cursor", page.after != nil),
+	)
+	setTagFilterAttributes(span, "todo.list", filter)
+	if filter.Project != nil {
+		setProjectAttribute(span, *filter.Project)
+	}
+
 	// Using the global store instance
-	todos := store.List()
+	todos, err := store.List(ctx, filter)
+	if err != nil {
+		handleError(ctx, w, r, "list", storeProblem(err), err)
+		return
+	}
+	todos, next := paginate(todos, page)
 
-	logWithTrace(ctx).Str("event", "list_tasks").Int("count", len(todos)).Msg("Listed tasks")
+	span.SetAttributes(attribute.Int("todo.list.result_count", len(todos)), attribute.Bool("todo.list.has_more", next != ""))
+	logWithTrace(ctx).Str("event", "list_tasks").Str("status", status).Int("count", len(todos)).Msg("Listed tasks")
+	setPageLinks(w, r, next)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(todos)
 }
@@ -78,28 +123,32 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	tr := otel.Tracer("todo-service")
 	ctx, span := tr.Start(ctx, "deleteHandler")
 	defer span.End()
+	ctx = withClient(ctx, r)
 
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := todoID(r)
 	if err != nil {
 		span.SetAttributes(attribute.String("todo.delete.error", "invalid id"))
-		handleError(ctx, w, http.StatusBadRequest, "Invalid ID", err)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "delete")))
+		handleError(ctx, w, r, "delete", problemInvalidID, err)
 		return
 	}
 	span.SetAttributes(attribute.Int("todo.id", id))
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "delete", storeProblem(err), err)
+		return
+	}
 
 	// Using the global store instance
-	deleted := store.Delete(id)
-
-	if deleted {
-		logWithTrace(ctx).Str("event", "delete_task").Int("todo_id", id).Msg("Deleted task")
-		w.WriteHeader(http.StatusNoContent)
-	} else {
-		span.SetAttributes(attribute.String("todo.delete.status", "not found"))
-		handleError(ctx, w, http.StatusNotFound, "ToDo not found", nil) // Use handleError
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "delete")))
+	if err := store.Delete(ctx, id, ifVersion); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			span.SetAttributes(attribute.String("todo.delete.status", "not found"))
+		}
+		handleError(ctx, w, r, "delete", storeProblem(err), err)
+		return
 	}
+
+	logWithTrace(ctx).Str("event", "delete_task").Int("todo_id", id).Msg("Moved task to trash")
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func updateHandler(w http.ResponseWriter, r *http.Request) {
@@ -113,134 +162,1057 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 	tr := otel.Tracer("todo-service")
 	ctx, span := tr.Start(ctx, "updateHandler")
 	defer span.End()
+	ctx = withClient(ctx, r)
 
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := todoID(r)
 	if err != nil {
-		handleError(ctx, w, http.StatusBadRequest, "Invalid ID", err)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "update")))
+		handleError(ctx, w, r, "update", problemInvalidID, err)
 		return
 	}
 
-	var todo ToDo
-	if err := json.NewDecoder(r.Body).Decode(&todo); err != nil {
-		handleError(ctx, w, http.StatusBadRequest, "Invalid request body", err)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "update")))
+	todo, err := decodeTaskInput(w, r, id)
+	if err != nil {
+		handleBodyError(ctx, w, r, "update", err)
 		return
 	}
+	setProjectAttribute(span, todo.Project)
 
-	// Using the global store instance
-	updated, exists := store.Update(id, todo.Text)
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "update", storeProblem(err), err)
+		return
+	}
 
-	if !exists {
-		handleError(ctx, w, http.StatusNotFound, "ToDo not found", nil)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "update")))
+	// Using the global store instance
+	updated, err := store.Update(ctx, todo, ifVersion)
+	if err != nil {
+		handleTaskStoreError(ctx, w, r, "update", err)
 		return
 	}
 
 	span.SetAttributes(attribute.String("todo.text", updated.Text), attribute.Int("todo.id", updated.ID))
 	logWithTrace(ctx).Str("event", "update_task").Int("todo_id", updated.ID).Str("todo_text", updated.Text).Msg("Updated task")
+	setETag(w, updated)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updated)
 }
 
-func getHandler(w http.ResponseWriter, r *http.Request) {
+func patchHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
 		duration := time.Since(start).Milliseconds()
-		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "get")))
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "patch")))
 	}()
 
 	ctx := r.Context()
 	tr := otel.Tracer("todo-service")
-	ctx, span := tr.Start(ctx, "getHandler")
+	ctx, span := tr.Start(ctx, "patchHandler")
 	defer span.End()
+	ctx = withClient(ctx, r)
 
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "patch", problemInvalidID, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.id", id))
+
+	// Plain application/json is treated as a merge patch, matching what PUT-style clients send
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var apply func(doc, patch []byte) ([]byte, error)
+	switch mediaType {
+	case mergePatchMediaType, "application/json":
+		apply = applyMergePatch
+	case jsonPatchMediaType:
+		apply = applyJSONPatch
+	default:
+		w.Header().Set("Accept-Patch", acceptPatch)
+		handleError(ctx, w, r, "patch", problemUnsupportedMediaType, fmt.Errorf("unsupported patch format %q", mediaType))
+		return
+	}
+	span.SetAttributes(attribute.String("todo.patch.format", mediaType))
+
+	patch, err := readBody(w, r)
+	if err != nil {
+		handleBodyError(ctx, w, r, "patch", err)
+		return
+	}
+
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "patch", storeProblem(err), err)
+		return
+	}
+
+	// Using the global store instance
+	updated, err := patchTodo(ctx, id, ifVersion, apply, patch, requestActor(r))
+	var opErr fieldError
+	var invalid validationErrors
+	switch {
+	case errors.As(err, &opErr):
+		// RFC 5789: the patch is well-formed but conflicts with the current state
+		handleFieldErrors(ctx, w, r, "patch", problemPatchConflict, err, []fieldError{opErr})
+		return
+	case errors.As(err, &invalid):
+		handleFieldErrors(ctx, w, r, "patch", problemValidation, nil, invalid)
+		return
+	case errors.Is(err, errMalformedPatch):
+		handleError(ctx, w, r, "patch", problemMalformedPatch, err)
+		return
+	case err != nil:
+		handleTaskStoreError(ctx, w, r, "patch", err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("todo.text", updated.Text), attribute.Int("todo.version", updated.Version))
+	setProjectAttribute(span, updated.Project)
+	logWithTrace(ctx).Str("event", "patch_task").Int("todo_id", updated.ID).Str("format", mediaType).Int("version", updated.Version).Msg("Patched task")
+	setETag(w, updated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func moveHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "move")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "moveHandler")
+	defer span.End()
+	ctx = withClient(ctx, r)
+
+	id, err := todoID(r)
 	if err != nil {
-		handleError(ctx, w, http.StatusBadRequest, "Invalid ID format", err)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "get")))
+		handleError(ctx, w, r, "move", problemInvalidID, err)
 		return
 	}
 	span.SetAttributes(attribute.Int("todo.id", id))
 
+	var in moveInput
+	if err := decodeJSONBody(w, r, &in); err != nil {
+		handleBodyError(ctx, w, r, "move", err)
+		return
+	}
+	anchor, before, err := in.anchor(id)
+	if err != nil {
+		handleBodyError(ctx, w, r, "move", err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.move.anchor", anchor), attribute.Bool("todo.move.before", before))
+
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "move", storeProblem(err), err)
+		return
+	}
+
 	// Using the global store instance
-	todo, exists := store.Get(id)
+	moved, err := store.Move(ctx, id, anchor, before, ifVersion)
+	if err != nil {
+		handleError(ctx, w, r, "move", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("todo.position", moved.Position))
+	logWithTrace(ctx).Str("event", "move_task").Int("todo_id", moved.ID).Int("anchor_id", anchor).Bool("before", before).Str("position", moved.Position).Msg("Moved task")
+	setETag(w, moved)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(moved)
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "history")))
+	}()
 
-	if !exists {
-		handleError(ctx, w, http.StatusNotFound, "ToDo not found", nil)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "get")))
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "historyHandler")
+	defer span.End()
+
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "history", problemInvalidID, err)
 		return
 	}
+	span.SetAttributes(attribute.Int("todo.id", id))
 
-	span.SetAttributes(attribute.String("todo.text", todo.Text))
-	logWithTrace(ctx).Str("event", "get_task").Int("todo_id", todo.ID).Str("todo_text", todo.Text).Msg("Retrieved task")
+	// Using the global store instance
+	entries, err := store.History(ctx, id)
+	if err != nil {
+		handleError(ctx, w, r, "history", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("todo.history.entries", len(entries)))
+	logWithTrace(ctx).Str("event", "task_history").Int("todo_id", id).Int("count", len(entries)).Msg("Read task history")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(todo)
+	json.NewEncoder(w).Encode(entries)
 }
 
-func completeHandler(w http.ResponseWriter, r *http.Request) {
+// eventsHandler streams task changes as Server-Sent Events. A reconnecting
+// client's Last-Event-ID header resumes the stream from the feed's buffer.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	_, span := tr.Start(ctx, "eventsHandler")
+
+	lastID, invalid := int64(-1), false
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			invalid = true // Not one of ours, so the client's state is unknown
+		} else {
+			lastID = id
+		}
+	}
+	sub, backlog, reset := changeFeed.subscribe(ctx, lastID)
+	defer changeFeed.unsubscribe(sub)
+	reset = reset || invalid
+
+	// The stream outlives the handler span; each event is sent in a span of its own.
+	span.SetAttributes(attribute.Int64("feed.last_event_id", lastID), attribute.Int("feed.backlog", len(backlog)), attribute.Bool("feed.reset", reset))
+	logWithTrace(ctx).Str("event", "feed_subscribe").Int64("last_event_id", lastID).Int("backlog", len(backlog)).Bool("reset", reset).Msg("Feed subscriber connected")
+	span.End()
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{}) // The server's write timeout would cut the stream
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		// Events were missed; the client has to reload its state
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range backlog {
+		if err := writeEvent(ctx, w, e); err != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(changeFeed.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.done:
+			return // Dropped or shutting down; the client reconnects with Last-Event-ID
+		case e := <-sub.events:
+			if err := writeEvent(ctx, w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
 		duration := time.Since(start).Milliseconds()
-		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "complete")))
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "restore")))
 	}()
 
 	ctx := r.Context()
 	tr := otel.Tracer("todo-service")
-	ctx, span := tr.Start(ctx, "completeHandler")
+	ctx, span := tr.Start(ctx, "restoreHandler")
 	defer span.End()
+	ctx = withClient(ctx, r)
 
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := todoID(r)
 	if err != nil {
-		handleError(ctx, w, http.StatusBadRequest, "Invalid ID", err)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "complete")))
+		handleError(ctx, w, r, "restore", problemInvalidID, err)
 		return
 	}
 	span.SetAttributes(attribute.Int("todo.id", id))
 
+	var in restoreInput
+	if err := decodeJSONBody(w, r, &in); err != nil {
+		handleBodyError(ctx, w, r, "restore", err)
+		return
+	}
+	version, err := in.version()
+	if err != nil {
+		handleBodyError(ctx, w, r, "restore", err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.restore.version", version))
+
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "restore", storeProblem(err), err)
+		return
+	}
+
 	// Using the global store instance
-	completedTodo, exists := store.Complete(id)
+	restored, err := store.Restore(ctx, id, version, ifVersion)
+	if err != nil {
+		handleTaskStoreError(ctx, w, r, "restore", err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("todo.version", restored.Version))
+	logWithTrace(ctx).Str("event", "restore_task").Int("todo_id", restored.ID).Int("from_version", version).Int("version", restored.Version).Msg("Restored task")
+	setETag(w, restored)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
+
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "trash")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "trashHandler")
+	defer span.End()
 
-	if !exists {
-		handleError(ctx, w, http.StatusNotFound, "ToDo not found", nil)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "complete")))
+	// Using the global store instance
+	todos, err := store.Trash(ctx)
+	if err != nil {
+		handleError(ctx, w, r, "trash", storeProblem(err), err)
 		return
 	}
 
-	span.SetAttributes(attribute.String("todo.text", completedTodo.Text), attribute.Bool("todo.completed", completedTodo.Completed))
-	logWithTrace(ctx).Str("event", "complete_task").Int("todo_id", completedTodo.ID).Msg("Completed task")
+	span.SetAttributes(attribute.Int("todo.trash.result_count", len(todos)))
+	logWithTrace(ctx).Str("event", "list_trash").Int("count", len(todos)).Msg("Listed trash")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(completedTodo)
+	json.NewEncoder(w).Encode(todos)
 }
 
-func searchHandler(w http.ResponseWriter, r *http.Request) {
+func untrashHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
 		duration := time.Since(start).Milliseconds()
-		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "search")))
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "untrash")))
 	}()
 
 	ctx := r.Context()
 	tr := otel.Tracer("todo-service")
-	ctx, span := tr.Start(ctx, "searchHandler")
+	ctx, span := tr.Start(ctx, "untrashHandler")
 	defer span.End()
+	ctx = withClient(ctx, r)
 
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		handleError(ctx, w, http.StatusBadRequest, "Query parameter 'q' is required", nil)
-		errorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("handler", "search")))
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "untrash", problemInvalidID, err)
 		return
 	}
-	span.SetAttributes(attribute.String("search.query", query))
+	span.SetAttributes(attribute.Int("todo.id", id))
 
 	// Using the global store instance
-	results := store.Search(query)
+	restored, err := store.Untrash(ctx, id)
+	if err != nil {
+		handleError(ctx, w, r, "untrash", storeProblem(err), err)
+		return
+	}
 
-	span.SetAttributes(attribute.Int("search.results", len(results)))
-	logWithTrace(ctx).Str("event", "search_tasks").Str("query", query).Int("count", len(results)).Msg("Searched tasks")
+	logWithTrace(ctx).Str("event", "untrash_task").Int("todo_id", restored.ID).Msg("Restored task from trash")
+	setETag(w, restored)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
+
+// undoHandler reverses the session's most recent changes (?steps=N, default 1).
+func undoHandler(w http.ResponseWriter, r *http.Request) {
+	replayHandler(w, r, "undo", undos.undo)
+}
+
+// redoHandler reapplies the session's most recently undone changes.
+func redoHandler(w http.ResponseWriter, r *http.Request) {
+	replayHandler(w, r, "redo", undos.redo)
+}
+
+// replayProblem is the problem body of a failed undo or redo; Applied lists
+// the steps replayed before it failed.
+type replayProblem struct {
+	problemDetails
+	Applied []undoResult `json:"applied,omitempty"`
+}
+
+func replayHandler(w http.ResponseWriter, r *http.Request, handler string, replay func(context.Context, string, int) ([]undoResult, error)) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", handler)))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, handler+"Handler")
+	defer span.End()
+	ctx = withActor(ctx, requestActor(r))
+	session := requestSession(r)
+
+	steps := 1
+	if v := r.URL.Query().Get("steps"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			handleError(ctx, w, r, handler, problemInvalidParameter, fmt.Errorf("steps must be a positive integer, got %q", v))
+			return
+		}
+		steps = n
+	}
+	span.SetAttributes(attribute.Int("undo.steps", steps))
+
+	results, err := replay(ctx, session, steps)
+	span.SetAttributes(attribute.Int("undo.applied", len(results)))
+	if err != nil {
+		// The steps replayed before a conflict stay applied, so report them too
+		problem := storeProblem(err)
+		detail := recordProblem(ctx, handler, problem, err, nil)
+		writeProblemBody(w, problem.status, replayProblem{newProblemDetails(ctx, problem, detail, r.URL.RequestURI(), nil), results})
+		return
+	}
+
+	logWithTrace(ctx).Str("event", handler+"_tasks").Str("session", session).Int("count", len(results)).Msg("Replayed session changes")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
+
+func getHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "get")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "getHandler")
+	defer span.End()
+
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "get", problemInvalidID, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.id", id))
+
+	expand := r.URL.Query().Get("expand")
+	switch expand {
+	case "":
+	case "children":
+		writeTaskTree(ctx, w, r, span, id)
+		return
+	default:
+		handleError(ctx, w, r, "get", problemInvalidParameter, fmt.Errorf("unknown expand %q, expected children", expand))
+		return
+	}
+
+	// Using the global store instance
+	todo, err := store.Get(ctx, id)
+	if err != nil {
+		handleError(ctx, w, r, "get", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("todo.text", todo.Text), attribute.Int("todo.version", todo.Version))
+	setProjectAttribute(span, todo.Project)
+	setETag(w, todo)
+	w.Header().Set("Accept-Patch", acceptPatch)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag(todo), true) {
+		span.SetAttributes(attribute.Bool("http.not_modified", true))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	logWithTrace(ctx).Str("event", "get_task").Int("todo_id", todo.ID).Str("todo_text", todo.Text).Msg("Retrieved task")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todo)
+}
+
+// writeTaskTree serves GET /todos/{id}?expand=children: the task with all
+// of its subtasks nested under children. The ETag is still the task's own,
+// so a tree is never answered with 304.
+func writeTaskTree(ctx context.Context, w http.ResponseWriter, r *http.Request, span oteltrace.Span, id int) {
+	// Using the global store instance
+	tree, err := store.Tree(ctx, id)
+	if err != nil {
+		handleError(ctx, w, r, "get", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("todo.expand", "children"), attribute.Int("todo.children", len(tree.Children)))
+	setProjectAttribute(span, tree.Project)
+	logWithTrace(ctx).Str("event", "get_task_tree").Int("todo_id", tree.ID).Int("children", len(tree.Children)).Msg("Retrieved task tree")
+	setETag(w, tree.ToDo)
+	w.Header().Set("Accept-Patch", acceptPatch)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func completeHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "complete")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "completeHandler")
+	defer span.End()
+	ctx = withClient(ctx, r)
+
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "complete", problemInvalidID, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.id", id))
+
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		if force, err = strconv.ParseBool(v); err != nil {
+			handleError(ctx, w, r, "complete", problemInvalidParameter, fmt.Errorf("force must be true or false, got %q", v))
+			return
+		}
+	}
+	span.SetAttributes(attribute.Bool("todo.complete.forced", force))
+
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "complete", storeProblem(err), err)
+		return
+	}
+
+	// Using the global store instance
+	actor := requestActor(r)
+	completedTodo, err := store.Complete(ctx, id, actor, force, ifVersion)
+	if err != nil {
+		handleError(ctx, w, r, "complete", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("todo.text", completedTodo.Text), attribute.Bool("todo.completed", completedTodo.Completed), attribute.Bool("todo.blocked", completedTodo.Blocked))
+	logWithTrace(ctx).Str("event", "complete_task").Int("todo_id", completedTodo.ID).Str("actor", completedTodo.CompletedBy).Msg("Completed task")
+	if completedTodo.NextOccurrence != 0 {
+		span.SetAttributes(attribute.Int("todo.next_occurrence", completedTodo.NextOccurrence))
+		logWithTrace(ctx).Str("event", "recur_task").Int("todo_id", completedTodo.ID).Int("next_id", completedTodo.NextOccurrence).Msg("Created next occurrence")
+	}
+	setETag(w, completedTodo)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completedTodo)
+}
+
+func uncompleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "uncomplete")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "uncompleteHandler")
+	defer span.End()
+	ctx = withClient(ctx, r)
+
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "uncomplete", problemInvalidID, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.id", id))
+
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "uncomplete", storeProblem(err), err)
+		return
+	}
+
+	// Using the global store instance
+	todo, err := store.Uncomplete(ctx, id, ifVersion)
+	if err != nil {
+		handleError(ctx, w, r, "uncomplete", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("todo.text", todo.Text), attribute.Bool("todo.completed", todo.Completed))
+	logWithTrace(ctx).Str("event", "uncomplete_task").Int("todo_id", todo.ID).Str("actor", requestActor(r)).Msg("Reopened task")
+	setETag(w, todo)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todo)
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "search")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "searchHandler")
+	defer span.End()
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		handleError(ctx, w, r, "search", problemInvalidParameter, errors.New("query parameter 'q' is required"))
+		return
+	}
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		handleError(ctx, w, r, "search", problemInvalidParameter, err)
+		return
+	}
+	order, err := parseSortOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		handleError(ctx, w, r, "search", problemInvalidParameter, err)
+		return
+	}
+	span.SetAttributes(attribute.String("search.query", query), attribute.String("search.sort", order.String()))
+	setTagFilterAttributes(span, "search", filter)
+	if filter.Project != nil {
+		setProjectAttribute(span, *filter.Project)
+	}
+
+	// Using the global store instance
+	results, err := store.Search(ctx, query, filter)
+	if err != nil {
+		handleError(ctx, w, r, "search", storeProblem(err), err)
+		return
+	}
+
+	slices.SortFunc(results, order.compare)
+
+	span.SetAttributes(attribute.Int("search.results", len(results)))
+	logWithTrace(ctx).Str("event", "search_tasks").Str("query", query).Int("count", len(results)).Msg("Searched tasks")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// defaultNextOrder breaks ties between tasks that are equally ready in GET /todos/next.
+const defaultNextOrder = "priority,due,position"
+
+func nextHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "next")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "nextHandler")
+	defer span.End()
+
+	query := r.URL.Query()
+	filter, err := parseListFilter(query)
+	if err != nil {
+		handleError(ctx, w, r, "next", problemInvalidParameter, err)
+		return
+	}
+	open := false
+	filter.Completed = &open
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = defaultNextOrder
+	}
+	order, err := parseSortOrder(sort)
+	if err != nil {
+		handleError(ctx, w, r, "next", problemInvalidParameter, err)
+		return
+	}
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			handleError(ctx, w, r, "next", problemInvalidParameter, fmt.Errorf("limit must be a positive integer, got %q", v))
+			return
+		}
+	}
+	span.SetAttributes(attribute.String("todo.next.sort", order.String()), attribute.Int("todo.next.limit", limit))
+	setTagFilterAttributes(span, "todo.next", filter)
+	if filter.Project != nil {
+		setProjectAttribute(span, *filter.Project)
+	}
+
+	// Using the global store instance
+	todos, err := store.List(ctx, filter)
+	if err != nil {
+		handleError(ctx, w, r, "next", storeProblem(err), err)
+		return
+	}
+	todos = topoSort(todos, order.compare)
+	ready := 0
+	for _, todo := range todos {
+		if !todo.Blocked {
+			ready++
+		}
+	}
+	if limit > 0 && len(todos) > limit {
+		todos = todos[:limit]
+	}
+
+	span.SetAttributes(attribute.Int("todo.next.result_count", len(todos)), attribute.Int("todo.next.ready", ready))
+	logWithTrace(ctx).Str("event", "next_tasks").Int("count", len(todos)).Int("ready", ready).Msg("Listed next tasks")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todos)
+}
+
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "tag")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "tagHandler")
+	defer span.End()
+	ctx = withClient(ctx, r)
+
+	// PUT adds the tag, DELETE removes it
+	operation := "add"
+	if r.Method == http.MethodDelete {
+		operation = "remove"
+	}
+	span.SetAttributes(attribute.String("todo.tag.operation", operation))
+
+	id, err := todoID(r)
+	if err != nil {
+		handleError(ctx, w, r, "tag", problemInvalidID, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("todo.id", id))
+
+	tag, ferr := normalizeTag("tag", r.PathValue("tag"))
+	if ferr != nil {
+		handleError(ctx, w, r, "tag", problemInvalidParameter, fmt.Errorf("tag %q %s", r.PathValue("tag"), ferr.Message))
+		return
+	}
+	span.SetAttributes(attribute.String("todo.tag", tag))
+
+	ifVersion, err := ifMatchVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "tag", storeProblem(err), err)
+		return
+	}
+
+	// Using the global store instance
+	var todo ToDo
+	maxTags := validation.Fields["tags"].MaxItems
+	if operation == "add" {
+		todo, err = store.AddTag(ctx, id, tag, maxTags, ifVersion)
+	} else {
+		todo, err = store.RemoveTag(ctx, id, tag, ifVersion)
+	}
+	if errors.Is(err, ErrTooManyTags) {
+		handleFieldErrors(ctx, w, r, "tag", problemValidation, nil, []fieldError{{Path: "/tags", Message: fmt.Sprintf("must have at most %d tags", maxTags), Code: "too_many"}})
+		return
+	}
+	if err != nil {
+		handleError(ctx, w, r, "tag", storeProblem(err), err)
+		return
+	}
+	countTagOperation(ctx, operation, tag)
+
+	span.SetAttributes(attribute.StringSlice("todo.tags", todo.Tags), attribute.Int("todo.version", todo.Version))
+	logWithTrace(ctx).Str("event", operation+"_tag").Int("todo_id", todo.ID).Str("tag", tag).Msg("Updated task tags")
+	setETag(w, todo)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todo)
+}
+
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "tags")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "tagsHandler")
+	defer span.End()
+
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		handleError(ctx, w, r, "tags", problemInvalidParameter, err)
+		return
+	}
+	setTagFilterAttributes(span, "todo.tags", filter)
+	if filter.Project != nil {
+		setProjectAttribute(span, *filter.Project)
+	}
+
+	// Using the global store instance
+	tags, err := store.Tags(ctx, filter)
+	if err != nil {
+		handleError(ctx, w, r, "tags", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("todo.tags.count", len(tags)))
+	logWithTrace(ctx).Str("event", "list_tags").Int("count", len(tags)).Msg("Listed tags")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func projectsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "projects")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "projectsHandler")
+	defer span.End()
+
+	// Using the global store instance
+	projects, err := store.Projects(ctx)
+	if err != nil {
+		handleError(ctx, w, r, "projects", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("project.count", len(projects)))
+	logWithTrace(ctx).Str("event", "list_projects").Int("count", len(projects)).Msg("Listed projects")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
+
+func addProjectHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "add_project")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "addProjectHandler")
+	defer span.End()
+
+	project, err := decodeProjectInput(w, r)
+	if err != nil {
+		handleBodyError(ctx, w, r, "add_project", err)
+		return
+	}
+	span.SetAttributes(attribute.String("project.name", project.Name))
+
+	// Using the global store instance
+	added, err := store.AddProject(ctx, project)
+	if err != nil {
+		handleError(ctx, w, r, "add_project", storeProblem(err), err)
+		return
+	}
+
+	setProjectAttribute(span, added.ID)
+	logWithTrace(ctx).Str("event", "project_added").Int("project_id", added.ID).Str("project_name", added.Name).Msg("Added project")
+	w.Header().Set("ETag", versionTag(added.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ProjectSummary{Project: added})
+}
+
+func getProjectHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "get_project")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "getProjectHandler")
+	defer span.End()
+
+	id, err := projectID(r)
+	if err != nil {
+		handleError(ctx, w, r, "get_project", problemInvalidID, err)
+		return
+	}
+	setProjectAttribute(span, id)
+
+	// Using the global store instance
+	project, err := store.GetProject(ctx, id)
+	if err != nil {
+		handleError(ctx, w, r, "get_project", storeProblem(err), err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("project.name", project.Name), attribute.Int("project.tasks", project.Counts.Total))
+	w.Header().Set("ETag", versionTag(project.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+func renameProjectHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "rename_project")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "renameProjectHandler")
+	defer span.End()
+
+	id, err := projectID(r)
+	if err != nil {
+		handleError(ctx, w, r, "rename_project", problemInvalidID, err)
+		return
+	}
+	setProjectAttribute(span, id)
+
+	input, err := decodeProjectInput(w, r)
+	if err != nil {
+		handleBodyError(ctx, w, r, "rename_project", err)
+		return
+	}
+	span.SetAttributes(attribute.String("project.name", input.Name))
+
+	ifVersion, err := ifMatchProjectVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "rename_project", storeProblem(err), err)
+		return
+	}
+
+	// Using the global store instance
+	renamed, err := store.RenameProject(ctx, id, input.Name, ifVersion)
+	if err != nil {
+		handleError(ctx, w, r, "rename_project", storeProblem(err), err)
+		return
+	}
+	project, err := store.GetProject(ctx, id)
+	if err != nil {
+		handleError(ctx, w, r, "rename_project", storeProblem(err), err)
+		return
+	}
+
+	logWithTrace(ctx).Str("event", "project_renamed").Int("project_id", id).Str("project_name", renamed.Name).Msg("Renamed project")
+	w.Header().Set("ETag", versionTag(renamed.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+func deleteProjectHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(r.Context(), float64(duration), metric.WithAttributes(attribute.String("handler", "delete_project")))
+	}()
+
+	ctx := r.Context()
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "deleteProjectHandler")
+	defer span.End()
+
+	id, err := projectID(r)
+	if err != nil {
+		handleError(ctx, w, r, "delete_project", problemInvalidID, err)
+		return
+	}
+	setProjectAttribute(span, id)
+
+	ifVersion, err := ifMatchProjectVersion(ctx, r, id)
+	if err != nil {
+		handleError(ctx, w, r, "delete_project", storeProblem(err), err)
+		return
+	}
+
+	// Using the global store instance
+	if err := store.DeleteProject(ctx, id, ifVersion); err != nil {
+		handleError(ctx, w, r, "delete_project", storeProblem(err), err)
+		return
+	}
+
+	logWithTrace(ctx).Str("event", "project_deleted").Int("project_id", id).Msg("Deleted project")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setProjectAttribute records the project a request concerns, so traces can
+// be sliced per project; 0 stands for tasks in no project.
+func setProjectAttribute(span oteltrace.Span, project int) {
+	span.SetAttributes(attribute.Int("todo.project", project))
+}
+
+// handleTaskStoreError reports a Store error from writing a task. A task
+// assigned to a missing project or parent, or to one of its own subtasks,
+// dependencies on missing tasks or in a cycle, and restoring a version the
+// history does not record are validation failures rather than 404s.
+func handleTaskStoreError(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string, err error) {
+	problem, fieldErrs := taskStoreProblem(err)
+	handleFieldErrors(ctx, w, r, handler, problem, err, fieldErrs)
+}
+
+// taskStoreProblem maps a Store error onto the catalogue, reporting the
+// errors caused by a field of the task document as validation failures.
+func taskStoreProblem(err error) (problemType, []fieldError) {
+	var ferr fieldError
+	switch {
+	case errors.Is(err, ErrProjectNotFound):
+		ferr = fieldError{Path: "/project", Message: "project does not exist", Code: "unknown_project"}
+	case errors.Is(err, ErrParentNotFound):
+		ferr = fieldError{Path: "/parent", Message: "parent task does not exist", Code: "unknown_parent"}
+	case errors.Is(err, ErrCycle):
+		ferr = fieldError{Path: "/parent", Message: "a task cannot be its own ancestor", Code: "cycle"}
+	case errors.Is(err, ErrBlockerNotFound):
+		ferr = fieldError{Path: "/blocked_by", Message: "blocking task does not exist", Code: "unknown_task"}
+	case errors.Is(err, ErrDependencyCycle):
+		ferr = fieldError{Path: "/blocked_by", Message: "a task cannot be blocked by itself, directly or through other tasks", Code: "cycle"}
+	case errors.Is(err, ErrRevisionNotFound):
+		ferr = fieldError{Path: "/version", Message: "the task history has no such version", Code: "unknown_revision"}
+	default:
+		return storeProblem(err), nil
+	}
+	return problemValidation, []fieldError{ferr}
+}
+
+// setTagFilterAttributes records the tag filters of a list-style request on its span.
+func setTagFilterAttributes(span oteltrace.Span, prefix string, filter ListFilter) {
+	if len(filter.TagsAny) > 0 {
+		span.SetAttributes(attribute.StringSlice(prefix+".tags_any", filter.TagsAny))
+	}
+	if len(filter.TagsAll) > 0 {
+		span.SetAttributes(attribute.StringSlice(prefix+".tags_all", filter.TagsAll))
+	}
+	if len(filter.TagsNone) > 0 {
+		span.SetAttributes(attribute.StringSlice(prefix+".tags_none", filter.TagsNone))
+	}
+}
+
+// todoID reads the task ID from the {id} path segment, falling back to the
+// "id" query parameter used by the deprecated verb-style routes.
+func todoID(r *http.Request) (int, error) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		idStr = r.URL.Query().Get("id")
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, fmt.Errorf("task ID %q is not an integer", idStr)
+	}
+	return id, nil
+}
+
+// projectID reads the project ID from the {id} path segment.
+func projectID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, fmt.Errorf("project ID %q is not an integer", r.PathValue("id"))
+	}
+	return id, nil
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.opentelemetry.io/otel/metric/noop"
 )
 
 // Setup common test resources if needed, e.g., a mock store or initializing globals for tests
@@ -11,10 +22,19 @@ func setupTest() {
 	// Initialize global store for testing purposes
 	// WARNING: This uses global state, which is not ideal for parallel tests.
 	// Consider using dependency injection and mocks for better test isolation.
-	store = NewStore()
-	// Initialize metrics/tracer stubs if handlers depend on them directly and they aren't mocked
-	// initMetrics() // Might start HTTP server, be careful
-	// initTracer()() // Might try to connect to collector
+	store = NewMemoryStore()
+	undos = newUndoLog(store, defaultUndoConfig())
+	store = undos.wrap(store)
+	changeFeed, _ = newFeed(defaultFeedConfig())
+	store.Watch(changeFeed.publish)
+	// Handlers record metrics directly, so back the globals with no-op instruments.
+	// initMetrics() would start the :2112 metrics server and initTracer() dials the collector.
+	meter = noop.NewMeterProvider().Meter("todo-service-test")
+	taskCounter, _ = meter.Int64Counter("todo_tasks_added_total")
+	handlerLatency, _ = meter.Float64Histogram("todo_handler_latency_milliseconds")
+	errorCounter, _ = meter.Int64Counter("todo_handler_errors_total")
+	deprecatedCounter, _ = meter.Int64Counter("todo_deprecated_route_requests_total")
+	tagCounter, _ = meter.Int64Counter("todo_tag_operations_total")
 }
 
 func TestGetHandler_InvalidID(t *testing.T) {
@@ -45,6 +65,1072 @@ func TestGetHandler_NotFound(t *testing.T) {
 	}
 }
 
+func TestCompleteHandler_PersistsState(t *testing.T) {
+	setupTest()
+	ctx := context.Background()
+	open, _ := store.Add(ctx, ToDo{Text: "Open task"})
+	done, _ := store.Add(ctx, ToDo{Text: "Done task"})
+
+	req, _ := http.NewRequest("POST", "/complete?id="+strconv.Itoa(done.ID), nil)
+	req.Header.Set("X-User", "alice")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(completeHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	stored, _ := store.Get(ctx, done.ID)
+	if !stored.Completed || stored.CompletedAt == nil || stored.CompletedBy != "alice" {
+		t.Errorf("completion state not persisted: %+v", stored)
+	}
+
+	req, _ = http.NewRequest("GET", "/list?status=open", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(listHandler).ServeHTTP(rr, req)
+	var todos []ToDo
+	if err := json.NewDecoder(rr.Body).Decode(&todos); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	if len(todos) != 1 || todos[0].ID != open.ID {
+		t.Errorf("status=open returned %+v, want only task %d", todos, open.ID)
+	}
+
+	req, _ = http.NewRequest("POST", "/uncomplete?id="+strconv.Itoa(done.ID), nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(uncompleteHandler).ServeHTTP(rr, req)
+	if stored, _ := store.Get(ctx, done.ID); stored.Completed || stored.CompletedAt != nil {
+		t.Errorf("uncomplete did not clear completion state: %+v", stored)
+	}
+}
+
+func TestRoutes_ResourceAPI(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("POST", "/todos", strings.NewReader(`{"text":"Routed"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST /todos returned %v, want %v", rr.Code, http.StatusCreated)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/todos/1", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Deprecation") != "" {
+		t.Errorf("GET /todos/1 returned %v (Deprecation %q)", rr.Code, rr.Header().Get("Deprecation"))
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("POST", "/todos/1", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /todos/1 returned %v, want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "DELETE, GET, HEAD, PATCH, PUT" {
+		t.Errorf("Allow header = %q, want DELETE, GET, HEAD, PATCH, PUT", allow)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/get?id=1", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Deprecation") != "true" {
+		t.Errorf("GET /get returned %v (Deprecation %q)", rr.Code, rr.Header().Get("Deprecation"))
+	}
+}
+
+func TestListHandler_CursorPagination(t *testing.T) {
+	setupTest()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		store.Add(ctx, ToDo{Text: "Task " + strconv.Itoa(i)})
+	}
+
+	var ids []int
+	target := "/todos?limit=2&sort=-id"
+	for pages := 0; target != ""; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		req, _ := http.NewRequest("GET", target, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(listHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s returned %v", target, rr.Code)
+		}
+		var todos []ToDo
+		json.NewDecoder(rr.Body).Decode(&todos)
+		for _, todo := range todos {
+			ids = append(ids, todo.ID)
+		}
+		target = ""
+		if next := rr.Header().Get("X-Next-Cursor"); next != "" {
+			if !strings.Contains(rr.Header().Get("Link"), `rel="next"`) {
+				t.Errorf("missing Link header alongside X-Next-Cursor")
+			}
+			target = "/todos?limit=2&sort=-id&cursor=" + next
+		}
+	}
+	if want := []int{5, 4, 3, 2, 1}; !slices.Equal(ids, want) {
+		t.Errorf("paged IDs = %v, want %v", ids, want)
+	}
+
+	req, _ := http.NewRequest("GET", "/todos?limit=2&sort=id", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(listHandler).ServeHTTP(rr, req)
+	req, _ = http.NewRequest("GET", "/todos?sort=created&cursor="+rr.Header().Get("X-Next-Cursor"), nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(listHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("cursor reused with a different sort returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	todo, _ := store.Add(context.Background(), ToDo{Text: "Shared"})
+	path := "/todos/" + strconv.Itoa(todo.ID)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	tag := rr.Header().Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("ETag = %q, want %q", tag, `"1"`)
+	}
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", tag)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match with current ETag returned %v, want %v", rr.Code, http.StatusNotModified)
+	}
+
+	req = httptest.NewRequest("PUT", path, strings.NewReader(`{"text":"Client A"}`))
+	req.Header.Set("If-Match", tag)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("first conditional PUT returned %v with ETag %q", rr.Code, rr.Header().Get("ETag"))
+	}
+
+	// Client B still holds the old ETag and must not overwrite client A.
+	for _, method := range []string{"PUT", "DELETE"} {
+		req = httptest.NewRequest(method, path, strings.NewReader(`{"text":"Client B"}`))
+		req.Header.Set("If-Match", tag)
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("stale %s returned %v, want %v", method, rr.Code, http.StatusPreconditionFailed)
+		}
+	}
+	if stored, _ := store.Get(context.Background(), todo.ID); stored.Text != "Client A" {
+		t.Errorf("stored text = %q, want %q", stored.Text, "Client A")
+	}
+}
+
+func TestPatchHandler(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	todo, _ := store.Add(context.Background(), ToDo{Text: "Draft"})
+	path := "/todos/" + strconv.Itoa(todo.ID)
+
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := patch(mergePatchMediaType, `{"completed": true}`)
+	var patched ToDo
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || !patched.Completed || patched.Text != "Draft" || patched.CompletedAt == nil {
+		t.Fatalf("merge patch returned %v: %+v", rr.Code, patched)
+	}
+
+	rr = patch(jsonPatchMediaType, `[{"op":"test","path":"/text","value":"Draft"},{"op":"replace","path":"/text","value":"Final"}]`)
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || patched.Text != "Final" || !patched.Completed {
+		t.Fatalf("JSON patch returned %v: %+v", rr.Code, patched)
+	}
+
+	if got, err := applyJSONPatch([]byte(`{"text":"Final","tags":["a"]}`), []byte(`[{"op":"replace","path":"","value":{"text":"Whole"}}]`)); err != nil || string(got) != `{"text":"Whole"}` {
+		t.Errorf("replacing the root = %s, %v", got, err)
+	}
+	patched.Text = "Replaced"
+	whole, _ := json.Marshal(patched)
+	rr = patch(jsonPatchMediaType, `[{"op":"replace","path":"","value":`+string(whole)+`}]`)
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || patched.Text != "Replaced" {
+		t.Fatalf("root replace returned %v: %+v", rr.Code, patched)
+	}
+
+	rr = patch(jsonPatchMediaType, `[{"op":"test","path":"/text","value":"Draft"}]`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("failed test op returned %v, want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr = patch(mergePatchMediaType, `{"id": 7, "text": "", "colour": "red"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid merge patch returned %v, want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	var body struct {
+		Errors []fieldError `json:"errors"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	var paths []string
+	for _, e := range body.Errors {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"/colour", "/id", "/text"}; !slices.Equal(paths, want) {
+		t.Errorf("error paths = %v, want %v", paths, want)
+	}
+
+	rr = patch("text/plain", `text=x`)
+	if rr.Code != http.StatusUnsupportedMediaType || rr.Header().Get("Accept-Patch") == "" {
+		t.Errorf("unsupported media type returned %v (Accept-Patch %q)", rr.Code, rr.Header().Get("Accept-Patch"))
+	}
+}
+
+func TestTaskPayloadValidation(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	existing, _ := store.Add(context.Background(), ToDo{Text: "Existing"})
+	path := "/todos/" + strconv.Itoa(existing.ID)
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	cases := []struct {
+		name, method, target, body string
+		codes                      []string
+	}{
+		{"empty text", "POST", "/todos", `{"text": "   "}`, []string{"empty"}},
+		{"missing text", "POST", "/todos", `{}`, []string{"required"}},
+		{"unknown field", "POST", "/todos", `{"text": "a", "colour": "red"}`, []string{"unknown_field"}},
+		{"client id", "POST", "/todos", `{"id": 99, "text": "a", "version": 3}`, []string{"client_id", "read_only"}},
+		{"wrong type", "POST", "/todos", `{"text": 5}`, []string{"invalid_type"}},
+		{"control characters", "POST", "/todos", `{"text": "a\u0000b"}`, []string{"control_characters"}},
+		{"too long", "POST", "/todos", `{"text": "` + strings.Repeat("x", 501) + `"}`, []string{"too_long"}},
+		{"mismatched id", "PUT", path, `{"id": 12345, "text": "a"}`, []string{"client_id"}},
+	}
+	for _, tc := range cases {
+		rr := send(tc.method, tc.target, tc.body)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: returned %v, want %v", tc.name, rr.Code, http.StatusUnprocessableEntity)
+			continue
+		}
+		var body problemDetails
+		json.NewDecoder(rr.Body).Decode(&body)
+		var codes []string
+		for _, e := range body.Errors {
+			codes = append(codes, e.Code)
+		}
+		if body.Type != problemValidation.URI() || !slices.Equal(codes, tc.codes) {
+			t.Errorf("%s: problem %s with codes %v, want %v", tc.name, body.Type, codes, tc.codes)
+		}
+	}
+
+	if rr := send("POST", "/todos", `{"text": "a"} {"text": "b"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("trailing data returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+	validation.MaxBodyBytes = 32
+	defer func() { validation = defaultValidationConfig() }()
+	if rr := send("POST", "/todos", `{"text": "`+strings.Repeat("x", 64)+`"}`); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body returned %v, want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// Decomposed "é" is stored in NFC form with surrounding space trimmed
+	rr := send("POST", "/todos", `{"text": "  Cafe\u0301  "}`)
+	var added ToDo
+	json.NewDecoder(rr.Body).Decode(&added)
+	if rr.Code != http.StatusCreated || added.Text != "Caf\u00e9" {
+		t.Errorf("normalised add returned %v: %q", rr.Code, added.Text)
+	}
+	if rr := send("PUT", path, `{"id": `+strconv.Itoa(existing.ID)+`, "text": "Renamed"}`); rr.Code != http.StatusOK {
+		t.Errorf("PUT repeating the path ID returned %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestDueDates(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := send("POST", "/todos", `{"text": "Standup", "due": "2030-03-01T08:00:00Z", "due_zone": "Europe/Berlin"}`)
+	var added ToDo
+	json.NewDecoder(rr.Body).Decode(&added)
+	if rr.Code != http.StatusCreated || added.Due == nil || added.Due.Format(time.RFC3339) != "2030-03-01T09:00:00+01:00" {
+		t.Fatalf("add with due returned %v: %+v", rr.Code, added)
+	}
+	send("POST", "/todos", `{"text": "Taxes", "due": "2020-04-15T23:59:00-04:00"}`)
+	send("POST", "/todos", `{"text": "Someday"}`)
+
+	list := func(query string) []string {
+		rr := send("GET", "/todos?"+query, "")
+		var todos []ToDo
+		json.NewDecoder(rr.Body).Decode(&todos)
+		var texts []string
+		for _, todo := range todos {
+			texts = append(texts, todo.Text)
+		}
+		return texts
+	}
+	if got := list("overdue=true"); !slices.Equal(got, []string{"Taxes"}) {
+		t.Errorf("overdue=true listed %v", got)
+	}
+	if got := list("due_before=2031-01-01"); !slices.Equal(got, []string{"Standup", "Taxes"}) {
+		t.Errorf("due_before listed %v", got)
+	}
+	if got := list("sort=-due"); !slices.Equal(got, []string{"Someday", "Standup", "Taxes"}) {
+		t.Errorf("sort=-due listed %v", got)
+	}
+
+	for _, body := range []string{
+		`{"text": "x", "due": "tomorrow"}`,
+		`{"text": "x", "due": "2030-03-01T08:00:00Z", "due_zone": "Mars/Olympus"}`,
+		`{"text": "x", "due_zone": "Europe/Berlin"}`,
+		`{"text": "x", "overdue": true}`,
+	} {
+		if rr := send("POST", "/todos", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("POST %s returned %v, want %v", body, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+	if rr := send("GET", "/todos?due_before=soon", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid due_before returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPrioritiesAndOrdering(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+	texts := func(rr *httptest.ResponseRecorder) []string {
+		var todos []ToDo
+		json.NewDecoder(rr.Body).Decode(&todos)
+		var texts []string
+		for _, todo := range todos {
+			texts = append(texts, todo.Text)
+		}
+		return texts
+	}
+
+	send("POST", "/todos", `{"text": "Fix prod", "priority": "P0"}`)
+	send("POST", "/todos", `{"text": "Fix docs", "priority": "P3"}`)
+	send("POST", "/todos", `{"text": "Fix typo"}`)
+	send("POST", "/todos", `{"text": "Fix tests", "priority": "P3"}`)
+	if rr := send("POST", "/todos", `{"text": "x", "priority": "urgent"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid priority returned %v, want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	rr := send("POST", "/todos/4/move", `{"before": 2}`)
+	var moved ToDo
+	json.NewDecoder(rr.Body).Decode(&moved)
+	if rr.Code != http.StatusOK || moved.ID != 4 || rr.Header().Get("ETag") != etag(moved) {
+		t.Fatalf("move returned %v: %+v", rr.Code, moved)
+	}
+	if got := texts(send("GET", "/todos?sort=position", "")); !slices.Equal(got, []string{"Fix prod", "Fix tests", "Fix docs", "Fix typo"}) {
+		t.Errorf("sort=position listed %v", got)
+	}
+	if got := texts(send("GET", "/todos?sort=priority,position", "")); !slices.Equal(got, []string{"Fix prod", "Fix tests", "Fix docs", "Fix typo"}) {
+		t.Errorf("sort=priority,position listed %v", got)
+	}
+	if got := texts(send("GET", "/todos/search?q=Fix&sort=-priority,id", "")); !slices.Equal(got, []string{"Fix typo", "Fix docs", "Fix tests", "Fix prod"}) {
+		t.Errorf("search sort=-priority,id listed %v", got)
+	}
+
+	for _, body := range []string{`{}`, `{"before": 1, "after": 2}`, `{"after": 4}`} {
+		if rr := send("POST", "/todos/4/move", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("move %s returned %v, want %v", body, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+	if rr := send("POST", "/todos/4/move", `{"after": 99}`); rr.Code != http.StatusNotFound {
+		t.Errorf("move after a missing task returned %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestTags(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := send("POST", "/todos", `{"text": "Pay rent", "tags": ["Home", " bills ", "home"]}`)
+	var added ToDo
+	json.NewDecoder(rr.Body).Decode(&added)
+	if rr.Code != http.StatusCreated || !slices.Equal(added.Tags, []string{"bills", "home"}) {
+		t.Fatalf("add with tags returned %v: %v", rr.Code, added.Tags)
+	}
+	send("POST", "/todos", `{"text": "Review PR", "tags": ["work"]}`)
+
+	rr = send("PUT", "/todos/2/tags/Urgent%20Stuff", "")
+	var tagged ToDo
+	json.NewDecoder(rr.Body).Decode(&tagged)
+	if rr.Code != http.StatusOK || !slices.Equal(tagged.Tags, []string{"urgent stuff", "work"}) {
+		t.Errorf("PUT tag returned %v: %v", rr.Code, tagged.Tags)
+	}
+	if rr := send("DELETE", "/todos/1/tags/bills", ""); rr.Code != http.StatusOK {
+		t.Errorf("DELETE tag returned %v", rr.Code)
+	}
+	if rr := send("PUT", "/todos/1/tags/a,b", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("tag with a comma returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = send("GET", "/tags", "")
+	var counts []TagCount
+	json.NewDecoder(rr.Body).Decode(&counts)
+	if want := []TagCount{{"home", 1}, {"urgent stuff", 1}, {"work", 1}}; !slices.Equal(counts, want) {
+		t.Errorf("GET /tags = %v, want %v", counts, want)
+	}
+
+	rr = send("GET", "/todos?tags_none=work", "")
+	var todos []ToDo
+	json.NewDecoder(rr.Body).Decode(&todos)
+	if len(todos) != 1 || todos[0].ID != 1 {
+		t.Errorf("tags_none=work listed %+v", todos)
+	}
+	rr = send("GET", "/todos/search?q=R&tags_all=work,URGENT%20STUFF", "")
+	json.NewDecoder(rr.Body).Decode(&todos)
+	if len(todos) != 1 || todos[0].ID != 2 {
+		t.Errorf("search with tags_all listed %+v", todos)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/projects", `{"name": "  Home "}`)
+	var home ProjectSummary
+	json.NewDecoder(rr.Body).Decode(&home)
+	if rr.Code != http.StatusCreated || home.Name != "Home" || rr.Header().Get("ETag") != `"1"` {
+		t.Fatalf("POST /projects returned %v: %+v", rr.Code, home)
+	}
+	if rr := send("POST", "/projects", `{"name": "HOME"}`); rr.Code != http.StatusConflict {
+		t.Errorf("duplicate project returned %v, want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := send("POST", "/projects", `{}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("project without a name returned %v, want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	send("POST", "/projects", `{"name": "Work"}`)
+
+	send("POST", "/todos", `{"text": "Water plants", "project": 1}`)
+	send("POST", "/todos", `{"text": "Write report", "project": 1}`)
+	send("POST", "/todos", `{"text": "Unfiled"}`)
+	rr = send("POST", "/todos", `{"text": "Lost", "project": 42}`)
+	var problem problemDetails
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusUnprocessableEntity || len(problem.Errors) != 1 || problem.Errors[0].Code != "unknown_project" {
+		t.Errorf("task in a missing project returned %v: %+v", rr.Code, problem.Errors)
+	}
+
+	// Moving a task between projects is an edit of its project field
+	rr = send("PATCH", "/todos/2", `{"project": 2}`, "Content-Type", mergePatchMediaType)
+	var moved ToDo
+	json.NewDecoder(rr.Body).Decode(&moved)
+	if rr.Code != http.StatusOK || moved.Project != 2 {
+		t.Errorf("PATCH project returned %v: %+v", rr.Code, moved)
+	}
+
+	rr = send("GET", "/todos?project=1", "")
+	var todos []ToDo
+	json.NewDecoder(rr.Body).Decode(&todos)
+	if len(todos) != 1 || todos[0].ID != 1 {
+		t.Errorf("project=1 listed %+v", todos)
+	}
+	rr = send("GET", "/list?project=none", "")
+	json.NewDecoder(rr.Body).Decode(&todos)
+	if len(todos) != 1 || todos[0].ID != 3 {
+		t.Errorf("/list?project=none listed %+v", todos)
+	}
+	rr = send("GET", "/todos/search?q=W&project=2", "")
+	json.NewDecoder(rr.Body).Decode(&todos)
+	if len(todos) != 1 || todos[0].ID != 2 {
+		t.Errorf("project-scoped search listed %+v", todos)
+	}
+	if rr := send("GET", "/todos?project=abc", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid project filter returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = send("GET", "/projects", "")
+	var projects []ProjectSummary
+	json.NewDecoder(rr.Body).Decode(&projects)
+	if len(projects) != 2 || projects[0].Counts.Total != 1 || projects[1].Counts.Open != 1 {
+		t.Errorf("GET /projects = %+v", projects)
+	}
+
+	if rr := send("PUT", "/projects/2", `{"name": "Office"}`, "If-Match", `"7"`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale rename returned %v, want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	rr = send("PUT", "/projects/2", `{"name": "Office"}`, "If-Match", `"1"`)
+	var renamed ProjectSummary
+	json.NewDecoder(rr.Body).Decode(&renamed)
+	if rr.Code != http.StatusOK || renamed.Name != "Office" || renamed.Counts.Total != 1 {
+		t.Errorf("rename returned %v: %+v", rr.Code, renamed)
+	}
+
+	if rr := send("DELETE", "/projects/2", ""); rr.Code != http.StatusConflict {
+		t.Errorf("deleting a non-empty project returned %v, want %v", rr.Code, http.StatusConflict)
+	}
+	send("DELETE", "/todos/2", "")
+	if rr := send("DELETE", "/projects/2", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("deleting an empty project returned %v, want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := send("GET", "/projects/2", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("deleted project returned %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestSubtasks(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	send("POST", "/todos", `{"text": "Plan trip"}`)
+	send("POST", "/todos", `{"text": "Book flights", "parent": 1}`)
+	send("POST", "/todos", `{"text": "Book hotel", "parent": 1}`)
+	send("POST", "/todos", `{"text": "Compare prices", "parent": 3}`)
+	send("POST", "/todos/2/complete", "")
+
+	rr := send("GET", "/todos/1?expand=children", "")
+	var tree TaskTree
+	json.NewDecoder(rr.Body).Decode(&tree)
+	if rr.Code != http.StatusOK || len(tree.Children) != 2 || len(tree.Children[1].Children) != 1 || tree.Children[1].Children[0].Text != "Compare prices" {
+		t.Fatalf("expand=children returned %v: %+v", rr.Code, tree)
+	}
+	if tree.Progress == nil || tree.Progress.Percent != 50 {
+		t.Errorf("parent progress = %+v, want 50%%", tree.Progress)
+	}
+	rr = send("GET", "/get?id=3&expand=children", "")
+	json.NewDecoder(rr.Body).Decode(&tree)
+	if len(tree.Children) != 1 || tree.Children[0].Children == nil {
+		t.Errorf("/get expand=children returned %+v", tree)
+	}
+	if rr := send("GET", "/todos/1?expand=parents", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown expand returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = send("PATCH", "/todos/1", `{"parent": 4}`)
+	var problem problemDetails
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusUnprocessableEntity || len(problem.Errors) != 1 || problem.Errors[0].Code != "cycle" {
+		t.Errorf("cyclic parent returned %v: %+v", rr.Code, problem.Errors)
+	}
+	if rr := send("DELETE", "/todos/3", ""); rr.Code != http.StatusConflict {
+		t.Errorf("deleting a parent returned %v, want %v", rr.Code, http.StatusConflict)
+	}
+
+	send("POST", "/todos/4/complete", "")
+	rr = send("GET", "/todos/1", "")
+	var root ToDo
+	json.NewDecoder(rr.Body).Decode(&root)
+	if !root.Completed || root.Progress.Done != 2 {
+		t.Errorf("root after all subtasks done = %+v", root)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	send("POST", "/todos", `{"text": "Write tests", "priority": "P2"}`)
+	send("POST", "/todos", `{"text": "Merge", "priority": "P0", "blocked_by": [1]}`)
+	send("POST", "/todos", `{"text": "Update docs", "priority": "P1"}`)
+
+	rr := send("GET", "/todos/next", "")
+	var next []ToDo
+	json.NewDecoder(rr.Body).Decode(&next)
+	if len(next) != 3 || next[0].ID != 3 || next[1].ID != 1 || next[2].ID != 2 || !next[2].Blocked {
+		t.Errorf("GET /todos/next = %+v", next)
+	}
+	rr = send("GET", "/todos/next?limit=1&blocked=false", "")
+	json.NewDecoder(rr.Body).Decode(&next)
+	if len(next) != 1 || next[0].ID != 3 {
+		t.Errorf("GET /todos/next?limit=1 = %+v", next)
+	}
+
+	rr = send("POST", "/todos/2/complete", "")
+	var problem problemDetails
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusConflict || problem.Type != problemBlocked.URI() {
+		t.Errorf("completing a blocked task returned %v: %+v", rr.Code, problem)
+	}
+	if rr := send("POST", "/todos/2/complete?force=true", ""); rr.Code != http.StatusOK {
+		t.Errorf("forced completion returned %v", rr.Code)
+	}
+
+	rr = send("PATCH", "/todos/1", `{"blocked_by": [2]}`)
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusUnprocessableEntity || len(problem.Errors) != 1 || problem.Errors[0].Code != "cycle" {
+		t.Errorf("dependency cycle returned %v: %+v", rr.Code, problem.Errors)
+	}
+	if rr := send("PATCH", "/todos/3", `{"blocked_by": [0]}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid blocker ID returned %v", rr.Code)
+	}
+}
+
+func TestRecurringTasks(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for body, code := range map[string]string{
+		`{"text": "x", "recurrence": "FREQ=WEEKLY"}`:                                "requires_due",
+		`{"text": "x", "due": "2030-03-01T08:00:00Z", "recurrence": "FREQ=YEARLY"}`: "invalid_format",
+		`{"text": "x", "due": "2030-03-01T08:00:00Z", "recurrence": 7}`:             "invalid_type",
+	} {
+		rr := send("POST", "/todos", body)
+		var problem problemDetails
+		json.NewDecoder(rr.Body).Decode(&problem)
+		if rr.Code != http.StatusUnprocessableEntity || len(problem.Errors) != 1 || problem.Errors[0].Path != "/recurrence" || problem.Errors[0].Code != code {
+			t.Errorf("POST %s returned %v: %+v, want %s", body, rr.Code, problem.Errors, code)
+		}
+	}
+
+	rr := send("POST", "/todos", `{"text": "Review budget", "due": "2030-03-01T08:00:00Z", "due_zone": "Europe/Berlin", "recurrence": "rrule:freq=monthly;byday=1fr"}`)
+	var created ToDo
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.Recurrence != "FREQ=MONTHLY;BYDAY=1FR" || created.Occurrence != 1 {
+		t.Fatalf("POST recurring task returned %v: %+v", rr.Code, created)
+	}
+	rr = send("POST", "/todos/1/complete", "")
+	var done ToDo
+	json.NewDecoder(rr.Body).Decode(&done)
+	if rr.Code != http.StatusOK || done.NextOccurrence != 2 {
+		t.Fatalf("completing a recurring task returned %v: %+v", rr.Code, done)
+	}
+	rr = send("GET", "/todos/2", "")
+	var next ToDo
+	json.NewDecoder(rr.Body).Decode(&next)
+	if next.Due == nil || next.Due.Format(time.RFC3339) != "2030-04-05T09:00:00+02:00" || next.Occurrence != 2 || next.Completed {
+		t.Errorf("next occurrence = %+v", next)
+	}
+	if rr := send("PATCH", "/todos/2", `{"occurrence": 1}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("PATCH occurrence returned %v", rr.Code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", "alice")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	send("POST", "/todos", `{"text": "Book venue"}`)
+	send("PATCH", "/todos/1", `{"text": "Book a bigger venue", "priority": "P1"}`)
+
+	rr := send("GET", "/todos/1/history", "")
+	var entries []HistoryEntry
+	json.NewDecoder(rr.Body).Decode(&entries)
+	if rr.Code != http.StatusOK || len(entries) != 2 || entries[1].Op != "replace" || entries[1].Actor != "alice" ||
+		entries[1].Before.Text != "Book venue" || entries[1].After.Priority != "P1" {
+		t.Fatalf("GET /todos/1/history returned %v: %+v", rr.Code, entries)
+	}
+
+	rr = send("POST", "/todos/1/restore", `{"version": 1}`)
+	var restored ToDo
+	json.NewDecoder(rr.Body).Decode(&restored)
+	if rr.Code != http.StatusOK || restored.Text != "Book venue" || restored.Priority != "" || restored.Version != 3 || rr.Header().Get("ETag") != `"3"` {
+		t.Errorf("restore returned %v: %+v", rr.Code, restored)
+	}
+
+	for body, code := range map[string]string{`{"version": 7}`: "unknown_revision", `{}`: "required", `{"version": 0}`: "invalid_value"} {
+		rr := send("POST", "/todos/1/restore", body)
+		var problem problemDetails
+		json.NewDecoder(rr.Body).Decode(&problem)
+		if rr.Code != http.StatusUnprocessableEntity || len(problem.Errors) != 1 || problem.Errors[0].Code != code {
+			t.Errorf("restore %s returned %v: %+v, want %s", body, rr.Code, problem.Errors, code)
+		}
+	}
+	if rr := send("GET", "/todos/9/history", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("history of a missing task returned %v", rr.Code)
+	}
+	if rr := send("DELETE", "/todos/1/history", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /todos/1/history returned %v", rr.Code)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	send("POST", "/projects", `{"name": "Garden"}`)
+	send("POST", "/todos", `{"text": "Plant bulbs", "project": 1}`)
+	send("DELETE", "/todos/1", "")
+
+	rr := send("GET", "/trash", "")
+	var trash []ToDo
+	json.NewDecoder(rr.Body).Decode(&trash)
+	if rr.Code != http.StatusOK || len(trash) != 1 || trash[0].ID != 1 || trash[0].DeletedAt == nil {
+		t.Fatalf("GET /trash returned %v: %+v", rr.Code, trash)
+	}
+
+	send("DELETE", "/projects/1", "")
+	rr = send("POST", "/trash/1/restore", "")
+	var problem problemDetails
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusConflict || problem.Type != problemRestoreConflict.URI() {
+		t.Errorf("restoring into a deleted project returned %v: %+v", rr.Code, problem)
+	}
+
+	send("POST", "/projects", `{"name": "Garden"}`)
+	rr = send("POST", "/trash/1/restore", "")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("restore with the project recreated under a new ID returned %v", rr.Code)
+	}
+
+	send("POST", "/todos", `{"text": "Rake leaves"}`)
+	send("DELETE", "/todos/2", "")
+	rr = send("POST", "/trash/2/restore", "")
+	var restored ToDo
+	json.NewDecoder(rr.Body).Decode(&restored)
+	if rr.Code != http.StatusOK || restored.ID != 2 || restored.DeletedAt != nil || rr.Header().Get("ETag") != `"3"` {
+		t.Errorf("POST /trash/2/restore returned %v: %+v", rr.Code, restored)
+	}
+	if rr := send("POST", "/trash/2/restore", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("restoring a task not in the trash returned %v", rr.Code)
+	}
+	if rr := send("DELETE", "/trash", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /trash returned %v", rr.Code)
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+	send := func(method, target, body, session string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Session-ID", session)
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	send("POST", "/todos", `{"text": "Book flights"}`, "tab-1")
+	send("PUT", "/todos/1", `{"text": "Book flights to Lisbon"}`, "tab-1")
+	send("POST", "/todos/1/complete", "", "tab-1")
+	if rr := send("POST", "/undo", "", "tab-2"); rr.Code != http.StatusConflict {
+		t.Errorf("undo in another session returned %v", rr.Code)
+	}
+
+	rr := send("POST", "/undo?steps=2", "", "tab-1")
+	var results []undoResult
+	json.NewDecoder(rr.Body).Decode(&results)
+	if rr.Code != http.StatusOK || len(results) != 2 || results[1].Op != "update" || results[1].Task.Text != "Book flights" || results[1].Task.Completed {
+		t.Fatalf("POST /undo?steps=2 returned %v: %+v", rr.Code, results)
+	}
+	rr = send("POST", "/redo", "", "tab-1")
+	results = nil
+	json.NewDecoder(rr.Body).Decode(&results)
+	if rr.Code != http.StatusOK || len(results) != 1 || results[0].Task.Text != "Book flights to Lisbon" {
+		t.Errorf("POST /redo returned %v: %+v", rr.Code, results)
+	}
+
+	send("PUT", "/todos/1", `{"text": "Book flights to Porto"}`, "tab-2")
+	rr = send("POST", "/undo", "", "tab-1")
+	var problem problemDetails
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusConflict || problem.Type != problemUndoConflict.URI() {
+		t.Errorf("undo of a task changed since returned %v: %+v", rr.Code, problem)
+	}
+
+	// A conflict partway through reports the steps already undone
+	send("POST", "/todos", `{"text": "Water the plants"}`, "tab-3")
+	send("POST", "/todos", `{"text": "Pay rent"}`, "tab-3")
+	send("PUT", "/todos/2", `{"text": "Water the balcony plants"}`, "tab-4")
+	rr = send("POST", "/undo?steps=2", "", "tab-3")
+	var partial replayProblem
+	json.NewDecoder(rr.Body).Decode(&partial)
+	if rr.Code != http.StatusConflict || partial.Type != problemUndoConflict.URI() || len(partial.Applied) != 1 || partial.Applied[0].TaskID != 3 {
+		t.Errorf("undo conflicting at its second step returned %v: %+v", rr.Code, partial)
+	}
+
+	if rr := send("POST", "/undo?steps=0", "", "tab-1"); rr.Code != http.StatusBadRequest {
+		t.Errorf("POST /undo?steps=0 returned %v", rr.Code)
+	}
+	if rr := send("GET", "/redo", "", "tab-1"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /redo returned %v", rr.Code)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(setupRoutes())
+	defer server.Close()
+	defer changeFeed.close() // Ends the open streams before the server closes
+
+	connect := func(lastEventID string) *bufio.Reader {
+		req, _ := http.NewRequest("GET", server.URL+"/todos/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("GET /todos/events returned %v %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body)
+	}
+	// next returns the next event's fields, skipping comments.
+	next := func(stream *bufio.Reader) map[string]string {
+		event := map[string]string{}
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && len(event) > 0 {
+				return event
+			}
+			if name, value, ok := strings.Cut(line, ": "); ok && name != "" {
+				event[name] = value
+			}
+		}
+	}
+
+	live := connect("")
+	http.Post(server.URL+"/todos", "application/json", strings.NewReader(`{"text": "Renew passport"}`))
+	event := next(live)
+	var payload feedPayload
+	json.Unmarshal([]byte(event["data"]), &payload)
+	if event["id"] != "1" || event["event"] != "add" || payload.Task == nil || payload.Task.Text != "Renew passport" || payload.Op != "add" {
+		t.Errorf("live event = %v", event)
+	}
+
+	req, _ := http.NewRequest("POST", server.URL+"/todos/1/complete", nil)
+	http.DefaultClient.Do(req)
+	if event := next(live); event["id"] != "2" || event["event"] != "complete" {
+		t.Errorf("completion event = %v", event)
+	}
+
+	resumed := connect("1")
+	if event := next(resumed); event["id"] != "2" || event["event"] != "complete" {
+		t.Errorf("resumed stream started with %v", event)
+	}
+	if event := next(connect("bogus")); event["event"] != "reset" {
+		t.Errorf("stream with an unknown Last-Event-ID started with %v", event)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(setupRoutes())
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dial := func(user string) *websocket.Conn {
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws", &websocket.DialOptions{
+			HTTPHeader: http.Header{"X-User": {user}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.CloseNow() })
+		return conn
+	}
+	call := func(conn *websocket.Conn, msg string) wsReply {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		var reply wsReply
+		if err := wsjson.Read(ctx, conn, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	watcher := dial("dashboard")
+	if reply := call(watcher, `{"id": "s", "type": "subscribe"}`); reply.Type != "ack" || reply.ID != "s" {
+		t.Fatalf("subscribe = %+v", reply)
+	}
+
+	client := dial("alice")
+	reply := call(client, `{"id": "1", "type": "add", "task": {"text": "Pack bags"}}`)
+	if reply.Type != "ack" || reply.ID != "1" || reply.Task == nil || reply.Task.Text != "Pack bags" {
+		t.Fatalf("add = %+v", reply)
+	}
+	reply = call(client, `{"id": "2", "type": "update", "task_id": 1, "version": 1, "task": {"text": "Pack bags and passports"}}`)
+	if reply.Type != "ack" || reply.Task.Version != 2 {
+		t.Errorf("update = %+v", reply)
+	}
+	reply = call(client, `{"id": "3", "type": "complete", "task_id": 1, "version": 1}`)
+	if reply.Type != "error" || reply.ID != "3" || reply.Error.Type != problemPreconditionFailed.URI() {
+		t.Errorf("complete at a stale version = %+v", reply)
+	}
+	if reply := call(client, `{"id": "4", "type": "complete", "task_id": 1}`); reply.Type != "ack" || reply.Task.CompletedBy != "alice" {
+		t.Errorf("complete = %+v", reply)
+	}
+	if reply := call(client, `{"id": "5", "type": "delete", "task_id": 1}`); reply.Type != "ack" || reply.Task != nil {
+		t.Errorf("delete = %+v", reply)
+	}
+	if reply := call(client, `{"id": "6", "type": "add", "task": {"text": ""}}`); reply.Type != "error" || reply.Error.Type != problemValidation.URI() || len(reply.Error.Errors) != 1 {
+		t.Errorf("add of an invalid task = %+v", reply)
+	}
+	if reply := call(client, `{"id": "7", "type": "archive", "task_id": 1}`); reply.Type != "error" || reply.Error.Type != problemInvalidBody.URI() {
+		t.Errorf("unknown command = %+v", reply)
+	}
+	if reply := call(client, `{"id": "8", "type": "delete"}`); reply.Type != "error" || reply.Error.Type != problemInvalidID.URI() {
+		t.Errorf("delete without task_id = %+v", reply)
+	}
+
+	var events []string
+	for range 4 {
+		var event wsReply
+		if err := wsjson.Read(ctx, watcher, &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event.Event)
+		if event.Type != "event" || event.EventID != int64(len(events)) {
+			t.Errorf("event = %+v", event)
+		}
+	}
+	if want := []string{"add", "update", "complete", "delete"}; !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestLabelLimiter(t *testing.T) {
+	l := newLabelLimiter(2)
+	got := []string{l.label("a"), l.label("b"), l.label("c"), l.label("a")}
+	if want := []string{"a", "b", "_other", "a"}; !slices.Equal(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+}
+
+func TestLoadValidationConfig(t *testing.T) {
+	env := map[string]string{
+		"TODO_MAX_BODY_BYTES":    "1024",
+		"TODO_FIELD_CONSTRAINTS": `{"text": {"max_length": 280}}`,
+	}
+	cfg, err := loadValidationConfig(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxBodyBytes != 1024 || cfg.Fields["text"] != (fieldConstraint{MinLength: 1, MaxLength: 280}) {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	for _, bad := range []string{`{"title": {"max_length": 5}}`, `{"text": {"max": 5}}`, `{"text": {"min_length": 10, "max_length": 5}}`} {
+		env["TODO_FIELD_CONSTRAINTS"] = bad
+		if _, err := loadValidationConfig(func(key string) string { return env[key] }); err == nil {
+			t.Errorf("TODO_FIELD_CONSTRAINTS=%s was accepted", bad)
+		}
+	}
+}
+
+func TestMethodNotAllowed_FixedTodoPaths(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+
+	for _, path := range []string{"/todos/search", "/todos/next", "/todos/events"} {
+		for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+			if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "GET, HEAD" {
+				t.Errorf("%s %s returned %v (Allow %q), want %v with GET, HEAD", method, path, rr.Code, rr.Header().Get("Allow"), http.StatusMethodNotAllowed)
+			}
+		}
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	setupTest()
+	mux := setupRoutes()
+
+	cases := []struct {
+		method, target string
+		status         int
+		problem        problemType
+	}{
+		{"GET", "/todos/abc", http.StatusBadRequest, problemInvalidID},
+		{"GET", "/todos/42", http.StatusNotFound, problemNotFound},
+		{"POST", "/todos/42", http.StatusMethodNotAllowed, problemMethodNotAllowed},
+		{"GET", "/todos?status=maybe", http.StatusBadRequest, problemInvalidParameter},
+		{"GET", "/nowhere", http.StatusNotFound, problemRouteNotFound},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, nil))
+		if rr.Code != tc.status || rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s %s returned %v (%s), want %v problem+json", tc.method, tc.target, rr.Code, rr.Header().Get("Content-Type"), tc.status)
+			continue
+		}
+		var body problemDetails
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("Could not decode problem: %v", err)
+		}
+		if body.Type != tc.problem.URI() || body.Status != tc.status || body.Title == "" || body.Instance != tc.target {
+			t.Errorf("%s %s problem = %+v", tc.method, tc.target, body)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", problemInvalidID.URI(), nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), problemInvalidID.title) {
+		t.Errorf("problem type URI did not resolve: %v %q", rr.Code, rr.Body.String())
+	}
+}
+
 // Add more tests for other handlers (add, list, delete, update, complete, search)
 // Example for AddHandler:
 /*
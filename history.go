@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// ErrRevisionNotFound is returned when restoring an item to a version its
+// history does not record.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// HistoryEntry is one immutable record in an item's audit trail: the item
+// before and after a committed change. Every change is recorded, including
+// those the store cascades to other items (e.g. a parent completed along
+// with its last subtask), under the operation that caused it.
+type HistoryEntry struct {
+	TaskID  int       `json:"task_id"`
+	Seq     int       `json:"seq"` // Position in the item's history, from 1; assigned by the backend
+	Op      string    `json:"op"`  // Store operation, e.g. "update" or "delete"
+	Actor   string    `json:"actor,omitempty"`
+	At      time.Time `json:"at"`
+	TraceID string    `json:"trace_id,omitempty"` // Trace of the request that made the change
+	Before  *ToDo     `json:"before"`             // nil when the item was created
+	After   *ToDo     `json:"after"`              // nil when the item was deleted
+}
+
+// actorKey is the context key of the actor recorded in history entries.
+type actorKey struct{}
+
+// withActor returns a context whose store changes are attributed to actor.
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor set by withActor, or "" for changes made by
+// the service itself (e.g. the scheduler).
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// auditTxn tracks the items a transaction writes, keeping each one's state
+// from before its first write, so the changes can be recorded on commit.
+// It forwards the optional indexedTxn and positionedTxn capabilities.
+type auditTxn struct {
+	txn
+	before  map[int]*ToDo // nil when the item did not exist
+	written []int         // IDs in order of first write
+}
+
+func (tx *auditTxn) track(id int) error {
+	if _, seen := tx.before[id]; seen {
+		return nil
+	}
+	var before *ToDo
+	todo, err := tx.txn.get(id)
+	switch {
+	case err == nil:
+		before = &todo
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+	if tx.before == nil {
+		tx.before = make(map[int]*ToDo)
+	}
+	tx.before[id] = before
+	tx.written = append(tx.written, id)
+	return nil
+}
+
+func (tx *auditTxn) put(todo ToDo) error {
+	if err := tx.track(todo.ID); err != nil {
+		return err
+	}
+	return tx.txn.put(todo)
+}
+
+func (tx *auditTxn) remove(id int) error {
+	if err := tx.track(id); err != nil {
+		return err
+	}
+	return tx.txn.remove(id)
+}
+
+func (tx *auditTxn) candidates(filter ListFilter) ([]ToDo, error) {
+	return listCandidates(tx.txn, filter)
+}
+
+func (tx *auditTxn) searchCandidates(query string) ([]ToDo, error) {
+	if itx, ok := tx.txn.(indexedTxn); ok {
+		return itx.searchCandidates(query)
+	}
+	return tx.txn.all()
+}
+
+func (tx *auditTxn) lastPosition() (string, error) {
+	return lastPosition(tx.txn)
+}
+
+// update runs fn in a read-write transaction and appends a history entry
+// for every item it changed, attributed to the actor and trace in ctx. Once
+// the transaction commits, the entries are passed to the watchers. Inside a
+// Batch, fn runs in the batch's transaction instead.
+func (s *taskStore) update(ctx context.Context, op string, fn func(tx txn) error) error {
+	if b := s.batchFrom(ctx); b != nil {
+		changes, err := s.record(ctx, op, b.tx, fn)
+		if err != nil {
+			b.err = cmp.Or(b.err, err)
+			return err
+		}
+		b.pending = append(b.pending, batchChanges{ctx: ctx, changes: changes})
+		logChanges(ctx, changes)
+		return nil
+	}
+	var changes []HistoryEntry
+	err := s.backend.update(ctx, op, func(tx txn) error {
+		var err error
+		changes, err = s.record(ctx, op, tx, fn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logChanges(ctx, changes)
+	s.notify(ctx, changes)
+	return nil
+}
+
+// record runs fn on tx and appends the history entries of the changes it
+// made, which it returns.
+func (s *taskStore) record(ctx context.Context, op string, tx txn, fn func(tx txn) error) ([]HistoryEntry, error) {
+	atx := &auditTxn{txn: tx}
+	if err := fn(atx); err != nil {
+		return nil, err
+	}
+	entry := HistoryEntry{Op: op, Actor: actorFrom(ctx), At: s.now().UTC()}
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		entry.TraceID = sc.TraceID().String()
+	}
+	var changes []HistoryEntry
+	for _, id := range atx.written {
+		entry.TaskID, entry.Before, entry.After = id, atx.before[id], nil
+		after, err := tx.get(id)
+		switch {
+		case err == nil:
+			entry.After = &after
+		case !errors.Is(err, ErrNotFound):
+			return nil, err
+		}
+		if entry.Before == nil && entry.After == nil || reflect.DeepEqual(entry.Before, entry.After) {
+			continue
+		}
+		if err := tx.appendHistory(entry); err != nil {
+			return nil, err
+		}
+		changes = append(changes, entry)
+	}
+	return changes, nil
+}
+
+// changeLogKey is the context key of the change log set by withChangeLog.
+type changeLogKey struct{}
+
+// withChangeLog returns a context whose store changes, including those
+// cascaded to other items, are appended to *log as they are made. Inside a
+// Batch they are appended before it commits.
+func withChangeLog(ctx context.Context, log *[]HistoryEntry) context.Context {
+	return context.WithValue(ctx, changeLogKey{}, log)
+}
+
+// logChanges appends changes to the change log of ctx, if it has one.
+func logChanges(ctx context.Context, changes []HistoryEntry) {
+	if log, ok := ctx.Value(changeLogKey{}).(*[]HistoryEntry); ok {
+		*log = append(*log, changes...)
+	}
+}
+
+// notify passes committed changes to the watchers.
+func (s *taskStore) notify(ctx context.Context, changes []HistoryEntry) {
+	if len(changes) == 0 {
+		return
+	}
+	s.watchMu.RLock()
+	defer s.watchMu.RUnlock()
+	for _, fn := range s.watchers {
+		fn(ctx, changes)
+	}
+}
+
+// Watch registers fn to be called after every committed change. Changes
+// committed concurrently may reach fn in either order; fn must not block.
+func (s *taskStore) Watch(fn func(ctx context.Context, changes []HistoryEntry)) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	s.watchers = append(s.watchers, fn)
+}
+
+// History returns an item's history, oldest first. Deleted items keep their
+// history; items that never existed fail with ErrNotFound.
+func (s *taskStore) History(ctx context.Context, id int) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	err := s.view(ctx, func(tx txn) error {
+		var err error
+		if entries, err = tx.history(id); err != nil || len(entries) > 0 {
+			return err
+		}
+		_, err = tx.get(id) // Items stored before history was recorded have none
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Restore sets an item's editable fields and completion state back to
+// those it had at version, as recorded in its history. The restore is a
+// change like any other: it gets a new version and history entry. A link
+// to a next occurrence that has since been deleted is dropped, so the item
+// recurs again when it is completed.
+func (s *taskStore) Restore(ctx context.Context, id, version int, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "restore", id, ifVersion, func(tx txn, todo *ToDo) error {
+		entries, err := tx.history(id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if rev := entry.After; rev != nil && rev.Version == version {
+				applyEdits(todo, *rev)
+				todo.Completed, todo.CompletedAt, todo.CompletedBy = rev.Completed, rev.CompletedAt, rev.CompletedBy
+				return dropDeletedOccurrence(tx, todo)
+			}
+		}
+		return ErrRevisionNotFound
+	})
+}
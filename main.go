@@ -7,21 +7,29 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // due_zone names must resolve in the Alpine image, which has no zoneinfo
 
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc"
 )
 
 // Global variables required by handlers and other components
 var (
-	store          *Store                   // In-memory task store
-	meterProvider  *sdkmetric.MeterProvider // OTel meter provider for metrics
-	meter          metric.Meter             // OTel meter for creating metrics
-	taskCounter    metric.Int64Counter      // Counter for tracking task operations
-	handlerLatency metric.Float64Histogram  // Histogram for tracking handler latencies
-	errorCounter   metric.Int64Counter      // Counter for tracking errors
+	store             Store                    // Task store, backend selected by TODO_STORE
+	undos             *undoLog                 // Per-session undo log of changes made through store
+	changeFeed        *feed                    // Live task changes for GET /todos/events
+	graphQL           *graphqlAPI              // Schema and limits of POST /graphql
+	meterProvider     *sdkmetric.MeterProvider // OTel meter provider for metrics
+	meter             metric.Meter             // OTel meter for creating metrics
+	taskCounter       metric.Int64Counter      // Counter for tracking task operations
+	handlerLatency    metric.Float64Histogram  // Histogram for tracking handler latencies
+	errorCounter      metric.Int64Counter      // Counter for tracking errors
+	deprecatedCounter metric.Int64Counter      // Counter for requests to deprecated routes
+	tagCounter        metric.Int64Counter      // Counter for tag operations, per (capped) tag
 )
 
 func main() {
@@ -37,34 +45,162 @@ func main() {
 	// Initialize OpenTelemetry metrics
 	initMetrics()
 
+	// Load payload validation limits
+	var err error
+	validation, err = loadValidationConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid validation configuration")
+	}
+
 	// Initialize task store
-	store = NewStore()
+	completion, err := loadCompletionRules(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid completion configuration")
+	}
+	store, err = OpenStore(os.Getenv("TODO_STORE"), os.Getenv("TODO_STORE_PATH"), completion)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to open task store")
+	}
+	undoCfg, err := loadUndoConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid undo configuration")
+	}
+	undos = newUndoLog(store, undoCfg)
+	store = undos.wrap(store)
+
+	// Publish committed changes to the event feed
+	feedCfg, err := loadFeedConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid feed configuration")
+	}
+	changeFeed, err = newFeed(feedCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create event feed")
+	}
+	store.Watch(changeFeed.publish)
+
+	// Build the GraphQL schema
+	graphqlCfg, err := loadGraphQLConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid GraphQL configuration")
+	}
+	graphQL, err = newGraphQLAPI(graphqlCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build GraphQL schema")
+	}
+
+	// Start the due-date scheduler (overdue flags and reminders)
+	schedCfg, err := loadSchedulerConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid scheduler configuration")
+	}
+	sched, err := newScheduler(store, schedCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create scheduler")
+	}
+	sched.start()
 
 	// Configure and start HTTP server
 	mux := setupRoutes()
 	server := createServer(mux)
+	server.RegisterOnShutdown(changeFeed.close) // Shutdown waits for open event streams otherwise
 	startServerAsync(server)
 
+	// The gRPC API serves the same store on its own port
+	grpcSrv := newGRPCServer()
+	startGRPCServerAsync(grpcSrv, grpcAddr(os.Getenv))
+
 	// Wait for shutdown signal and perform cleanup
-	handleGracefulShutdown(server)
+	handleGracefulShutdown(server, grpcSrv, sched)
 }
 
 // setupRoutes configures all HTTP routes with OTel instrumentation
 func setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	// Register API endpoints with OpenTelemetry instrumentation wrappers
-	mux.Handle("/add", otelhttp.NewHandler(http.HandlerFunc(addHandler), "addHandler"))
-	mux.Handle("/list", otelhttp.NewHandler(http.HandlerFunc(listHandler), "listHandler"))
-	mux.Handle("/delete", otelhttp.NewHandler(http.HandlerFunc(deleteHandler), "deleteHandler"))
-	mux.Handle("/update", otelhttp.NewHandler(http.HandlerFunc(updateHandler), "updateHandler"))
-	mux.Handle("/get", otelhttp.NewHandler(http.HandlerFunc(getHandler), "getHandler"))
-	mux.Handle("/complete", otelhttp.NewHandler(http.HandlerFunc(completeHandler), "completeHandler"))
-	mux.Handle("/search", otelhttp.NewHandler(http.HandlerFunc(searchHandler), "searchHandler"))
+	// Resource API
+	mux.Handle("GET /todos", otelhttp.NewHandler(http.HandlerFunc(listHandler), "listHandler"))
+	mux.Handle("POST /todos", otelhttp.NewHandler(http.HandlerFunc(addHandler), "addHandler"))
+	mux.Handle("GET /todos/search", otelhttp.NewHandler(http.HandlerFunc(searchHandler), "searchHandler"))
+	mux.Handle("GET /todos/next", otelhttp.NewHandler(http.HandlerFunc(nextHandler), "nextHandler"))
+	mux.Handle("GET /todos/events", otelhttp.NewHandler(http.HandlerFunc(eventsHandler), "eventsHandler"))
+	mux.Handle("GET /todos/{id}", otelhttp.NewHandler(http.HandlerFunc(getHandler), "getHandler"))
+	mux.Handle("PUT /todos/{id}", otelhttp.NewHandler(http.HandlerFunc(updateHandler), "updateHandler"))
+	mux.Handle("PATCH /todos/{id}", otelhttp.NewHandler(http.HandlerFunc(patchHandler), "patchHandler"))
+	mux.Handle("DELETE /todos/{id}", otelhttp.NewHandler(http.HandlerFunc(deleteHandler), "deleteHandler"))
+	mux.Handle("POST /todos/{id}/complete", otelhttp.NewHandler(http.HandlerFunc(completeHandler), "completeHandler"))
+	mux.Handle("DELETE /todos/{id}/complete", otelhttp.NewHandler(http.HandlerFunc(uncompleteHandler), "uncompleteHandler"))
+	mux.Handle("POST /todos/{id}/move", otelhttp.NewHandler(http.HandlerFunc(moveHandler), "moveHandler"))
+	mux.Handle("GET /todos/{id}/history", otelhttp.NewHandler(http.HandlerFunc(historyHandler), "historyHandler"))
+	mux.Handle("POST /todos/{id}/restore", otelhttp.NewHandler(http.HandlerFunc(restoreHandler), "restoreHandler"))
+	mux.Handle("PUT /todos/{id}/tags/{tag}", otelhttp.NewHandler(http.HandlerFunc(tagHandler), "tagHandler"))
+	mux.Handle("DELETE /todos/{id}/tags/{tag}", otelhttp.NewHandler(http.HandlerFunc(tagHandler), "tagHandler"))
+	mux.Handle("GET /trash", otelhttp.NewHandler(http.HandlerFunc(trashHandler), "trashHandler"))
+	mux.Handle("POST /trash/{id}/restore", otelhttp.NewHandler(http.HandlerFunc(untrashHandler), "untrashHandler"))
+	mux.Handle("POST /undo", otelhttp.NewHandler(http.HandlerFunc(undoHandler), "undoHandler"))
+	mux.Handle("POST /redo", otelhttp.NewHandler(http.HandlerFunc(redoHandler), "redoHandler"))
+	mux.Handle("GET /ws", otelhttp.NewHandler(http.HandlerFunc(wsHandler), "wsHandler"))
+	mux.Handle("POST /graphql", otelhttp.NewHandler(http.HandlerFunc(graphqlHandler), "graphqlHandler"))
+	mux.Handle("POST /batch", otelhttp.NewHandler(http.HandlerFunc(batchHandler), "batchHandler"))
+	mux.Handle("GET /tags", otelhttp.NewHandler(http.HandlerFunc(tagsHandler), "tagsHandler"))
+	mux.Handle("GET /projects", otelhttp.NewHandler(http.HandlerFunc(projectsHandler), "projectsHandler"))
+	mux.Handle("POST /projects", otelhttp.NewHandler(http.HandlerFunc(addProjectHandler), "addProjectHandler"))
+	mux.Handle("GET /projects/{id}", otelhttp.NewHandler(http.HandlerFunc(getProjectHandler), "getProjectHandler"))
+	mux.Handle("PUT /projects/{id}", otelhttp.NewHandler(http.HandlerFunc(renameProjectHandler), "renameProjectHandler"))
+	mux.Handle("DELETE /projects/{id}", otelhttp.NewHandler(http.HandlerFunc(deleteProjectHandler), "deleteProjectHandler"))
+
+	// Unsupported methods and unknown paths get problem+json responses too
+	mux.Handle("/todos", methodNotAllowed("GET", "POST"))
+	mux.Handle("/todos/{id}", methodNotAllowed("GET", "PUT", "PATCH", "DELETE"))
+	// A method-less pattern would conflict with GET /todos/{id}, so the fixed
+	// paths beside it get their other methods one by one
+	for _, path := range []string{"/todos/search", "/todos/next", "/todos/events"} {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace} {
+			mux.Handle(method+" "+path, methodNotAllowed("GET"))
+		}
+	}
+	mux.Handle("/todos/{id}/complete", methodNotAllowed("POST", "DELETE"))
+	mux.Handle("/todos/{id}/move", methodNotAllowed("POST"))
+	mux.Handle("/todos/{id}/history", methodNotAllowed("GET"))
+	mux.Handle("/todos/{id}/restore", methodNotAllowed("POST"))
+	mux.Handle("/todos/{id}/tags/{tag}", methodNotAllowed("PUT", "DELETE"))
+	mux.Handle("/tags", methodNotAllowed("GET"))
+	mux.Handle("/trash", methodNotAllowed("GET"))
+	mux.Handle("/trash/{id}/restore", methodNotAllowed("POST"))
+	mux.Handle("/ws", methodNotAllowed("GET"))
+	mux.Handle("/graphql", methodNotAllowed("POST"))
+	mux.Handle("/batch", methodNotAllowed("POST"))
+	mux.Handle("/undo", methodNotAllowed("POST"))
+	mux.Handle("/redo", methodNotAllowed("POST"))
+	mux.Handle("/projects", methodNotAllowed("GET", "POST"))
+	mux.Handle("/projects/{id}", methodNotAllowed("GET", "PUT", "DELETE"))
+	mux.Handle("GET /problems/{slug}", http.HandlerFunc(problemDocsHandler))
+	mux.Handle("/", http.HandlerFunc(notFoundHandler))
+
+	// Deprecated verb-style aliases, kept until clients have migrated
+	mux.Handle("/add", deprecatedRoute("/add", "/todos", otelhttp.NewHandler(http.HandlerFunc(addHandler), "addHandler")))
+	mux.Handle("/list", deprecatedRoute("/list", "/todos", otelhttp.NewHandler(http.HandlerFunc(listHandler), "listHandler")))
+	mux.Handle("/delete", deprecatedRoute("/delete", "/todos/{id}", otelhttp.NewHandler(http.HandlerFunc(deleteHandler), "deleteHandler")))
+	mux.Handle("/update", deprecatedRoute("/update", "/todos/{id}", otelhttp.NewHandler(http.HandlerFunc(updateHandler), "updateHandler")))
+	mux.Handle("/get", deprecatedRoute("/get", "/todos/{id}", otelhttp.NewHandler(http.HandlerFunc(getHandler), "getHandler")))
+	mux.Handle("/complete", deprecatedRoute("/complete", "/todos/{id}/complete", otelhttp.NewHandler(http.HandlerFunc(completeHandler), "completeHandler")))
+	mux.Handle("/uncomplete", deprecatedRoute("/uncomplete", "/todos/{id}/complete", otelhttp.NewHandler(http.HandlerFunc(uncompleteHandler), "uncompleteHandler")))
+	mux.Handle("/search", deprecatedRoute("/search", "/todos/search", otelhttp.NewHandler(http.HandlerFunc(searchHandler), "searchHandler")))
 
 	return mux
 }
 
+// deprecatedRoute marks responses from a legacy path with a Deprecation header
+// and a Link to its successor, and counts its use so migration can be tracked.
+func deprecatedRoute(path, successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		deprecatedCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("route", path)))
+		next.ServeHTTP(w, r)
+	})
+}
+
 // createServer initializes the HTTP server with configuration
 func createServer(handler http.Handler) *http.Server {
 	return &http.Server{
@@ -87,7 +223,7 @@ func startServerAsync(server *http.Server) {
 }
 
 // handleGracefulShutdown waits for termination signals and shuts down cleanly
-func handleGracefulShutdown(server *http.Server) {
+func handleGracefulShutdown(server *http.Server, grpcSrv *grpc.Server, sched *scheduler) {
 	// Wait for interrupt signal
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -106,6 +242,30 @@ func handleGracefulShutdown(server *http.Server) {
 		log.Info().Msg("Server gracefully stopped")
 	}
 
+	// End watch streams so GracefulStop does not wait for them, then stop
+	// gRPC, cutting off calls still running when the deadline passes
+	changeFeed.close()
+	stopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		log.Info().Msg("gRPC server gracefully stopped")
+	case <-ctx.Done():
+		grpcSrv.Stop()
+		log.Error().Err(ctx.Err()).Msg("gRPC server shutdown timed out")
+	}
+
+	// Stop background jobs before the store they write to
+	sched.close()
+
+	// Flush and release the task store
+	if err := store.Close(); err != nil {
+		log.Error().Err(err).Msg("Store close failed")
+	}
+
 	// Clean up OTel meter provider
 	if meterProvider != nil {
 		if err := meterProvider.Shutdown(context.Background()); err != nil {
@@ -1,14 +1,136 @@
 package main
 
+import (
+	"slices"
+	"time"
+)
+
 // ToDo represents a task item.
 type ToDo struct {
-	ID   int    `json:"id"`
-	Text string `json:"text"`
+	ID             int           `json:"id"`
+	Text           string        `json:"text"`
+	Version        int           `json:"version"` // Incremented on every change; served as the ETag
+	CreatedAt      time.Time     `json:"created_at"`
+	Completed      bool          `json:"completed"`
+	CompletedAt    *time.Time    `json:"completed_at,omitempty"`
+	CompletedBy    string        `json:"completed_by,omitempty"`
+	Due            *time.Time    `json:"due,omitempty"`             // Rendered in DueZone when set, else in the offset it was given with
+	DueZone        string        `json:"due_zone,omitempty"`        // IANA time zone name, e.g. "Europe/Berlin"
+	Overdue        bool          `json:"overdue"`                   // Open and past its due time; maintained by the store and scheduler
+	Priority       string        `json:"priority,omitempty"`        // "P0" (most urgent) to "P4"; empty when untriaged
+	Position       string        `json:"position,omitempty"`        // Fractional-index key for manual ordering; see position.go
+	Tags           []string      `json:"tags,omitempty"`            // Normalised (see normalizeTag), sorted and unique
+	Project        int           `json:"project,omitempty"`         // ID of the owning project; 0 when in no project
+	Parent         int           `json:"parent,omitempty"`          // ID of the parent task; 0 for top-level tasks
+	Progress       *TaskProgress `json:"progress,omitempty"`        // Completion of the direct children; maintained by the store
+	BlockedBy      []int         `json:"blocked_by,omitempty"`      // IDs of the tasks that must be done first, sorted
+	Blocked        bool          `json:"blocked"`                   // Some task in BlockedBy is still open; maintained by the store
+	Recurrence     string        `json:"recurrence,omitempty"`      // RRULE subset (see recurrence.go) repeating the task from its due time
+	Occurrence     int           `json:"occurrence,omitempty"`      // Number of a recurring task within its series, from 1
+	NextOccurrence int           `json:"next_occurrence,omitempty"` // ID of the occurrence created when this one was completed
+	DeletedAt      *time.Time    `json:"deleted_at,omitempty"`      // Set while the task is in the trash
+}
+
+// priorities are the accepted Priority values, most urgent first.
+var priorities = []string{"P0", "P1", "P2", "P3", "P4"}
+
+// ListFilter narrows the set of ToDo items returned by List.
+type ListFilter struct {
+	Completed *bool      // nil matches both open and completed items
+	Project   *int       // nil matches every project; 0 matches items in no project
+	Parent    *int       // nil matches every item; 0 matches top-level items
+	Overdue   *bool      // nil matches both overdue and other items
+	Blocked   *bool      // nil matches both blocked and other items
+	DependsOn *int       // Only items blocked by this item
+	DueBefore *time.Time // Only items due strictly before this time
+	DueAfter  *time.Time // Only items due strictly after this time
+	TagsAny   []string   // Items with at least one of these tags
+	TagsAll   []string   // Items with every one of these tags
+	TagsNone  []string   // Items with none of these tags
+}
+
+// matches reports whether the todo satisfies the filter.
+func (f ListFilter) matches(todo ToDo) bool {
+	if f.Completed != nil && todo.Completed != *f.Completed {
+		return false
+	}
+	if f.Project != nil && todo.Project != *f.Project {
+		return false
+	}
+	if f.Parent != nil && todo.Parent != *f.Parent {
+		return false
+	}
+	if f.Blocked != nil && todo.Blocked != *f.Blocked {
+		return false
+	}
+	if f.DependsOn != nil && !slices.Contains(todo.BlockedBy, *f.DependsOn) {
+		return false
+	}
+	if f.Overdue != nil && todo.Overdue != *f.Overdue {
+		return false
+	}
+	if f.DueBefore != nil && (todo.Due == nil || !todo.Due.Before(*f.DueBefore)) {
+		return false
+	}
+	if f.DueAfter != nil && (todo.Due == nil || !todo.Due.After(*f.DueAfter)) {
+		return false
+	}
+	if len(f.TagsAny) > 0 && !slices.ContainsFunc(f.TagsAny, todo.hasTag) {
+		return false
+	}
+	for _, tag := range f.TagsAll {
+		if !todo.hasTag(tag) {
+			return false
+		}
+	}
+	if slices.ContainsFunc(f.TagsNone, todo.hasTag) {
+		return false
+	}
+	return true
+}
+
+func (todo ToDo) hasTag(tag string) bool {
+	_, found := slices.BinarySearch(todo.Tags, tag)
+	return found
+}
+
+// TagCount is one entry of GET /tags.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Project is a named list that owns tasks.
+type Project struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"` // Unique, ignoring case
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProjectCounts tallies the tasks of a project.
+type ProjectCounts struct {
+	Total     int `json:"total"`
+	Open      int `json:"open"`
+	Completed int `json:"completed"`
+	Overdue   int `json:"overdue"`
+}
+
+// count adds a task to the tally.
+func (c *ProjectCounts) count(todo ToDo) {
+	c.Total++
+	if todo.Completed {
+		c.Completed++
+	} else {
+		c.Open++
+	}
+	if todo.Overdue {
+		c.Overdue++
+	}
 }
 
-// CompletedToDo represents a completed task item.
-type CompletedToDo struct {
-	ID        int    `json:"id"`
-	Text      string `json:"text"`
-	Completed bool   `json:"completed"`
+// ProjectSummary is a project with its task counts, as served by the API.
+type ProjectSummary struct {
+	Project
+	Counts ProjectCounts `json:"counts"`
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// sortField is an ordering accepted by the sort= query parameter.
+type sortField struct {
+	// compare orders two items by this field only.
+	compare func(a, b ToDo) int
+	// keep copies the fields compare reads into a cursor key.
+	keep func(dst *ToDo, src ToDo)
+}
+
+// sortFields lists the fields clients can sort by.
+var sortFields = map[string]sortField{
+	"id": {
+		compare: func(a, b ToDo) int { return cmp.Compare(a.ID, b.ID) },
+		keep:    func(dst *ToDo, src ToDo) {},
+	},
+	"created": {
+		compare: func(a, b ToDo) int { return a.CreatedAt.Compare(b.CreatedAt) },
+		keep:    func(dst *ToDo, src ToDo) { dst.CreatedAt = src.CreatedAt },
+	},
+	"due": {
+		compare: compareDue,
+		keep:    func(dst *ToDo, src ToDo) { dst.Due = src.Due },
+	},
+	"priority": {
+		compare: comparePriority,
+		keep:    func(dst *ToDo, src ToDo) { dst.Priority = src.Priority },
+	},
+	"position": {
+		compare: comparePosition,
+		keep:    func(dst *ToDo, src ToDo) { dst.Position = src.Position },
+	},
+}
+
+// comparePriority orders P0 first; untriaged items sort after P4.
+func comparePriority(a, b ToDo) int {
+	rank := func(p string) int {
+		if i := slices.Index(priorities, p); i >= 0 {
+			return i
+		}
+		return len(priorities)
+	}
+	return cmp.Compare(rank(a.Priority), rank(b.Priority))
+}
+
+// comparePosition orders by manual position; items stored before positions
+// existed sort last, by ID.
+func comparePosition(a, b ToDo) int {
+	switch {
+	case a.Position == "" && b.Position == "":
+		return cmp.Compare(a.ID, b.ID)
+	case a.Position == "":
+		return 1
+	case b.Position == "":
+		return -1
+	}
+	return strings.Compare(a.Position, b.Position)
+}
+
+// compareDue orders by due time; items without one sort after all others.
+func compareDue(a, b ToDo) int {
+	switch {
+	case a.Due == nil && b.Due == nil:
+		return 0
+	case a.Due == nil:
+		return 1
+	case b.Due == nil:
+		return -1
+	}
+	return a.Due.Compare(*b.Due)
+}
+
+// sortTerm is one field of a sort order, optionally descending ("-created").
+type sortTerm struct {
+	field string
+	desc  bool
+}
+
+// sortOrder is a comma-separated list of sort terms. Items that tie on every
+// term are ordered by ID so that pages are stable.
+type sortOrder []sortTerm
+
+// parseSortOrder parses the sort= query parameter, defaulting to ID order.
+func parseSortOrder(value string) (sortOrder, error) {
+	if value == "" {
+		return sortOrder{{field: "id"}}, nil
+	}
+	var order sortOrder
+	for _, part := range strings.Split(value, ",") {
+		term := sortTerm{field: strings.TrimSpace(part)}
+		if rest, ok := strings.CutPrefix(term.field, "-"); ok {
+			term.field, term.desc = rest, true
+		}
+		if _, ok := sortFields[term.field]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q", term.field)
+		}
+		order = append(order, term)
+	}
+	return order, nil
+}
+
+func (o sortOrder) String() string {
+	parts := make([]string, len(o))
+	for i, term := range o {
+		if term.desc {
+			parts[i] = "-" + term.field
+		} else {
+			parts[i] = term.field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (o sortOrder) compare(a, b ToDo) int {
+	for _, term := range o {
+		c := sortFields[term.field].compare(a, b)
+		if term.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// key reduces an item to the fields the order compares, for use in a cursor.
+func (o sortOrder) key(todo ToDo) ToDo {
+	key := ToDo{ID: todo.ID}
+	for _, term := range o {
+		sortFields[term.field].keep(&key, todo)
+	}
+	return key
+}
+
+// listCursor is the decoded form of the opaque cursor= parameter: the sort
+// order it was issued for and the key of the last item already returned.
+type listCursor struct {
+	Sort  string `json:"s"`
+	After ToDo   `json:"a"`
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string, order sortOrder) (*ToDo, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	if c.Sort != order.String() {
+		return nil, fmt.Errorf("cursor was issued for sort %q, not %q", c.Sort, order.String())
+	}
+	return &c.After, nil
+}
+
+// pageRequest holds the parsed pagination parameters of a list request.
+type pageRequest struct {
+	order sortOrder
+	after *ToDo
+	limit int
+}
+
+// parsePageRequest reads sort=, limit= and cursor= from the query string.
+func parsePageRequest(query url.Values) (pageRequest, error) {
+	var req pageRequest
+	var err error
+	if req.order, err = parseSortOrder(query.Get("sort")); err != nil {
+		return req, err
+	}
+	req.limit = defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		req.limit, err = strconv.Atoi(value)
+		if err != nil || req.limit < 1 || req.limit > maxPageSize {
+			return req, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	req.after, err = decodeCursor(query.Get("cursor"), req.order)
+	return req, err
+}
+
+// paginate sorts todos and returns the page following the cursor, plus the
+// cursor for the next page ("" on the last page).
+func paginate(todos []ToDo, req pageRequest) ([]ToDo, string) {
+	slices.SortFunc(todos, req.order.compare)
+	start := 0
+	if req.after != nil {
+		start, _ = slices.BinarySearchFunc(todos, *req.after, func(todo, after ToDo) int {
+			if req.order.compare(todo, after) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	end := min(start+req.limit, len(todos))
+	page := todos[start:end]
+	if end == len(todos) {
+		return page, ""
+	}
+	return page, encodeCursor(listCursor{Sort: req.order.String(), After: req.order.key(page[len(page)-1])})
+}
+
+// setPageLinks advertises the next page through the Link and X-Next-Cursor headers.
+func setPageLinks(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	nextURL := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Add("Link", "<"+nextURL.String()+">; rel=\"next\"")
+	w.Header().Set("X-Next-Cursor", next)
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media types accepted by PATCH /todos/{id}.
+const (
+	mergePatchMediaType = "application/merge-patch+json" // RFC 7396
+	jsonPatchMediaType  = "application/json-patch+json"  // RFC 6902
+
+	acceptPatch = mergePatchMediaType + ", " + jsonPatchMediaType
+)
+
+// errMalformedPatch is returned for patch documents that are not valid
+// merge patches or JSON Patch operation lists.
+var errMalformedPatch = errors.New("malformed patch document")
+
+// fieldError reports a problem with one location of a task document,
+// identified by a JSON Pointer. Code is a stable, machine-readable reason.
+type fieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (e fieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// validationErrors is returned when a task document fails validation.
+type validationErrors []fieldError
+
+func (errs validationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// maxPatchAttempts bounds how often an unconditional PATCH is re-applied
+// when the item changes between reading and writing it.
+const maxPatchAttempts = 3
+
+// patchTodo applies a patch document to the current state of a task and
+// stores the result. With ifVersion set the patch only applies to that
+// version; otherwise it is re-applied to the latest state on conflicts.
+func patchTodo(ctx context.Context, id, ifVersion int, apply func(doc, patch []byte) ([]byte, error), patch []byte, by string) (ToDo, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := store.Get(ctx, id)
+		if err != nil {
+			return ToDo{}, err
+		}
+		if ifVersion != 0 && current.Version != ifVersion {
+			return ToDo{}, ErrVersionMismatch
+		}
+		doc, err := json.Marshal(current)
+		if err != nil {
+			return ToDo{}, err
+		}
+		patched, err := apply(doc, patch)
+		if err != nil {
+			return ToDo{}, err
+		}
+		edited, errs := decodeTaskDocument(current, patched)
+		if len(errs) > 0 {
+			slices.SortFunc(errs, func(a, b fieldError) int { return strings.Compare(a.Path, b.Path) })
+			return ToDo{}, validationErrors(errs)
+		}
+		updated, err := store.Replace(ctx, edited, by, current.Version)
+		if errors.Is(err, ErrVersionMismatch) && ifVersion == 0 && attempt < maxPatchAttempts {
+			continue
+		}
+		return updated, err
+	}
+}
+
+// documentField describes one member of the task document as seen by PATCH.
+// Read-only fields may be left untouched but not changed; editable fields
+// are decoded into the ToDo passed to Store.Replace.
+type documentField struct {
+	readOnly bool
+	decode   func(raw json.RawMessage, todo *ToDo) *fieldError // Path defaults to the member's
+}
+
+// documentFields lists every member of the JSON task document.
+var documentFields = map[string]documentField{
+	"id":           {readOnly: true},
+	"version":      {readOnly: true},
+	"created_at":   {readOnly: true},
+	"completed_at": {readOnly: true},
+	"completed_by": {readOnly: true},
+	"text": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil || bytes.Equal(raw, []byte("null")) {
+			return &fieldError{Message: "must be a string", Code: "invalid_type"}
+		}
+		text, ferr := normalizeText("text", text)
+		if ferr != nil {
+			return ferr
+		}
+		todo.Text = text
+		return nil
+	}},
+	"completed": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if err := json.Unmarshal(raw, &todo.Completed); err != nil || bytes.Equal(raw, []byte("null")) {
+			return &fieldError{Message: "must be a boolean", Code: "invalid_type"}
+		}
+		return nil
+	}},
+	"overdue":  {readOnly: true},
+	"position": {readOnly: true}, // Changed through POST /todos/{id}/move
+	"tags": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.Tags = nil
+			return nil
+		}
+		return decodeTags(raw, todo)
+	}},
+	"progress":        {readOnly: true},
+	"blocked":         {readOnly: true},
+	"occurrence":      {readOnly: true},
+	"next_occurrence": {readOnly: true},
+	"deleted_at":      {readOnly: true},
+	"recurrence": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.Recurrence = ""
+			return nil
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return &fieldError{Message: "must be an RRULE string or null", Code: "invalid_type"}
+		}
+		rule, err := parseRecurrence(value)
+		if err != nil {
+			return &fieldError{Message: err.Error(), Code: "invalid_format"}
+		}
+		todo.Recurrence = rule.String()
+		return nil
+	}},
+	"blocked_by": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.BlockedBy = nil
+			return nil
+		}
+		var ids []int
+		if err := json.Unmarshal(raw, &ids); err != nil {
+			return &fieldError{Message: "must be an array of task IDs or null", Code: "invalid_type"}
+		}
+		for i, id := range ids {
+			if id <= 0 {
+				return &fieldError{Path: "/blocked_by/" + strconv.Itoa(i), Message: "must be a positive task ID", Code: "invalid_value"}
+			}
+		}
+		slices.Sort(ids)
+		ids = slices.Compact(ids)
+		if len(ids) == 0 {
+			ids = nil
+		}
+		todo.BlockedBy = ids // Existence and cycles are checked by the store
+		return nil
+	}},
+	"parent": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.Parent = 0
+			return nil
+		}
+		var parent int
+		if err := json.Unmarshal(raw, &parent); err != nil {
+			return &fieldError{Message: "must be a task ID or null", Code: "invalid_type"}
+		}
+		if parent <= 0 {
+			return &fieldError{Message: "must be a positive task ID", Code: "invalid_value"}
+		}
+		todo.Parent = parent // Existence and cycles are checked by the store
+		return nil
+	}},
+	"project": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.Project = 0
+			return nil
+		}
+		var project int
+		if err := json.Unmarshal(raw, &project); err != nil {
+			return &fieldError{Message: "must be a project ID or null", Code: "invalid_type"}
+		}
+		if project <= 0 {
+			return &fieldError{Message: "must be a positive project ID", Code: "invalid_value"}
+		}
+		todo.Project = project // Existence is checked by the store
+		return nil
+	}},
+	"priority": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.Priority = ""
+			return nil
+		}
+		var priority string
+		if err := json.Unmarshal(raw, &priority); err != nil {
+			return &fieldError{Message: "must be a string or null", Code: "invalid_type"}
+		}
+		if !slices.Contains(priorities, priority) {
+			return &fieldError{Message: "must be one of " + strings.Join(priorities, ", "), Code: "invalid_value"}
+		}
+		todo.Priority = priority
+		return nil
+	}},
+	"due": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.Due = nil
+			return nil
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return &fieldError{Message: "must be a timestamp string or null", Code: "invalid_type"}
+		}
+		due, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return &fieldError{Message: "must be an RFC 3339 timestamp with a UTC offset, e.g. 2026-10-20T09:00:00+02:00", Code: "invalid_format"}
+		}
+		todo.Due = &due
+		return nil
+	}},
+	"due_zone": {decode: func(raw json.RawMessage, todo *ToDo) *fieldError {
+		if bytes.Equal(raw, []byte("null")) {
+			todo.DueZone = ""
+			return nil
+		}
+		var zone string
+		if err := json.Unmarshal(raw, &zone); err != nil {
+			return &fieldError{Message: "must be a time zone name or null", Code: "invalid_type"}
+		}
+		if _, err := time.LoadLocation(zone); err != nil || zone == "Local" {
+			return &fieldError{Message: fmt.Sprintf("unknown time zone %q", zone), Code: "invalid_format"}
+		}
+		todo.DueZone = zone
+		return nil
+	}},
+}
+
+// requiredFields must be present in a patched document. Other editable
+// fields are optional; leaving one out clears it.
+var requiredFields = []string{"text", "completed"}
+
+// decodeTaskDocument validates a patched task document against the current
+// item and returns the edited ToDo, or one error per offending path.
+func decodeTaskDocument(current ToDo, doc []byte) (ToDo, []fieldError) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &members); err != nil || members == nil {
+		return ToDo{}, []fieldError{{Path: "", Message: "patched document must be a JSON object", Code: "invalid_type"}}
+	}
+	original, _ := json.Marshal(current)
+	var originalMembers map[string]json.RawMessage
+	json.Unmarshal(original, &originalMembers)
+
+	edited := current
+	var errs []fieldError
+	for name, raw := range members {
+		path := "/" + escapePointer(name)
+		field, known := documentFields[name]
+		switch {
+		case !known:
+			errs = append(errs, fieldError{Path: path, Message: "unknown field", Code: "unknown_field"})
+		case field.readOnly:
+			if !jsonEqual(raw, originalMembers[name]) {
+				errs = append(errs, fieldError{Path: path, Message: "field is read-only", Code: "read_only"})
+			}
+		default:
+			if ferr := field.decode(raw, &edited); ferr != nil {
+				if ferr.Path == "" {
+					ferr.Path = path
+				}
+				errs = append(errs, *ferr)
+			}
+		}
+	}
+	for name := range originalMembers {
+		if _, present := members[name]; !present && documentFields[name].readOnly {
+			errs = append(errs, fieldError{Path: "/" + escapePointer(name), Message: "field is read-only and cannot be removed", Code: "read_only"})
+		}
+	}
+	for name, field := range documentFields {
+		if _, present := members[name]; present || field.readOnly {
+			continue
+		}
+		if slices.Contains(requiredFields, name) {
+			errs = append(errs, fieldError{Path: "/" + name, Message: "field is required", Code: "required"})
+		} else {
+			field.decode(json.RawMessage("null"), &edited)
+		}
+	}
+	if ferr := normalizeDue(&edited); ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	return edited, errs
+}
+
+// jsonEqual compares two JSON values semantically (a missing value equals null).
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb any
+	if len(a) > 0 {
+		json.Unmarshal(a, &va)
+	}
+	if len(b) > 0 {
+		json.Unmarshal(b, &vb)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func applyMergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedPatch, err)
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergePatch(targetObj[name], value)
+		}
+	}
+	return targetObj
+}
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch document.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to doc. Patch documents that
+// are not an array of operations return a plain error; an operation that
+// cannot be applied returns a fieldError naming its path.
+func applyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedPatch, err)
+	}
+	for i, op := range ops {
+		if err := validatePatchOp(op); err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", errMalformedPatch, i, err)
+		}
+	}
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		var err error
+		target, err = applyPatchOp(target, op)
+		if err != nil {
+			return nil, fieldError{Path: op.Path, Message: fmt.Sprintf("operation %d (%s): %v", i, op.Op, err)}
+		}
+	}
+	return json.Marshal(target)
+}
+
+// validatePatchOp checks an operation's shape before anything is applied.
+func validatePatchOp(op jsonPatchOp) error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%s requires a value", op.Op)
+		}
+	case "move", "copy":
+		if _, err := parsePointer(op.From); err != nil || op.From == "" && op.Op == "move" {
+			return fmt.Errorf("%s requires a valid from pointer", op.Op)
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+	_, err := parsePointer(op.Path)
+	return err
+}
+
+func applyPatchOp(doc any, op jsonPatchOp) (any, error) {
+	var value any
+	if op.Value != nil {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, op.Path, value)
+	case "remove":
+		doc, _, err := pointerRemove(doc, op.Path)
+		return doc, err
+	case "replace":
+		if op.Path == "" {
+			return value, nil // Replaces the whole document
+		}
+		if _, err := pointerGet(doc, op.Path); err != nil {
+			return nil, err
+		}
+		doc, _, err := pointerRemove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, value)
+	case "move":
+		if op.Path == op.From || strings.HasPrefix(op.Path, op.From+"/") {
+			if op.Path != op.From {
+				return nil, fmt.Errorf("cannot move a value into one of its children")
+			}
+			return doc, nil
+		}
+		doc, moved, err := pointerRemove(doc, op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		return pointerAdd(doc, op.Path, moved)
+	case "copy":
+		copied, err := pointerGet(doc, op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		return pointerAdd(doc, op.Path, deepCopy(copied))
+	case "test":
+		current, err := pointerGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// arrayIndex resolves an array index token; "-" (append) is allowed when forAdd.
+func arrayIndex(token string, length int, forAdd bool) (int, error) {
+	if forAdd && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	idx, err := strconv.Atoi(token)
+	limit := length - 1
+	if forAdd {
+		limit = length
+	}
+	if err != nil || idx < 0 || idx > limit {
+		return 0, fmt.Errorf("array index %q out of range", token)
+	}
+	return idx, nil
+}
+
+func pointerGet(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			current = value
+		case []any:
+			idx, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[idx]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return current, nil
+}
+
+// pointerAdd inserts value at pointer and returns the (possibly new) root.
+func pointerAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, tokens, func(parent any, last string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[last] = value
+			return node, nil
+		case []any:
+			idx, err := arrayIndex(last, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[idx+1:], node[idx:])
+			node[idx] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	})
+}
+
+// pointerRemove deletes the value at pointer, returning the new root and the removed value.
+func pointerRemove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	var removed any
+	root, err := updateParent(doc, tokens, func(parent any, last string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			value, ok := node[last]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			removed = value
+			delete(node, last)
+			return node, nil
+		case []any:
+			idx, err := arrayIndex(last, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[idx]
+			return append(node[:idx], node[idx+1:]...), nil
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	})
+	return root, removed, err
+}
+
+// updateParent walks to the parent of the last token, lets fn modify it and
+// writes the result back up the tree (arrays may be reallocated).
+func updateParent(doc any, tokens []string, fn func(parent any, last string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		updated, err := updateParent(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[tokens[0]] = updated
+		return node, nil
+	case []any:
+		idx, err := arrayIndex(tokens[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateParent(node[idx], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[idx] = updated
+		return node, nil
+	default:
+		return nil, fmt.Errorf("path not found")
+	}
+}
+
+func deepCopy(value any) any {
+	data, _ := json.Marshal(value)
+	var copied any
+	json.Unmarshal(data, &copied)
+	return copied
+}
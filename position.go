@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Positions are fractional-index keys: strings that sort lexicographically
+// and always leave room for another key between any two of them, so moving
+// an item rewrites only that item. A key is an integer part, whose length is
+// encoded by its first character ('a' = 2 characters, 'b' = 3, ...; 'Z' = 2,
+// 'Y' = 3, ... for the negative side), followed by an optional base-62
+// fraction without trailing zeros. Appending increments the integer part,
+// so keys grow logarithmically with the number of appends.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const (
+	positionZero     = "a0"
+	positionSmallest = "A" + "00000000000000000000000000" // Reserved so there is always room before the first key
+)
+
+var errInvalidPosition = errors.New("invalid position key")
+
+// positionBetween returns a key that sorts strictly between a and b. Either
+// may be "" for an open end: positionBetween(last, "") appends and
+// positionBetween("", first) prepends.
+func positionBetween(a, b string) (string, error) {
+	for _, key := range []string{a, b} {
+		if key != "" && !validPosition(key) {
+			return "", errInvalidPosition
+		}
+	}
+	if a != "" && b != "" && a >= b {
+		return "", errInvalidPosition
+	}
+	switch {
+	case a == "" && b == "":
+		return positionZero, nil
+	case a == "":
+		ib := integerPart(b)
+		if ib == positionSmallest {
+			return ib + positionMidpoint("", b[len(ib):], true), nil
+		}
+		if ib < b {
+			return ib, nil
+		}
+		if i, ok := decrementInteger(ib); ok {
+			return i, nil
+		}
+		return "", errInvalidPosition
+	case b == "":
+		ia := integerPart(a)
+		if i, ok := incrementInteger(ia); ok {
+			return i, nil
+		}
+		return ia + positionMidpoint(a[len(ia):], "", false), nil
+	}
+	ia, ib := integerPart(a), integerPart(b)
+	if ia == ib {
+		return ia + positionMidpoint(a[len(ia):], b[len(ib):], true), nil
+	}
+	i, ok := incrementInteger(ia)
+	if !ok {
+		return "", errInvalidPosition
+	}
+	if i < b {
+		return i, nil
+	}
+	return ia + positionMidpoint(a[len(ia):], "", false), nil
+}
+
+// positionMidpoint returns a fraction between fractions a and b (b unbounded
+// unless hasB); neither has trailing zeros and a < b.
+func positionMidpoint(a, b string, hasB bool) string {
+	if hasB {
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + positionMidpoint(suffix(a, n), b[n:], true)
+		}
+	}
+	da := 0
+	if a != "" {
+		da = strings.IndexByte(positionDigits, a[0])
+	}
+	db := len(positionDigits)
+	if hasB && b != "" {
+		db = strings.IndexByte(positionDigits, b[0])
+	}
+	if db-da > 1 {
+		return string(positionDigits[(da+db+1)/2])
+	}
+	if hasB && len(b) > 1 {
+		return b[:1]
+	}
+	return string(positionDigits[da]) + positionMidpoint(suffix(a, 1), "", false)
+}
+
+func digitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return '0'
+}
+
+func suffix(s string, n int) string {
+	if n >= len(s) {
+		return ""
+	}
+	return s[n:]
+}
+
+// integerLength is the length of the integer part introduced by head, or 0.
+func integerLength(head byte) int {
+	switch {
+	case head >= 'a' && head <= 'z':
+		return int(head-'a') + 2
+	case head >= 'A' && head <= 'Z':
+		return int('Z'-head) + 2
+	}
+	return 0
+}
+
+func integerPart(key string) string {
+	return key[:integerLength(key[0])]
+}
+
+func validPosition(key string) bool {
+	n := integerLength(key[0])
+	if n == 0 || len(key) < n || key == positionSmallest {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		if strings.IndexByte(positionDigits, key[i]) < 0 {
+			return false
+		}
+	}
+	return !strings.HasSuffix(key[n:], "0")
+}
+
+func incrementInteger(x string) (string, bool) {
+	head, digits := x[0], []byte(x[1:])
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := strings.IndexByte(positionDigits, digits[i]) + 1
+		if d < len(positionDigits) {
+			digits[i] = positionDigits[d]
+			return string(head) + string(digits), true
+		}
+		digits[i] = '0'
+	}
+	// Every digit carried: move to the next integer length.
+	switch head {
+	case 'Z':
+		return positionZero, true
+	case 'z':
+		return "", false
+	}
+	head++
+	if head > 'a' {
+		digits = append(digits, '0')
+	} else {
+		digits = digits[:len(digits)-1]
+	}
+	return string(head) + string(digits), true
+}
+
+func decrementInteger(x string) (string, bool) {
+	head, digits := x[0], []byte(x[1:])
+	top := positionDigits[len(positionDigits)-1]
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := strings.IndexByte(positionDigits, digits[i]) - 1
+		if d >= 0 {
+			digits[i] = positionDigits[d]
+			return string(head) + string(digits), true
+		}
+		digits[i] = top
+	}
+	switch head {
+	case 'a':
+		return "Z" + string(top), true
+	case 'A':
+		return "", false
+	}
+	head--
+	if head < 'Z' {
+		digits = append(digits, top)
+	} else {
+		digits = digits[:len(digits)-1]
+	}
+	return string(head) + string(digits), true
+}
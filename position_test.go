@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestPositionBetween(t *testing.T) {
+	cases := []struct{ a, b, want string }{
+		{"", "", "a0"},
+		{"a0", "", "a1"},
+		{"az", "", "b00"},
+		{"", "a0", "Zz"},
+		{"a0", "a1", "a0V"},
+		{"a0V", "a1", "a0l"},
+		{"Zz", "a0", "ZzV"},
+	}
+	for _, tc := range cases {
+		if got, err := positionBetween(tc.a, tc.b); err != nil || got != tc.want {
+			t.Errorf("positionBetween(%q, %q) = %q, %v, want %q", tc.a, tc.b, got, err, tc.want)
+		}
+	}
+	for _, bad := range [][2]string{{"a1", "a0"}, {"a0", "a0"}, {"a10", ""}, {"!", ""}} {
+		if _, err := positionBetween(bad[0], bad[1]); err == nil {
+			t.Errorf("positionBetween(%q, %q) succeeded", bad[0], bad[1])
+		}
+	}
+
+	// Random inserts keep every key valid, unique and in order, and appends stay short.
+	rng := rand.New(rand.NewSource(1))
+	keys := []string{}
+	for range 2000 {
+		i := rng.Intn(len(keys) + 1)
+		var lo, hi string
+		if i > 0 {
+			lo = keys[i-1]
+		}
+		if i < len(keys) {
+			hi = keys[i]
+		}
+		key, err := positionBetween(lo, hi)
+		if err != nil || (lo != "" && key <= lo) || (hi != "" && key >= hi) || !validPosition(key) {
+			t.Fatalf("positionBetween(%q, %q) = %q, %v", lo, hi, key, err)
+		}
+		keys = slices.Insert(keys, i, key)
+	}
+	last := ""
+	for range 10000 {
+		last, _ = positionBetween(last, "")
+	}
+	if len(last) > 4 {
+		t.Errorf("10000 appends produced key %q", last)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// problemTypeBase prefixes every problem type URI. GET /problems/{slug}
+// serves the catalogue entry, so type URIs resolve to documentation.
+const problemTypeBase = "/problems/"
+
+// problemType is an entry of the error catalogue. Clients should branch on
+// the type URI; titles are stable but meant for humans.
+type problemType struct {
+	slug        string
+	title       string
+	status      int
+	description string
+}
+
+// URI returns the problem's stable type identifier.
+func (p problemType) URI() string {
+	return problemTypeBase + p.slug
+}
+
+// The error catalogue. Every error response is one of these.
+var (
+	problemInvalidID = problemType{"invalid-id", "Invalid task ID", http.StatusBadRequest,
+		"The task or project ID in the path or id query parameter is not an integer."}
+	problemInvalidBody = problemType{"invalid-body", "Invalid request body", http.StatusBadRequest,
+		"The request body could not be read or is not the expected JSON document."}
+	problemInvalidParameter = problemType{"invalid-parameter", "Invalid query parameter", http.StatusBadRequest,
+		"A query parameter is missing or has an unsupported value."}
+	problemMalformedPatch = problemType{"malformed-patch", "Malformed patch document", http.StatusBadRequest,
+		"The PATCH body is not a valid JSON Merge Patch or JSON Patch document."}
+	problemPayloadTooLarge = problemType{"payload-too-large", "Request body too large", http.StatusRequestEntityTooLarge,
+		"The request body exceeds the configured size limit (TODO_MAX_BODY_BYTES)."}
+	problemNotFound = problemType{"not-found", "Task not found", http.StatusNotFound,
+		"No task exists with the requested ID."}
+	problemProjectNotFound = problemType{"project-not-found", "Project not found", http.StatusNotFound,
+		"No project exists with the requested ID."}
+	problemRouteNotFound = problemType{"route-not-found", "Resource not found", http.StatusNotFound,
+		"The requested path is not part of the API."}
+	problemMethodNotAllowed = problemType{"method-not-allowed", "Method not allowed", http.StatusMethodNotAllowed,
+		"The resource does not support the request method; see the Allow header."}
+	problemPatchConflict = problemType{"patch-conflict", "Patch could not be applied", http.StatusConflict,
+		"A patch operation conflicts with the current state of the task, e.g. a failed test operation or a missing path."}
+	problemProjectExists = problemType{"project-exists", "Project name in use", http.StatusConflict,
+		"Another project already has this name; names are compared ignoring case."}
+	problemProjectNotEmpty = problemType{"project-not-empty", "Project has tasks", http.StatusConflict,
+		"A project can only be deleted once none of its tasks remain; move or delete them first."}
+	problemHasSubtasks = problemType{"has-subtasks", "Task has subtasks", http.StatusConflict,
+		"A task can only be deleted once it has no subtasks; delete them or move them to another parent first."}
+	problemRestoreConflict = problemType{"restore-conflict", "Task cannot be restored", http.StatusConflict,
+		"The deleted task belongs to a project or parent task that no longer exists; restore or recreate it first."}
+	problemNothingToUndo = problemType{"nothing-to-undo", "Nothing to undo", http.StatusConflict,
+		"The session (X-Session-ID, or X-User without one) has no recorded change left to undo or redo."}
+	problemUndoConflict = problemType{"undo-conflict", "Change cannot be undone", http.StatusConflict,
+		"The task has changed since the operation being undone or redone, or it was purged; the operation is dropped from the session's log."}
+	problemBlocked = problemType{"task-blocked", "Task is blocked", http.StatusConflict,
+		"The task is blocked by open tasks (see blocked_by); complete them first, or POST /todos/{id}/complete?force=true to complete it anyway."}
+	problemInvalidQuery = problemType{"invalid-query", "Invalid GraphQL query", http.StatusBadRequest,
+		"The GraphQL document does not parse or does not validate against the schema; the errors list each problem with its location."}
+	problemQueryTooComplex = problemType{"query-too-complex", "GraphQL query too complex", http.StatusBadRequest,
+		"The estimated cost of the GraphQL operation exceeds the configured limit (TODO_GRAPHQL_MAX_COMPLEXITY); select fewer fields or pass a smaller first argument."}
+	problemBatchAborted = problemType{"batch-aborted", "Batch operation not applied", http.StatusFailedDependency,
+		"Another operation of the atomic batch failed, so the whole batch was rolled back; fix that operation and resend the batch."}
+	problemPreconditionFailed = problemType{"precondition-failed", "Task has been modified", http.StatusPreconditionFailed,
+		"The If-Match header does not match the task's current ETag; fetch the latest version and retry."}
+	problemUnsupportedMediaType = problemType{"unsupported-media-type", "Unsupported media type", http.StatusUnsupportedMediaType,
+		"The Content-Type of the request is not supported; see the Accept-Patch header for PATCH."}
+	problemValidation = problemType{"validation-failed", "Validation failed", http.StatusUnprocessableEntity,
+		"The task document is invalid; the errors member lists each offending field by JSON Pointer, with a machine-readable code."}
+	problemInternal = problemType{"internal", "Internal server error", http.StatusInternalServerError,
+		"The server failed to process the request; the trace_id member identifies it in Jaeger."}
+)
+
+// problemCatalogue indexes the catalogue by slug for GET /problems/{slug}.
+var problemCatalogue = map[string]problemType{}
+
+func init() {
+	for _, p := range []problemType{
+		problemInvalidID, problemInvalidBody, problemInvalidParameter, problemMalformedPatch,
+		problemPayloadTooLarge, problemNotFound, problemProjectNotFound, problemRouteNotFound, problemMethodNotAllowed,
+		problemPatchConflict, problemProjectExists, problemProjectNotEmpty, problemHasSubtasks, problemBlocked,
+		problemRestoreConflict, problemNothingToUndo, problemUndoConflict, problemInvalidQuery, problemQueryTooComplex, problemBatchAborted, problemPreconditionFailed, problemUnsupportedMediaType, problemValidation, problemInternal,
+	} {
+		problemCatalogue[p.slug] = p
+	}
+}
+
+// problemDetails is an RFC 7807 application/problem+json body.
+type problemDetails struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	TraceID  string       `json:"trace_id,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// storeProblem maps a Store error onto the catalogue.
+func storeProblem(err error) problemType {
+	switch {
+	case errors.Is(err, ErrUndoConflict): // Wraps the store error that caused it
+		return problemUndoConflict
+	case errors.Is(err, ErrNotFound):
+		return problemNotFound
+	case errors.Is(err, ErrVersionMismatch):
+		return problemPreconditionFailed
+	case errors.Is(err, ErrProjectNotFound):
+		return problemProjectNotFound
+	case errors.Is(err, ErrProjectExists):
+		return problemProjectExists
+	case errors.Is(err, ErrProjectNotEmpty):
+		return problemProjectNotEmpty
+	case errors.Is(err, ErrHasSubtasks):
+		return problemHasSubtasks
+	case errors.Is(err, ErrBlocked):
+		return problemBlocked
+	case errors.Is(err, ErrRestoreConflict):
+		return problemRestoreConflict
+	case errors.Is(err, ErrNothingToUndo):
+		return problemNothingToUndo
+	default:
+		return problemInternal
+	}
+}
+
+// problemDocsHandler serves the catalogue entry behind a problem type URI.
+func problemDocsHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := problemCatalogue[r.PathValue("slug")]
+	if !ok {
+		writeProblem(w, r, problemRouteNotFound, "", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s (%d)\n\n%s\n", p.title, p.status, p.description)
+}
+
+// notFoundHandler answers paths that match no route.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemRouteNotFound, "No route for "+r.URL.Path, nil)
+}
+
+// methodNotAllowed answers any method not registered for a path with a 405
+// problem listing the allowed methods. GET routes also serve HEAD.
+func methodNotAllowed(allowed ...string) http.Handler {
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	sort.Strings(allowed)
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		writeProblem(w, r, problemMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path, nil)
+	})
+}
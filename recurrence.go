@@ -0,0 +1,334 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrenceRule is the supported subset of an iCalendar RRULE (RFC 5545):
+// DAILY, WEEKLY or MONTHLY frequency with INTERVAL, BYDAY and either COUNT
+// or UNTIL. The series starts at the first occurrence's due time, which it
+// keeps as the time of day of every later occurrence.
+type recurrenceRule struct {
+	Freq      string // "DAILY", "WEEKLY" or "MONTHLY"
+	Interval  int    // Every Interval days, weeks or months; at least 1
+	ByDay     []ruleDay
+	Count     int       // Total number of occurrences; 0 when unlimited
+	Until     time.Time // Last allowed occurrence; zero when unlimited
+	UntilDate bool      // Until is a date, compared with the occurrence's local date
+}
+
+// ruleDay is one BYDAY entry: a weekday, and for MONTHLY rules optionally
+// its N-th (1 to 5) or N-th last (-1 to -5) occurrence in the month.
+type ruleDay struct {
+	N   int
+	Day time.Weekday
+}
+
+// ruleWeekdays are the RRULE weekday codes, indexed by time.Weekday.
+var ruleWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// maxRulePeriods bounds the periods searched for the next occurrence, so a
+// rule that never matches cannot loop forever.
+const maxRulePeriods = 1000
+
+// parseRecurrence parses an RRULE value, with or without the "RRULE:" prefix.
+func parseRecurrence(s string) (recurrenceRule, error) {
+	rule := recurrenceRule{Interval: 1}
+	s = strings.TrimSpace(s)
+	if len(s) >= 6 && strings.EqualFold(s[:6], "RRULE:") {
+		s = s[6:]
+	}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || name == "" || value == "" {
+			return rule, fmt.Errorf("rule part %q is not NAME=VALUE", part)
+		}
+		if seen[name] {
+			return rule, fmt.Errorf("rule part %s is repeated", name)
+		}
+		seen[name] = true
+		switch name {
+		case "FREQ":
+			if value != "DAILY" && value != "WEEKLY" && value != "MONTHLY" {
+				return rule, fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY, got %s", value)
+			}
+			rule.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return rule, fmt.Errorf("INTERVAL must be a positive integer, got %s", value)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return rule, fmt.Errorf("COUNT must be a positive integer, got %s", value)
+			}
+			rule.Count = n
+		case "UNTIL":
+			if until, err := time.Parse("20060102", value); err == nil {
+				rule.Until, rule.UntilDate = until, true
+			} else if until, err := time.Parse("20060102T150405Z", value); err == nil {
+				rule.Until = until
+			} else {
+				return rule, fmt.Errorf("UNTIL must be a date (YYYYMMDD) or UTC time (YYYYMMDDTHHMMSSZ), got %s", value)
+			}
+		case "BYDAY":
+			for _, entry := range strings.Split(value, ",") {
+				day, err := parseRuleDay(entry)
+				if err != nil {
+					return rule, err
+				}
+				if !slices.Contains(rule.ByDay, day) {
+					rule.ByDay = append(rule.ByDay, day)
+				}
+			}
+		default:
+			return rule, fmt.Errorf("rule part %s is not supported", name)
+		}
+	}
+	switch {
+	case rule.Freq == "":
+		return rule, errors.New("FREQ is required")
+	case rule.Count > 0 && !rule.Until.IsZero():
+		return rule, errors.New("COUNT and UNTIL cannot both be set")
+	case rule.Freq != "MONTHLY" && slices.ContainsFunc(rule.ByDay, func(d ruleDay) bool { return d.N != 0 }):
+		return rule, errors.New("numbered BYDAY entries are only allowed with FREQ=MONTHLY")
+	}
+	slices.SortFunc(rule.ByDay, func(a, b ruleDay) int {
+		return cmp.Or(cmp.Compare(a.N, b.N), cmp.Compare((a.Day+6)%7, (b.Day+6)%7))
+	})
+	return rule, nil
+}
+
+// parseRuleDay parses a BYDAY entry such as "MO", "2TU" or "-1FR".
+func parseRuleDay(s string) (ruleDay, error) {
+	if len(s) < 2 {
+		return ruleDay{}, fmt.Errorf("BYDAY entry %q is not a weekday", s)
+	}
+	prefix, code := s[:len(s)-2], s[len(s)-2:]
+	day := slices.Index(ruleWeekdays, code)
+	if day < 0 {
+		return ruleDay{}, fmt.Errorf("BYDAY entry %q is not a weekday", s)
+	}
+	n := 0
+	if prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return ruleDay{}, fmt.Errorf("BYDAY entry %q must be numbered 1 to 5 or -1 to -5", s)
+		}
+	}
+	return ruleDay{N: n, Day: time.Weekday(day)}, nil
+}
+
+// String renders the rule in canonical form, e.g. "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=6".
+func (r recurrenceRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = ruleWeekdays[d.Day]
+			if d.N != 0 {
+				days[i] = strconv.Itoa(d.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	switch {
+	case r.UntilDate:
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	case !r.Until.IsZero():
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// next returns the first occurrence after prev, itself an occurrence, in
+// prev's location; it ignores COUNT and UNTIL. It returns false if none is
+// found within maxRulePeriods.
+func (r recurrenceRule) next(prev time.Time) (time.Time, bool) {
+	y, m, d := prev.Date()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, prev.Hour(), prev.Minute(), prev.Second(), prev.Nanosecond(), prev.Location())
+	}
+	for period := 0; period < maxRulePeriods; period++ {
+		var candidates []time.Time
+		switch r.Freq {
+		case "DAILY":
+			if day := at(y, m, d+period*r.Interval); r.onDay(day) {
+				candidates = append(candidates, day)
+			}
+		case "WEEKLY":
+			monday := d - int(prev.Weekday()+6)%7 + 7*period*r.Interval
+			for i := range 7 {
+				day := at(y, m, monday+i)
+				if len(r.ByDay) == 0 && day.Weekday() == prev.Weekday() || len(r.ByDay) > 0 && r.onDay(day) {
+					candidates = append(candidates, day)
+				}
+			}
+		case "MONTHLY":
+			first := at(y, m+time.Month(period*r.Interval), 1)
+			if len(r.ByDay) == 0 {
+				if day := at(first.Year(), first.Month(), d); day.Month() == first.Month() {
+					candidates = append(candidates, day) // Months without the day are skipped
+				}
+				break
+			}
+			for day := first; day.Month() == first.Month(); day = at(day.Year(), day.Month(), day.Day()+1) {
+				if r.onDay(day) {
+					candidates = append(candidates, day)
+				}
+			}
+		}
+		for _, c := range candidates {
+			if c.After(prev) {
+				return c, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// onDay reports whether day matches BYDAY; every day does without it.
+func (r recurrenceRule) onDay(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	nth, nthLast := (day.Day()-1)/7+1, -((daysInMonth-day.Day())/7 + 1)
+	return slices.ContainsFunc(r.ByDay, func(d ruleDay) bool {
+		return d.Day == day.Weekday() && (d.N == 0 || d.N == nth || d.N == nthLast)
+	})
+}
+
+// within reports whether an occurrence falls on or before UNTIL.
+func (r recurrenceRule) within(t time.Time) bool {
+	switch {
+	case r.UntilDate:
+		return t.Format("20060102") <= r.Until.Format("20060102")
+	case !r.Until.IsZero():
+		return !t.After(r.Until)
+	}
+	return true
+}
+
+// following returns the due time and series number of the occurrence that
+// follows occurrence number n, due at due, skipping occurrences that are
+// already past at now (they still count towards COUNT). It returns false
+// once the series has ended.
+func (r recurrenceRule) following(due time.Time, n int, now time.Time) (time.Time, int, bool) {
+	for {
+		next, ok := r.next(due)
+		if !ok {
+			return time.Time{}, 0, false
+		}
+		due, n = next, n+1
+		if r.Count > 0 && n > r.Count || !r.within(due) {
+			return time.Time{}, 0, false
+		}
+		if due.After(now) {
+			return due, n, true
+		}
+	}
+}
+
+// recur creates the next occurrence of a recurring item that is being
+// completed and links it from todo.NextOccurrence. Items that are not
+// recurring, already have a next occurrence or end their series are left
+// alone. The next occurrence copies the editable fields other than
+// dependencies.
+func (s *taskStore) recur(tx txn, todo *ToDo) error {
+	if todo.Recurrence == "" || todo.Due == nil || todo.NextOccurrence != 0 {
+		return nil
+	}
+	rule, err := parseRecurrence(todo.Recurrence)
+	if err != nil {
+		return err
+	}
+	due := *todo.Due
+	if todo.DueZone != "" {
+		loc, err := time.LoadLocation(todo.DueZone)
+		if err != nil {
+			return err
+		}
+		due = due.In(loc)
+	}
+	due, n, ok := rule.following(due, max(todo.Occurrence, 1), s.now())
+	if !ok {
+		return nil
+	}
+	id, err := tx.nextID()
+	if err != nil {
+		return err
+	}
+	last, err := lastPosition(tx)
+	if err != nil {
+		return err
+	}
+	position, err := positionBetween(last, "")
+	if err != nil {
+		return err
+	}
+	next := ToDo{
+		ID:         id,
+		Text:       todo.Text,
+		Version:    1,
+		CreatedAt:  s.now().UTC(),
+		Due:        &due,
+		DueZone:    todo.DueZone,
+		Priority:   todo.Priority,
+		Position:   position,
+		Tags:       todo.Tags,
+		Project:    todo.Project,
+		Parent:     todo.Parent,
+		Recurrence: todo.Recurrence,
+		Occurrence: n,
+	}
+	s.refreshOverdue(&next)
+	if err := tx.put(next); err != nil {
+		return err
+	}
+	todo.NextOccurrence = id
+	return nil
+}
+
+// dropDeletedOccurrence unlinks the next occurrence of an open item once
+// that occurrence is no longer in the store, e.g. moved to the trash.
+func dropDeletedOccurrence(tx txn, todo *ToDo) error {
+	if todo.NextOccurrence == 0 || todo.Completed {
+		return nil
+	}
+	if _, err := tx.get(todo.NextOccurrence); errors.Is(err, ErrNotFound) {
+		todo.NextOccurrence = 0
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// refreshOccurrence numbers a recurring item as the first of its series
+// unless it already has a number, and clears the number of other items.
+func refreshOccurrence(todo *ToDo) {
+	switch {
+	case todo.Recurrence == "":
+		todo.Occurrence = 0
+	case todo.Occurrence == 0:
+		todo.Occurrence = 1
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	canonical := map[string]string{
+		"FREQ=DAILY": "FREQ=DAILY",
+		"rrule:freq=weekly;byday=fr,mo;interval=1":   "FREQ=WEEKLY;BYDAY=MO,FR",
+		"FREQ=MONTHLY;BYDAY=-1FR,2TU;COUNT=6":        "FREQ=MONTHLY;BYDAY=-1FR,2TU;COUNT=6",
+		"FREQ=WEEKLY;INTERVAL=2;UNTIL=20261231":      "FREQ=WEEKLY;INTERVAL=2;UNTIL=20261231",
+		"FREQ=DAILY;UNTIL=20261231T170000Z;BYDAY=SA": "FREQ=DAILY;BYDAY=SA;UNTIL=20261231T170000Z",
+	}
+	for in, want := range canonical {
+		if rule, err := parseRecurrence(in); err != nil || rule.String() != want {
+			t.Errorf("parseRecurrence(%q) = %q, %v, want %q", in, rule, err, want)
+		}
+	}
+	for _, bad := range []string{
+		"",
+		"FREQ=YEARLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20261231",
+		"FREQ=DAILY;UNTIL=2026-12-31",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=WEEKLY;BYDAY=XX",
+	} {
+		if _, err := parseRecurrence(bad); err == nil {
+			t.Errorf("parseRecurrence(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(day string, loc *time.Location) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", day, loc)
+		return t
+	}
+	cases := []struct {
+		rule       string
+		prev, want time.Time
+	}{
+		{"FREQ=DAILY;INTERVAL=2", at("2026-10-01 09:00", time.UTC), at("2026-10-03 09:00", time.UTC)},
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", at("2026-10-02 09:00", time.UTC), at("2026-10-05 09:00", time.UTC)},
+		{"FREQ=WEEKLY", at("2026-10-01 09:00", time.UTC), at("2026-10-08 09:00", time.UTC)},
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR", at("2026-10-02 09:00", time.UTC), at("2026-10-05 09:00", time.UTC)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH", at("2026-10-01 09:00", time.UTC), at("2026-10-13 09:00", time.UTC)},
+		{"FREQ=MONTHLY", at("2026-01-31 09:00", time.UTC), at("2026-03-31 09:00", time.UTC)},
+		{"FREQ=MONTHLY;BYDAY=-1FR", at("2026-10-30 09:00", time.UTC), at("2026-11-27 09:00", time.UTC)},
+		{"FREQ=MONTHLY;INTERVAL=3;BYDAY=2TU", at("2026-10-13 09:00", time.UTC), at("2027-01-12 09:00", time.UTC)},
+		// The time of day is kept in the series' zone across the end of summer time.
+		{"FREQ=WEEKLY", at("2026-10-20 09:00", berlin), at("2026-10-27 09:00", berlin)},
+	}
+	for _, tc := range cases {
+		rule, err := parseRecurrence(tc.rule)
+		if err != nil {
+			t.Fatalf("parseRecurrence(%q): %v", tc.rule, err)
+		}
+		if got, ok := rule.next(tc.prev); !ok || !got.Equal(tc.want) {
+			t.Errorf("%s: next(%v) = %v, %v, want %v", tc.rule, tc.prev, got, ok, tc.want)
+		}
+	}
+}
+
+func TestRecurrenceFollowing(t *testing.T) {
+	due := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 10, 4, 12, 0, 0, 0, time.UTC)
+	daily, _ := parseRecurrence("FREQ=DAILY")
+	// Occurrences missed by the time the task is completed are skipped but counted.
+	if got, n, ok := daily.following(due, 1, now); !ok || n != 5 || !got.Equal(due.AddDate(0, 0, 4)) {
+		t.Errorf("following missed occurrences = %v, %d, %v, want %v, 5", got, n, ok, due.AddDate(0, 0, 4))
+	}
+	limited, _ := parseRecurrence("FREQ=DAILY;COUNT=3")
+	if _, _, ok := limited.following(due, 3, due); ok {
+		t.Error("series continued past COUNT")
+	}
+	if _, n, ok := limited.following(due, 1, due); !ok || n != 2 {
+		t.Errorf("following(occurrence 1 of 3) = %d, %v", n, ok)
+	}
+	until, _ := parseRecurrence("FREQ=DAILY;UNTIL=20261002")
+	if _, _, ok := until.following(due, 1, due); !ok {
+		t.Error("occurrence on the UNTIL date was dropped")
+	}
+	if _, _, ok := until.following(due.AddDate(0, 0, 1), 2, due); ok {
+		t.Error("series continued past UNTIL")
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// schedulerConfig controls the background scheduler.
+type schedulerConfig struct {
+	Interval       time.Duration   // How often due times and the trash are checked
+	Offsets        []time.Duration // Reminders fire this long before an item is due
+	TrashRetention time.Duration   // Deleted items are purged this long after deletion
+}
+
+func defaultSchedulerConfig() schedulerConfig {
+	return schedulerConfig{
+		Interval:       time.Minute,
+		Offsets:        []time.Duration{time.Hour},
+		TrashRetention: 30 * 24 * time.Hour,
+	}
+}
+
+// loadSchedulerConfig applies TODO_SCHEDULER_INTERVAL, TODO_TRASH_RETENTION
+// and TODO_REMINDER_OFFSETS (comma-separated durations, e.g. "24h,1h,15m";
+// "none" disables reminders) over the defaults.
+func loadSchedulerConfig(getenv func(string) string) (schedulerConfig, error) {
+	cfg := defaultSchedulerConfig()
+	if v := getenv("TODO_SCHEDULER_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("TODO_SCHEDULER_INTERVAL must be a positive duration, got %q", v)
+		}
+		cfg.Interval = interval
+	}
+	if v := getenv("TODO_TRASH_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention < 0 {
+			return cfg, fmt.Errorf("TODO_TRASH_RETENTION must be a non-negative duration, got %q", v)
+		}
+		cfg.TrashRetention = retention
+	}
+	if v := getenv("TODO_REMINDER_OFFSETS"); v != "" {
+		cfg.Offsets = nil
+		if v == "none" {
+			return cfg, nil
+		}
+		for _, part := range strings.Split(v, ",") {
+			offset, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil || offset < 0 {
+				return cfg, fmt.Errorf("TODO_REMINDER_OFFSETS: %q is not a non-negative duration", part)
+			}
+			cfg.Offsets = append(cfg.Offsets, offset)
+		}
+	}
+	return cfg, nil
+}
+
+// reminder is fired once per configured offset as an item's due time nears.
+type reminder struct {
+	Todo   ToDo
+	Offset time.Duration
+}
+
+// scheduler periodically flags overdue items, fires reminders and purges
+// the trash. A reminder fires on the first tick at or after its time;
+// reminders whose time passed while the service was down are not replayed.
+type scheduler struct {
+	store  Store
+	cfg    schedulerConfig
+	now    func() time.Time
+	notify func(ctx context.Context, r reminder)
+
+	last time.Time // Reminders up to this time have fired
+
+	reminders    metric.Int64Counter
+	purged       metric.Int64Counter
+	registration metric.Registration
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+func newScheduler(s Store, cfg schedulerConfig) (*scheduler, error) {
+	sch := &scheduler{
+		store:  s,
+		cfg:    cfg,
+		now:    time.Now,
+		notify: logReminder,
+		stop:   make(chan struct{}),
+	}
+	sch.last = sch.now()
+	if err := sch.initMetrics(); err != nil {
+		return nil, err
+	}
+	return sch, nil
+}
+
+// start runs the scheduler in the background until close is called.
+func (sch *scheduler) start() {
+	sch.wg.Add(1)
+	go func() {
+		defer sch.wg.Done()
+		ticker := time.NewTicker(sch.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sch.stop:
+				return
+			case <-ticker.C:
+				sch.tick(context.Background())
+			}
+		}
+	}()
+}
+
+// tick flags newly overdue items, purges items past the trash retention
+// and fires the reminders that came due since the previous tick.
+func (sch *scheduler) tick(ctx context.Context) {
+	ctx, span := otel.Tracer("todo-service").Start(ctx, "scheduler.tick")
+	defer span.End()
+
+	now := sch.now()
+	purged, err := sch.store.PurgeTrash(ctx, now.Add(-sch.cfg.TrashRetention))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "purge trash failed")
+		logWithTrace(ctx).Err(err).Msg("Failed to purge trash")
+	} else if purged > 0 {
+		sch.purged.Add(ctx, int64(purged))
+		logWithTrace(ctx).Str("event", "trash_purged").Int("count", purged).Msg("Purged deleted tasks")
+	}
+	span.SetAttributes(attribute.Int("scheduler.trash_purged", purged))
+
+	flagged, err := sch.store.FlagOverdue(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "flag overdue failed")
+		logWithTrace(ctx).Err(err).Msg("Failed to flag overdue tasks")
+	}
+	for _, todo := range flagged {
+		logWithTrace(ctx).Str("event", "task_overdue").Int("todo_id", todo.ID).Time("due", *todo.Due).Msg("Task is overdue")
+	}
+
+	fired := 0
+	if len(sch.cfg.Offsets) > 0 {
+		open := false
+		horizon := now.Add(slices.Max(sch.cfg.Offsets))
+		todos, err := sch.store.List(ctx, ListFilter{Completed: &open, DueAfter: &sch.last, DueBefore: &horizon})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "list due tasks failed")
+			logWithTrace(ctx).Err(err).Msg("Failed to list due tasks")
+			return
+		}
+		for _, todo := range todos {
+			for _, offset := range sch.cfg.Offsets {
+				at := todo.Due.Add(-offset)
+				if at.After(sch.last) && !at.After(now) {
+					sch.fire(ctx, reminder{Todo: todo, Offset: offset})
+					fired++
+				}
+			}
+		}
+	}
+	sch.last = now
+	span.SetAttributes(attribute.Int("scheduler.overdue_flagged", len(flagged)), attribute.Int("scheduler.reminders_fired", fired))
+}
+
+func (sch *scheduler) fire(ctx context.Context, r reminder) {
+	oteltrace.SpanFromContext(ctx).AddEvent("task.reminder", oteltrace.WithAttributes(
+		attribute.Int("todo.id", r.Todo.ID),
+		attribute.String("reminder.offset", r.Offset.String()),
+	))
+	sch.reminders.Add(ctx, 1, metric.WithAttributes(attribute.String("offset", r.Offset.String())))
+	sch.notify(ctx, r)
+}
+
+// logReminder is the default reminder sink.
+func logReminder(ctx context.Context, r reminder) {
+	logWithTrace(ctx).Str("event", "task_reminder").Int("todo_id", r.Todo.ID).Str("todo_text", r.Todo.Text).
+		Time("due", *r.Todo.Due).Str("offset", r.Offset.String()).Msg("Task due soon")
+}
+
+func (sch *scheduler) initMetrics() error {
+	m := otel.Meter("todo-service")
+	var err error
+	sch.reminders, err = m.Int64Counter(
+		"todo_reminders_total",
+		metric.WithDescription("Total number of due-date reminders fired"),
+		metric.WithUnit("{reminders}"),
+	)
+	if err != nil {
+		return err
+	}
+	sch.purged, err = m.Int64Counter(
+		"todo_trash_purged_total",
+		metric.WithDescription("Total number of deleted tasks purged from the trash"),
+		metric.WithUnit("{tasks}"),
+	)
+	if err != nil {
+		return err
+	}
+	trashed, err := m.Int64ObservableGauge(
+		"todo_trash_size",
+		metric.WithDescription("Current number of deleted tasks in the trash"),
+		metric.WithUnit("{tasks}"),
+	)
+	if err != nil {
+		return err
+	}
+	overdue, err := m.Int64ObservableGauge(
+		"todo_tasks_overdue",
+		metric.WithDescription("Current number of open tasks past their due time"),
+		metric.WithUnit("{tasks}"),
+	)
+	if err != nil {
+		return err
+	}
+	sch.registration, err = m.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		yes := true
+		todos, err := sch.store.List(ctx, ListFilter{Overdue: &yes})
+		if err != nil {
+			return err
+		}
+		o.ObserveInt64(overdue, int64(len(todos)))
+		trash, err := sch.store.Trash(ctx)
+		if err != nil {
+			return err
+		}
+		o.ObserveInt64(trashed, int64(len(trash)))
+		return nil
+	}, overdue, trashed)
+	return err
+}
+
+// close stops the background loop and unregisters the gauges.
+func (sch *scheduler) close() {
+	close(sch.stop)
+	sch.wg.Wait()
+	if sch.registration != nil {
+		sch.registration.Unregister()
+	}
+}
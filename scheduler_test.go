@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScheduler_RemindersAndOverdue(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	s := NewMemoryStore()
+	s.(*taskStore).now = now
+
+	due := clock.Add(90 * time.Minute)
+	todo, _ := s.Add(ctx, ToDo{Text: "Call the bank", Due: &due})
+
+	sch, err := newScheduler(s, schedulerConfig{Interval: time.Minute, Offsets: []time.Duration{time.Hour, 15 * time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sch.close()
+	sch.now = now
+	sch.last = clock
+	var fired []time.Duration
+	sch.notify = func(_ context.Context, r reminder) {
+		if r.Todo.ID != todo.ID {
+			t.Errorf("reminder for item %d, want %d", r.Todo.ID, todo.ID)
+		}
+		fired = append(fired, r.Offset)
+	}
+
+	step := func(d time.Duration) {
+		clock = clock.Add(d)
+		sch.tick(ctx)
+	}
+	step(20 * time.Minute) // 09:20, nothing due yet
+	step(20 * time.Minute) // 09:40, passes the 1h reminder at 09:30
+	step(20 * time.Minute) // 10:00
+	step(20 * time.Minute) // 10:20, passes the 15m reminder at 10:15
+	if want := []time.Duration{time.Hour, 15 * time.Minute}; !slices.Equal(fired, want) {
+		t.Errorf("reminders fired = %v, want %v", fired, want)
+	}
+	if got, _ := s.Get(ctx, todo.ID); got.Overdue {
+		t.Fatal("item flagged overdue before its due time")
+	}
+
+	step(20 * time.Minute) // 10:40, past due
+	if got, _ := s.Get(ctx, todo.ID); !got.Overdue {
+		t.Error("item not flagged overdue after its due time")
+	}
+	if len(fired) != 2 {
+		t.Errorf("reminders fired again: %v", fired)
+	}
+}
+
+func TestScheduler_PurgesTrash(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	s := NewMemoryStore()
+	s.(*taskStore).now = now
+
+	old, _ := s.Add(ctx, ToDo{Text: "old"})
+	recent, _ := s.Add(ctx, ToDo{Text: "recent"})
+	s.Delete(ctx, old.ID, 0)
+	clock = clock.Add(12 * time.Hour)
+	s.Delete(ctx, recent.ID, 0)
+
+	sch, err := newScheduler(s, schedulerConfig{Interval: time.Minute, TrashRetention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sch.close()
+	sch.now = now
+
+	clock = clock.Add(11 * time.Hour)
+	sch.tick(ctx)
+	if trash, _ := s.Trash(ctx); len(trash) != 2 {
+		t.Fatalf("trash purged before the retention period: %v", trash)
+	}
+	clock = clock.Add(time.Hour)
+	sch.tick(ctx)
+	if trash, _ := s.Trash(ctx); len(trash) != 1 || trash[0].ID != recent.ID {
+		t.Errorf("trash after retention = %v, want only %d", trash, recent.ID)
+	}
+}
+
+func TestLoadSchedulerConfig(t *testing.T) {
+	env := map[string]string{"TODO_SCHEDULER_INTERVAL": "30s", "TODO_REMINDER_OFFSETS": "24h, 15m", "TODO_TRASH_RETENTION": "168h"}
+	cfg, err := loadSchedulerConfig(func(key string) string { return env[key] })
+	if err != nil || cfg.Interval != 30*time.Second || !slices.Equal(cfg.Offsets, []time.Duration{24 * time.Hour, 15 * time.Minute}) || cfg.TrashRetention != 168*time.Hour {
+		t.Errorf("config = %+v, %v", cfg, err)
+	}
+	env["TODO_REMINDER_OFFSETS"] = "soon"
+	if _, err := loadSchedulerConfig(func(key string) string { return env[key] }); err == nil {
+		t.Error("invalid offset was accepted")
+	}
+	env["TODO_REMINDER_OFFSETS"] = ""
+	env["TODO_TRASH_RETENTION"] = "-1h"
+	if _, err := loadSchedulerConfig(func(key string) string { return env[key] }); err == nil {
+		t.Error("negative trash retention was accepted")
+	}
+}
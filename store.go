@@ -1,100 +1,834 @@
 package main
 
-import "sync"
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
 
-// Store manages the ToDo items.
-type Store struct {
-	sync.Mutex
-	data  map[int]ToDo
-	count int
+var (
+	// ErrNotFound is returned when a ToDo item does not exist in the store.
+	ErrNotFound = errors.New("todo not found")
+	// ErrVersionMismatch is returned when a conditional mutation's expected
+	// version no longer matches the stored item.
+	ErrVersionMismatch = errors.New("todo version mismatch")
+	// ErrTooManyTags is returned when adding a tag would exceed the item's tag limit.
+	ErrTooManyTags = errors.New("too many tags")
+	// ErrProjectNotFound is returned when a project, or the project an item
+	// is assigned to, does not exist.
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrProjectExists is returned when a project name is already taken.
+	ErrProjectExists = errors.New("project name already in use")
+	// ErrProjectNotEmpty is returned when deleting a project that still owns items.
+	ErrProjectNotEmpty = errors.New("project still has tasks")
+)
+
+// Store is the storage API used by the HTTP handlers. Every backend
+// (memory, file, ...) provides the same behaviour, enforced by the
+// conformance suite in store_test.go.
+//
+// Every change to an item increments its Version and is recorded in its
+// history, attributed to the actor set on the context with withActor.
+// Mutations taking an ifVersion only apply when it equals the stored version
+// and fail with ErrVersionMismatch otherwise; 0 applies them unconditionally.
+type Store interface {
+	// Add stores a new ToDo item and returns it with its assigned ID.
+	Add(ctx context.Context, todo ToDo) (ToDo, error)
+	// Get retrieves a ToDo item by ID.
+	Get(ctx context.Context, id int) (ToDo, error)
+	// List returns all ToDo items matching the filter, ordered by ID.
+	List(ctx context.Context, filter ListFilter) ([]ToDo, error)
+	// Update overwrites the client-editable fields of the item with edited's
+	// ID, leaving its completion state alone.
+	Update(ctx context.Context, edited ToDo, ifVersion int) (ToDo, error)
+	// Delete moves a ToDo item to the trash.
+	Delete(ctx context.Context, id int, ifVersion int) error
+	// Complete marks a ToDo item as completed by the given actor. A blocked
+	// item fails with ErrBlocked unless force is set.
+	Complete(ctx context.Context, id int, by string, force bool, ifVersion int) (ToDo, error)
+	// Uncomplete reopens a completed ToDo item.
+	Uncomplete(ctx context.Context, id int, ifVersion int) (ToDo, error)
+	// Replace overwrites the client-editable fields of the item with todo's ID;
+	// a change of completion state is attributed to by. Completing a blocked
+	// item fails with ErrBlocked.
+	Replace(ctx context.Context, todo ToDo, by string, ifVersion int) (ToDo, error)
+	// Search finds ToDo items containing the query text and matching the
+	// filter, ordered by ID.
+	Search(ctx context.Context, query string, filter ListFilter) ([]ToDo, error)
+	// AddTag adds a normalised tag to an item; adding a present tag is a
+	// no-op. With maxTags > 0 it fails with ErrTooManyTags beyond that many.
+	AddTag(ctx context.Context, id int, tag string, maxTags int, ifVersion int) (ToDo, error)
+	// RemoveTag removes a tag from an item; removing an absent tag is a no-op.
+	RemoveTag(ctx context.Context, id int, tag string, ifVersion int) (ToDo, error)
+	// Tags counts the items matching the filter per tag, ordered by tag.
+	Tags(ctx context.Context, filter ListFilter) ([]TagCount, error)
+	// Move places an item directly before or after the anchor item in manual
+	// (position) order, rewriting only the moved item.
+	Move(ctx context.Context, id, anchor int, before bool, ifVersion int) (ToDo, error)
+	// FlagOverdue sets the overdue flag on open items whose due time has
+	// passed and returns the items it flagged.
+	FlagOverdue(ctx context.Context) ([]ToDo, error)
+	// Tree returns an item with all of its descendants.
+	Tree(ctx context.Context, id int) (TaskTree, error)
+	// History returns every recorded change to an item, oldest first.
+	History(ctx context.Context, id int) ([]HistoryEntry, error)
+	// Restore returns an item's editable fields and completion state to
+	// those of an earlier version; unknown versions fail with ErrRevisionNotFound.
+	Restore(ctx context.Context, id, version int, ifVersion int) (ToDo, error)
+	// Batch runs fn atomically: every change fn makes through the store with
+	// the context it is given commits when fn returns nil, and none otherwise.
+	Batch(ctx context.Context, fn func(ctx context.Context) error) error
+	// Watch calls fn after every committed change with the history entries
+	// it recorded and the context of the call that made it.
+	Watch(fn func(ctx context.Context, changes []HistoryEntry))
+	// Trash returns the deleted items awaiting purge, ordered by ID.
+	Trash(ctx context.Context) ([]ToDo, error)
+	// Untrash restores a deleted item; it fails with ErrRestoreConflict
+	// while the item's project or parent is missing.
+	Untrash(ctx context.Context, id int) (ToDo, error)
+	// PurgeTrash permanently removes items deleted at or before cutoff.
+	PurgeTrash(ctx context.Context, cutoff time.Time) (int, error)
+	// AddProject stores a new project and returns it with its assigned ID.
+	// Names are unique, ignoring case.
+	AddProject(ctx context.Context, project Project) (Project, error)
+	// GetProject retrieves a project with its task counts.
+	GetProject(ctx context.Context, id int) (ProjectSummary, error)
+	// Projects returns every project with its task counts, ordered by ID.
+	Projects(ctx context.Context) ([]ProjectSummary, error)
+	// RenameProject changes a project's name.
+	RenameProject(ctx context.Context, id int, name string, ifVersion int) (Project, error)
+	// DeleteProject removes a project; it fails with ErrProjectNotEmpty
+	// while any item is assigned to it.
+	DeleteProject(ctx context.Context, id int, ifVersion int) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// backend is the storage engine beneath a Store. Read-write transactions
+// are applied atomically when fn returns nil and discarded otherwise;
+// op names the Store operation ("add", "update", ...) for logs and telemetry.
+type backend interface {
+	view(ctx context.Context, fn func(tx txn) error) error
+	update(ctx context.Context, op string, fn func(tx txn) error) error
+	close() error
+}
+
+// txn is a transaction against a backend. Items and projects have
+// separate ID sequences; trashed items share the item IDs, and item
+// history is append-only.
+type txn interface {
+	get(id int) (ToDo, error)
+	put(todo ToDo) error
+	remove(id int) error
+	nextID() (int, error)
+	all() ([]ToDo, error)
+
+	getProject(id int) (Project, error)
+	putProject(project Project) error
+	removeProject(id int) error
+	nextProjectID() (int, error)
+	allProjects() ([]Project, error)
+
+	getTrashed(id int) (ToDo, error)
+	putTrashed(todo ToDo) error
+	removeTrashed(id int) error
+	allTrashed() ([]ToDo, error)
+
+	appendHistory(entry HistoryEntry) error // Assigns entry.Seq
+	history(id int) ([]HistoryEntry, error) // Oldest first
+}
+
+// indexedTxn is implemented by transactions that can narrow List and Search
+// with native indexes. Results may be a superset of the matches; taskStore
+// still applies the filter to every candidate.
+type indexedTxn interface {
+	candidates(filter ListFilter) ([]ToDo, error)
+	searchCandidates(query string) ([]ToDo, error)
+}
+
+// listCandidates returns the items filter may match, narrowed by the
+// backend's indexes when it has them.
+func listCandidates(tx txn, filter ListFilter) ([]ToDo, error) {
+	if itx, ok := tx.(indexedTxn); ok {
+		return itx.candidates(filter)
+	}
+	return tx.all()
+}
+
+// positionedTxn is implemented by transactions that can find the last
+// position without reading every item.
+type positionedTxn interface {
+	lastPosition() (string, error)
+}
+
+// lastPosition returns the greatest position in use, or "" when there is none.
+func lastPosition(tx txn) (string, error) {
+	if ptx, ok := tx.(positionedTxn); ok {
+		return ptx.lastPosition()
+	}
+	todos, err := tx.all()
+	if err != nil {
+		return "", err
+	}
+	last := ""
+	for _, todo := range todos {
+		last = max(last, todo.Position)
+	}
+	return last, nil
+}
+
+// OpenStore creates the Store for the named backend ("memory", "file", "wal"
+// or "sqlite") with the given completion rules. path is only used by durable
+// backends; when empty a default under /data is used.
+func OpenStore(kind, path string, rules completionRules) (Store, error) {
+	var s Store
+	var err error
+	switch kind {
+	case "", "memory":
+		s = NewMemoryStore()
+	case "file":
+		s, err = NewFileStore(defaultPath(path, "/data/todos.json"))
+	case "wal":
+		s, err = NewWALStore(defaultPath(path, "/data/todos.json"), defaultWALOptions())
+	case "sqlite":
+		s, err = NewSQLiteStore(defaultPath(path, "/data/todos.db"))
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.(*taskStore).completion = rules
+	return s, nil
 }
 
-// NewStore creates a new Store.
-func NewStore() *Store {
-	return &Store{
-		data: make(map[int]ToDo),
+func defaultPath(path, fallback string) string {
+	if path == "" {
+		return fallback
 	}
+	return path
+}
+
+// taskStore implements Store on top of any backend, so the task rules live in one place.
+type taskStore struct {
+	backend    backend
+	now        func() time.Time
+	completion completionRules
+
+	watchMu  sync.RWMutex
+	watchers []func(ctx context.Context, changes []HistoryEntry)
+}
+
+func newTaskStore(b backend) *taskStore {
+	return &taskStore{backend: b, now: time.Now, completion: defaultCompletionRules()}
 }
 
 // Add adds a new ToDo item to the store.
-func (s *Store) Add(todo ToDo) ToDo {
-	s.Lock()
-	defer s.Unlock()
-	s.count++
-	todo.ID = s.count
-	s.data[todo.ID] = todo
-	return todo
+func (s *taskStore) Add(ctx context.Context, todo ToDo) (ToDo, error) {
+	err := s.update(ctx, "add", func(tx txn) error {
+		id, err := tx.nextID()
+		if err != nil {
+			return err
+		}
+		last, err := lastPosition(tx)
+		if err != nil {
+			return err
+		}
+		if todo.Position, err = positionBetween(last, ""); err != nil {
+			return err
+		}
+		if err := checkProject(tx, todo.Project); err != nil {
+			return err
+		}
+		if err := checkParent(tx, id, todo.Parent); err != nil {
+			return err
+		}
+		if err := checkBlockers(tx, id, todo.BlockedBy); err != nil {
+			return err
+		}
+		if err := refreshBlocked(tx, &todo); err != nil {
+			return err
+		}
+		todo.ID = id
+		todo.Version = 1
+		refreshOccurrence(&todo)
+		todo.CreatedAt = s.now().UTC()
+		s.refreshOverdue(&todo)
+		if err := tx.put(todo); err != nil {
+			return err
+		}
+		return s.refreshAncestors(tx, todo.Parent, !todo.Completed, todo.CompletedBy)
+	})
+	if err != nil {
+		return ToDo{}, err
+	}
+	return todo, nil
 }
 
 // Get retrieves a ToDo item by ID.
-func (s *Store) Get(id int) (ToDo, bool) {
-	s.Lock()
-	defer s.Unlock()
-	todo, exists := s.data[id]
-	return todo, exists
-}
-
-// List returns all ToDo items.
-func (s *Store) List() []ToDo {
-	s.Lock()
-	defer s.Unlock()
-	list := make([]ToDo, 0, len(s.data))
-	for _, todo := range s.data {
-		list = append(list, todo)
-	}
-	return list
-}
-
-// Delete removes a ToDo item by ID. Returns true if deleted, false otherwise.
-func (s *Store) Delete(id int) bool {
-	s.Lock()
-	defer s.Unlock()
-	_, exists := s.data[id]
-	if exists {
-		delete(s.data, id)
-	}
-	return exists
-}
-
-// Update modifies the text of an existing ToDo item. Returns the updated ToDo and true if found, otherwise empty ToDo and false.
-func (s *Store) Update(id int, text string) (ToDo, bool) {
-	s.Lock()
-	defer s.Unlock()
-	todo, exists := s.data[id]
-	if !exists {
-		return ToDo{}, false
-	}
-	todo.Text = text
-	s.data[id] = todo // Update the map
-	return todo, true
-}
-
-// Complete marks a ToDo item as completed (conceptually, by updating its representation if needed).
-// Note: The current implementation in main.go updates the store with a ToDo struct,
-// not a CompletedToDo struct. This logic might need refinement depending on requirements.
-func (s *Store) Complete(id int) (CompletedToDo, bool) {
-	s.Lock()
-	defer s.Unlock()
-	todo, exists := s.data[id]
-	if !exists {
-		return CompletedToDo{}, false
-	}
-	completedTodo := CompletedToDo{ID: todo.ID, Text: todo.Text, Completed: true}
-	// Update the store if necessary, or handle completion status differently.
-	// The original code overwrites the existing ToDo, which might not be intended.
-	// For now, just return the completed representation.
-	return completedTodo, true
-}
-
-// Search finds ToDo items containing the query text.
-func (s *Store) Search(query string) []ToDo {
-	s.Lock()
-	defer s.Unlock()
+func (s *taskStore) Get(ctx context.Context, id int) (ToDo, error) {
+	var todo ToDo
+	err := s.view(ctx, func(tx txn) error {
+		var err error
+		todo, err = tx.get(id)
+		return err
+	})
+	return todo, err
+}
+
+// List returns all ToDo items matching the filter.
+func (s *taskStore) List(ctx context.Context, filter ListFilter) ([]ToDo, error) {
+	list := []ToDo{}
+	err := s.view(ctx, func(tx txn) error {
+		todos, err := listCandidates(tx, filter)
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if filter.matches(todo) {
+				list = append(list, todo)
+			}
+		}
+		return nil
+	})
+	sortByID(list)
+	return list, err
+}
+
+// Update overwrites the client-editable fields of an existing item, except completion.
+func (s *taskStore) Update(ctx context.Context, edited ToDo, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "update", edited.ID, ifVersion, func(_ txn, todo *ToDo) error {
+		applyEdits(todo, edited)
+		return nil
+	})
+}
+
+// Delete moves a ToDo item to the trash. Items blocked by it lose the
+// dependency, which is not restored with it.
+func (s *taskStore) Delete(ctx context.Context, id int, ifVersion int) error {
+	return s.update(ctx, "delete", func(tx txn) error {
+		todo, err := tx.get(id)
+		if err != nil {
+			return err
+		}
+		if ifVersion != 0 && todo.Version != ifVersion {
+			return ErrVersionMismatch
+		}
+		children, err := childrenOf(tx, id)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return ErrHasSubtasks
+		}
+		if err := s.trashItem(tx, todo); err != nil {
+			return err
+		}
+		if err := refreshDependents(tx, id, true); err != nil {
+			return err
+		}
+		return s.refreshAncestors(tx, todo.Parent, false, "")
+	})
+}
+
+// Complete marks a ToDo item as completed by the given actor.
+func (s *taskStore) Complete(ctx context.Context, id int, by string, force bool, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "complete", id, ifVersion, func(_ txn, todo *ToDo) error {
+		if todo.Blocked && !todo.Completed && !force {
+			return ErrBlocked
+		}
+		s.markCompleted(todo, by)
+		return nil
+	})
+}
+
+// Uncomplete reopens a completed ToDo item, clearing its completion state.
+func (s *taskStore) Uncomplete(ctx context.Context, id int, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "uncomplete", id, ifVersion, func(_ txn, todo *ToDo) error {
+		markOpen(todo)
+		return nil
+	})
+}
+
+// Replace overwrites the client-editable fields of an existing item.
+// Server-managed fields (ID, version, timestamps, completion metadata) are kept.
+func (s *taskStore) Replace(ctx context.Context, edited ToDo, by string, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "replace", edited.ID, ifVersion, func(tx txn, todo *ToDo) error {
+		applyEdits(todo, edited)
+		if err := refreshBlocked(tx, todo); err != nil {
+			return err
+		}
+		if edited.Completed {
+			if todo.Blocked && !todo.Completed {
+				return ErrBlocked
+			}
+			s.markCompleted(todo, by)
+		} else {
+			markOpen(todo)
+		}
+		return nil
+	})
+}
+
+// applyEdits copies the client-editable fields other than completion.
+func applyEdits(todo *ToDo, edited ToDo) {
+	todo.Text = edited.Text
+	todo.Due = edited.Due
+	todo.DueZone = edited.DueZone
+	todo.Priority = edited.Priority
+	todo.Tags = edited.Tags
+	todo.Project = edited.Project
+	todo.Parent = edited.Parent
+	todo.BlockedBy = edited.BlockedBy
+	todo.Recurrence = edited.Recurrence
+}
+
+// checkProject fails with ErrProjectNotFound unless project is 0 or exists.
+func checkProject(tx txn, project int) error {
+	if project == 0 {
+		return nil
+	}
+	_, err := tx.getProject(project)
+	return err
+}
+
+// markCompleted records completion by the given actor. Completing an already
+// completed item keeps the original completion time and actor.
+func (s *taskStore) markCompleted(todo *ToDo, by string) {
+	if todo.Completed {
+		return
+	}
+	now := s.now().UTC()
+	todo.Completed = true
+	todo.CompletedAt = &now
+	todo.CompletedBy = by
+}
+
+// markOpen clears any completion state.
+func markOpen(todo *ToDo) {
+	todo.Completed = false
+	todo.CompletedAt = nil
+	todo.CompletedBy = ""
+}
+
+// Search finds ToDo items containing the query text and matching the filter.
+func (s *taskStore) Search(ctx context.Context, query string, filter ListFilter) ([]ToDo, error) {
 	results := []ToDo{}
-	for _, todo := range s.data {
-		if contains(todo.Text, query) { // Assumes contains is in utils.go or main package
-			results = append(results, todo)
+	err := s.view(ctx, func(tx txn) error {
+		var todos []ToDo
+		var err error
+		if itx, ok := tx.(indexedTxn); ok {
+			todos, err = itx.searchCandidates(query)
+		} else {
+			todos, err = tx.all()
+		}
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if contains(todo.Text, query) && filter.matches(todo) {
+				results = append(results, todo)
+			}
+		}
+		return nil
+	})
+	sortByID(results)
+	return results, err
+}
+
+// Move gives an item a position between the anchor and its neighbour.
+// Items stored before positions existed are first appended in ID order.
+func (s *taskStore) Move(ctx context.Context, id, anchor int, before bool, ifVersion int) (ToDo, error) {
+	var moved ToDo
+	err := s.update(ctx, "move", func(tx txn) error {
+		current, err := tx.get(id)
+		if err != nil {
+			return err
+		}
+		if ifVersion != 0 && current.Version != ifVersion {
+			return ErrVersionMismatch
+		}
+		todos, err := tx.all()
+		if err != nil {
+			return err
+		}
+		if err := backfillPositions(tx, todos); err != nil {
+			return err
+		}
+		slices.SortFunc(todos, comparePosition)
+		i := slices.IndexFunc(todos, func(todo ToDo) bool { return todo.ID == id })
+		moved = todos[i]
+		others := slices.Delete(todos, i, i+1)
+		k := slices.IndexFunc(others, func(todo ToDo) bool { return todo.ID == anchor })
+		if k < 0 {
+			return fmt.Errorf("anchor task %d: %w", anchor, ErrNotFound)
+		}
+		var lo, hi string
+		if before {
+			hi = others[k].Position
+			if k > 0 {
+				lo = others[k-1].Position
+			}
+		} else {
+			lo = others[k].Position
+			if k+1 < len(others) {
+				hi = others[k+1].Position
+			}
+		}
+		if moved.Position > lo && (hi == "" || moved.Position < hi) {
+			return nil // Already in place
+		}
+		if moved.Position, err = positionBetween(lo, hi); err != nil {
+			return err
+		}
+		moved.Version++
+		return tx.put(moved)
+	})
+	if err != nil {
+		return ToDo{}, err
+	}
+	return moved, nil
+}
+
+// backfillPositions appends items without a position in ID order, updating todos in place.
+func backfillPositions(tx txn, todos []ToDo) error {
+	last := ""
+	for _, todo := range todos {
+		last = max(last, todo.Position)
+	}
+	sortByID(todos)
+	for i := range todos {
+		if todos[i].Position != "" {
+			continue
+		}
+		var err error
+		if last, err = positionBetween(last, ""); err != nil {
+			return err
+		}
+		todos[i].Position = last
+		todos[i].Version++
+		if err := tx.put(todos[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlagOverdue flags open items whose due time has passed, in one transaction.
+func (s *taskStore) FlagOverdue(ctx context.Context) ([]ToDo, error) {
+	flagged := []ToDo{}
+	now := s.now()
+	err := s.update(ctx, "overdue", func(tx txn) error {
+		open, overdue := false, false
+		filter := ListFilter{Completed: &open, Overdue: &overdue, DueBefore: &now}
+		todos, err := listCandidates(tx, filter)
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if !filter.matches(todo) {
+				continue
+			}
+			todo.Overdue = true
+			todo.Version++
+			if err := tx.put(todo); err != nil {
+				return err
+			}
+			flagged = append(flagged, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortByID(flagged)
+	return flagged, nil
+}
+
+// refreshOverdue recomputes the overdue flag after a change, so completing
+// an item or moving its due time clears the flag without waiting for the scheduler.
+func (s *taskStore) refreshOverdue(todo *ToDo) {
+	todo.Overdue = !todo.Completed && todo.Due != nil && !s.now().Before(*todo.Due)
+}
+
+// AddTag adds a tag to an item, keeping its tags sorted and unique.
+func (s *taskStore) AddTag(ctx context.Context, id int, tag string, maxTags int, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "tag", id, ifVersion, func(_ txn, todo *ToDo) error {
+		i, found := slices.BinarySearch(todo.Tags, tag)
+		if found {
+			return nil
+		}
+		if maxTags > 0 && len(todo.Tags) >= maxTags {
+			return ErrTooManyTags
+		}
+		todo.Tags = slices.Insert(slices.Clone(todo.Tags), i, tag)
+		return nil
+	})
+}
+
+// RemoveTag removes a tag from an item.
+func (s *taskStore) RemoveTag(ctx context.Context, id int, tag string, ifVersion int) (ToDo, error) {
+	return s.modify(ctx, "untag", id, ifVersion, func(_ txn, todo *ToDo) error {
+		if i, found := slices.BinarySearch(todo.Tags, tag); found {
+			todo.Tags = slices.Delete(slices.Clone(todo.Tags), i, i+1)
+			if len(todo.Tags) == 0 {
+				todo.Tags = nil
+			}
+		}
+		return nil
+	})
+}
+
+// Tags counts the items matching the filter per tag.
+func (s *taskStore) Tags(ctx context.Context, filter ListFilter) ([]TagCount, error) {
+	todos, err := s.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, todo := range todos {
+		for _, tag := range todo.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(tags, func(a, b TagCount) int { return strings.Compare(a.Tag, b.Tag) })
+	return tags, nil
+}
+
+// AddProject creates a project with a unique name.
+func (s *taskStore) AddProject(ctx context.Context, project Project) (Project, error) {
+	err := s.update(ctx, "add_project", func(tx txn) error {
+		if err := checkProjectName(tx, 0, project.Name); err != nil {
+			return err
+		}
+		id, err := tx.nextProjectID()
+		if err != nil {
+			return err
+		}
+		project.ID = id
+		project.Version = 1
+		project.CreatedAt = s.now().UTC()
+		return tx.putProject(project)
+	})
+	if err != nil {
+		return Project{}, err
+	}
+	return project, nil
+}
+
+// GetProject retrieves a project and counts its items.
+func (s *taskStore) GetProject(ctx context.Context, id int) (ProjectSummary, error) {
+	var summary ProjectSummary
+	err := s.view(ctx, func(tx txn) error {
+		project, err := tx.getProject(id)
+		if err != nil {
+			return err
+		}
+		summary.Project = project
+		filter := ListFilter{Project: &id}
+		todos, err := listCandidates(tx, filter)
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if filter.matches(todo) {
+				summary.Counts.count(todo)
+			}
+		}
+		return nil
+	})
+	return summary, err
+}
+
+// Projects returns every project and counts their items in one pass.
+func (s *taskStore) Projects(ctx context.Context) ([]ProjectSummary, error) {
+	summaries := []ProjectSummary{}
+	err := s.view(ctx, func(tx txn) error {
+		projects, err := tx.allProjects()
+		if err != nil {
+			return err
+		}
+		todos, err := tx.all()
+		if err != nil {
+			return err
+		}
+		counts := map[int]*ProjectCounts{}
+		for _, todo := range todos {
+			if todo.Project == 0 {
+				continue
+			}
+			if counts[todo.Project] == nil {
+				counts[todo.Project] = &ProjectCounts{}
+			}
+			counts[todo.Project].count(todo)
+		}
+		for _, project := range projects {
+			summary := ProjectSummary{Project: project}
+			if c := counts[project.ID]; c != nil {
+				summary.Counts = *c
+			}
+			summaries = append(summaries, summary)
+		}
+		return nil
+	})
+	slices.SortFunc(summaries, func(a, b ProjectSummary) int { return cmp.Compare(a.ID, b.ID) })
+	return summaries, err
+}
+
+// RenameProject changes a project's name, keeping names unique.
+func (s *taskStore) RenameProject(ctx context.Context, id int, name string, ifVersion int) (Project, error) {
+	var project Project
+	err := s.update(ctx, "rename_project", func(tx txn) error {
+		current, err := tx.getProject(id)
+		if err != nil {
+			return err
+		}
+		if ifVersion != 0 && current.Version != ifVersion {
+			return ErrVersionMismatch
+		}
+		project = current
+		if name == current.Name {
+			return nil
+		}
+		if err := checkProjectName(tx, id, name); err != nil {
+			return err
+		}
+		project.Name = name
+		project.Version++
+		return tx.putProject(project)
+	})
+	if err != nil {
+		return Project{}, err
+	}
+	return project, nil
+}
+
+// DeleteProject removes a project that no longer owns any items.
+func (s *taskStore) DeleteProject(ctx context.Context, id int, ifVersion int) error {
+	return s.update(ctx, "delete_project", func(tx txn) error {
+		project, err := tx.getProject(id)
+		if err != nil {
+			return err
+		}
+		if ifVersion != 0 && project.Version != ifVersion {
+			return ErrVersionMismatch
+		}
+		filter := ListFilter{Project: &id}
+		todos, err := listCandidates(tx, filter)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(todos, filter.matches) {
+			return ErrProjectNotEmpty
+		}
+		return tx.removeProject(id)
+	})
+}
+
+// checkProjectName fails with ErrProjectExists if a project other than
+// self already uses name, ignoring case.
+func checkProjectName(tx txn, self int, name string) error {
+	projects, err := tx.allProjects()
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		if project.ID != self && strings.EqualFold(project.Name, name) {
+			return ErrProjectExists
+		}
+	}
+	return nil
+}
+
+// Close releases the backend.
+func (s *taskStore) Close() error {
+	return s.backend.close()
+}
+
+// sortByID orders todos by ID so every backend returns the same order.
+func sortByID(todos []ToDo) {
+	slices.SortFunc(todos, func(a, b ToDo) int { return cmp.Compare(a.ID, b.ID) })
+}
+
+// modify loads a ToDo item, checks ifVersion, applies fn and writes the
+// item back with a bumped version in one transaction. Items fn leaves
+// unchanged are not rewritten; changes of parent or completion state are
+// propagated to the item's ancestors and to the items it blocks, and
+// completing a recurring item creates its next occurrence.
+func (s *taskStore) modify(ctx context.Context, op string, id int, ifVersion int, fn func(tx txn, todo *ToDo) error) (ToDo, error) {
+	var todo ToDo
+	err := s.update(ctx, op, func(tx txn) error {
+		current, err := tx.get(id)
+		if err != nil {
+			return err
+		}
+		if ifVersion != 0 && current.Version != ifVersion {
+			return ErrVersionMismatch
+		}
+		todo = current
+		if err := fn(tx, &todo); err != nil {
+			return err
+		}
+		if err := refreshBlocked(tx, &todo); err != nil {
+			return err
+		}
+		refreshOccurrence(&todo)
+		s.refreshOverdue(&todo)
+		if reflect.DeepEqual(todo, current) {
+			return nil
+		}
+		if todo.Project != current.Project {
+			if err := checkProject(tx, todo.Project); err != nil {
+				return err
+			}
+		}
+		if todo.Parent != current.Parent {
+			if err := checkParent(tx, todo.ID, todo.Parent); err != nil {
+				return err
+			}
+		}
+		if !slices.Equal(todo.BlockedBy, current.BlockedBy) {
+			if err := checkBlockers(tx, todo.ID, todo.BlockedBy); err != nil {
+				return err
+			}
+		}
+		if todo.Completed && !current.Completed {
+			if err := s.recur(tx, &todo); err != nil {
+				return err
+			}
+		}
+		todo.Version = current.Version + 1
+		if err := tx.put(todo); err != nil {
+			return err
+		}
+		if todo.Completed != current.Completed {
+			if err := refreshDependents(tx, todo.ID, false); err != nil {
+				return err
+			}
+		}
+		if todo.Parent != current.Parent {
+			if err := s.refreshAncestors(tx, current.Parent, false, ""); err != nil {
+				return err
+			}
+		}
+		if todo.Parent != current.Parent || todo.Completed != current.Completed {
+			opened := !todo.Completed && (current.Completed || todo.Parent != current.Parent)
+			return s.refreshAncestors(tx, todo.Parent, opened, todo.CompletedBy)
 		}
+		return nil
+	})
+	if err != nil {
+		return ToDo{}, err
 	}
-	return results
+	return todo, nil
 }
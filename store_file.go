@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// fileSnapshot is the on-disk format of the file backend. The WAL backend
+// uses the same format for its compacted snapshots, recording in Seq the
+// last log record the snapshot includes.
+type fileSnapshot struct {
+	idCounters
+	Seq      uint64         `json:"seq,omitempty"`
+	Todos    []ToDo         `json:"todos"`
+	Trash    []ToDo         `json:"trash,omitempty"`
+	Projects []Project      `json:"projects,omitempty"`
+	History  []HistoryEntry `json:"history,omitempty"` // Ordered by item, then Seq
+}
+
+// NewFileStore creates a Store that serves reads from memory and rewrites
+// the JSON file at path after every committed transaction.
+func NewFileStore(path string) (Store, error) {
+	b := newMemoryBackend()
+	snap, err := readSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	b.load(snap)
+	b.persist = func(op string, changes []storeChange, ids idCounters) error {
+		return writeSnapshot(path, pendingSnapshot(b, changes, ids))
+	}
+	return newTaskStore(b), nil
+}
+
+// load fills an empty backend from a snapshot.
+func (b *memoryBackend) load(snap fileSnapshot) {
+	for _, todo := range snap.Todos {
+		b.data[todo.ID] = todo
+	}
+	for _, todo := range snap.Trash {
+		b.trash[todo.ID] = todo
+	}
+	for _, project := range snap.Projects {
+		b.projects[project.ID] = project
+	}
+	for _, entry := range snap.History {
+		b.history[entry.TaskID] = append(b.history[entry.TaskID], entry)
+	}
+	b.ids = snap.idCounters
+}
+
+// pendingSnapshot builds the snapshot b would have after changes are applied.
+// The caller must hold b's lock.
+func pendingSnapshot(b *memoryBackend, changes []storeChange, ids idCounters) fileSnapshot {
+	merged := &memoryBackend{
+		data:     maps.Clone(b.data),
+		trash:    maps.Clone(b.trash),
+		projects: maps.Clone(b.projects),
+		history:  make(map[int][]HistoryEntry, len(b.history)),
+	}
+	for id, entries := range b.history {
+		merged.history[id] = slices.Clip(entries) // Appends must not write into b's arrays
+	}
+	merged.apply(changes, ids)
+	snap := fileSnapshot{
+		idCounters: ids,
+		Todos:      slices.Collect(maps.Values(merged.data)),
+		Trash:      slices.Collect(maps.Values(merged.trash)),
+		Projects:   slices.Collect(maps.Values(merged.projects)),
+	}
+	sortByID(snap.Todos)
+	sortByID(snap.Trash)
+	slices.SortFunc(snap.Projects, func(a, b Project) int { return cmp.Compare(a.ID, b.ID) })
+	for _, id := range slices.Sorted(maps.Keys(merged.history)) {
+		snap.History = append(snap.History, merged.history[id]...)
+	}
+	if snap.Todos == nil {
+		snap.Todos = []ToDo{}
+	}
+	return snap
+}
+
+// readSnapshot loads a snapshot file; a missing file is an empty store.
+func readSnapshot(path string) (fileSnapshot, error) {
+	var snap fileSnapshot
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, fmt.Errorf("read snapshot %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("decode snapshot %s: %w", path, err)
+	}
+	return snap, nil
+}
+
+// writeSnapshot atomically replaces the snapshot file by writing a synced
+// temporary file next to it and renaming it into place.
+func writeSnapshot(path string, snap fileSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes directory metadata so a rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+var errReadOnlyTxn = errors.New("write in read-only transaction")
+
+// storeChange is a single write produced by a committed transaction.
+// Kind is empty for ToDo items, "trash" for trashed items, "project" for
+// projects and "history" for history entries of the item ID; a nil ToDo or
+// Project means the record with ID was removed.
+type storeChange struct {
+	Kind    string        `json:"kind,omitempty"`
+	ID      int           `json:"id"`
+	ToDo    *ToDo         `json:"todo,omitempty"`
+	Project *Project      `json:"project,omitempty"`
+	Entry   *HistoryEntry `json:"entry,omitempty"`
+}
+
+// Kinds of storeChange other than item writes.
+const (
+	changeTrash   = "trash"
+	changeProject = "project"
+	changeHistory = "history"
+)
+
+// idCounters are the last IDs handed out, persisted so IDs are never reused.
+type idCounters struct {
+	Count        int `json:"count"` // ToDo items
+	ProjectCount int `json:"project_count,omitempty"`
+}
+
+// memoryBackend keeps all ToDo items, trashed items, projects and history
+// in maps guarded by a mutex.
+type memoryBackend struct {
+	mu       sync.RWMutex
+	data     map[int]ToDo
+	trash    map[int]ToDo
+	projects map[int]Project
+	history  map[int][]HistoryEntry
+	ids      idCounters
+	// persist, when set, is called with the lock held before a transaction's
+	// changes are applied; an error aborts the transaction.
+	persist func(op string, changes []storeChange, ids idCounters) error
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		data:     make(map[int]ToDo),
+		trash:    make(map[int]ToDo),
+		projects: make(map[int]Project),
+		history:  make(map[int][]HistoryEntry),
+	}
+}
+
+// NewMemoryStore creates a Store that keeps its data in memory only.
+func NewMemoryStore() Store {
+	return newTaskStore(newMemoryBackend())
+}
+
+func (b *memoryBackend) view(ctx context.Context, fn func(tx txn) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return fn(&memoryTxn{b: b, ids: b.ids, readOnly: true})
+}
+
+func (b *memoryBackend) update(ctx context.Context, op string, fn func(tx txn) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tx := &memoryTxn{b: b, ids: b.ids}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.todos.order) == 0 && len(tx.trash.order) == 0 && len(tx.projects.order) == 0 && len(tx.entries) == 0 && tx.ids == b.ids {
+		return nil
+	}
+	changes := tx.changes()
+	if b.persist != nil {
+		if err := b.persist(op, changes, tx.ids); err != nil {
+			return err
+		}
+	}
+	b.apply(changes, tx.ids)
+	return nil
+}
+
+func (b *memoryBackend) close() error {
+	return nil
+}
+
+// apply writes committed changes into the maps. The caller must hold the lock.
+func (b *memoryBackend) apply(changes []storeChange, ids idCounters) {
+	for _, c := range changes {
+		switch {
+		case c.Kind == changeProject && c.Project == nil:
+			delete(b.projects, c.ID)
+		case c.Kind == changeProject:
+			b.projects[c.ID] = *c.Project
+		case c.Kind == changeTrash && c.ToDo == nil:
+			delete(b.trash, c.ID)
+		case c.Kind == changeTrash:
+			b.trash[c.ID] = *c.ToDo
+		case c.Kind == changeHistory:
+			b.history[c.ID] = append(b.history[c.ID], *c.Entry)
+		case c.ToDo == nil:
+			delete(b.data, c.ID)
+		default:
+			b.data[c.ID] = *c.ToDo
+		}
+	}
+	b.ids = ids
+}
+
+// memoryTxn buffers writes so a failed transaction leaves the maps untouched.
+type memoryTxn struct {
+	b        *memoryBackend
+	todos    pendingWrites[ToDo]
+	trash    pendingWrites[ToDo]
+	projects pendingWrites[Project]
+	entries  []HistoryEntry // Appended history entries, in order
+	ids      idCounters
+	readOnly bool
+}
+
+// pendingWrites buffers a transaction's writes to one of the backend's
+// maps; a nil entry is a removal.
+type pendingWrites[T any] struct {
+	writes map[int]*T
+	order  []int
+}
+
+func (p *pendingWrites[T]) get(data map[int]T, id int) (T, bool) {
+	if w, ok := p.writes[id]; ok {
+		if w == nil {
+			var zero T
+			return zero, false
+		}
+		return *w, true
+	}
+	v, ok := data[id]
+	return v, ok
+}
+
+func (p *pendingWrites[T]) write(id int, v *T) {
+	if p.writes == nil {
+		p.writes = make(map[int]*T)
+	}
+	if _, seen := p.writes[id]; !seen {
+		p.order = append(p.order, id)
+	}
+	p.writes[id] = v
+}
+
+func (p *pendingWrites[T]) all(data map[int]T) []T {
+	list := make([]T, 0, len(data)+len(p.writes))
+	for id, v := range data {
+		if _, overwritten := p.writes[id]; !overwritten {
+			list = append(list, v)
+		}
+	}
+	for _, id := range p.order {
+		if w := p.writes[id]; w != nil {
+			list = append(list, *w)
+		}
+	}
+	return list
+}
+
+func (tx *memoryTxn) get(id int) (ToDo, error) {
+	todo, ok := tx.todos.get(tx.b.data, id)
+	if !ok {
+		return ToDo{}, ErrNotFound
+	}
+	return todo, nil
+}
+
+func (tx *memoryTxn) put(todo ToDo) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	tx.todos.write(todo.ID, &todo)
+	return nil
+}
+
+func (tx *memoryTxn) remove(id int) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	tx.todos.write(id, nil)
+	return nil
+}
+
+func (tx *memoryTxn) nextID() (int, error) {
+	if tx.readOnly {
+		return 0, errReadOnlyTxn
+	}
+	tx.ids.Count++
+	return tx.ids.Count, nil
+}
+
+func (tx *memoryTxn) all() ([]ToDo, error) {
+	return tx.todos.all(tx.b.data), nil
+}
+
+func (tx *memoryTxn) getTrashed(id int) (ToDo, error) {
+	todo, ok := tx.trash.get(tx.b.trash, id)
+	if !ok {
+		return ToDo{}, ErrNotFound
+	}
+	return todo, nil
+}
+
+func (tx *memoryTxn) putTrashed(todo ToDo) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	tx.trash.write(todo.ID, &todo)
+	return nil
+}
+
+func (tx *memoryTxn) removeTrashed(id int) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	tx.trash.write(id, nil)
+	return nil
+}
+
+func (tx *memoryTxn) allTrashed() ([]ToDo, error) {
+	return tx.trash.all(tx.b.trash), nil
+}
+
+func (tx *memoryTxn) getProject(id int) (Project, error) {
+	project, ok := tx.projects.get(tx.b.projects, id)
+	if !ok {
+		return Project{}, ErrProjectNotFound
+	}
+	return project, nil
+}
+
+func (tx *memoryTxn) putProject(project Project) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	tx.projects.write(project.ID, &project)
+	return nil
+}
+
+func (tx *memoryTxn) removeProject(id int) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	tx.projects.write(id, nil)
+	return nil
+}
+
+func (tx *memoryTxn) nextProjectID() (int, error) {
+	if tx.readOnly {
+		return 0, errReadOnlyTxn
+	}
+	tx.ids.ProjectCount++
+	return tx.ids.ProjectCount, nil
+}
+
+func (tx *memoryTxn) allProjects() ([]Project, error) {
+	return tx.projects.all(tx.b.projects), nil
+}
+
+func (tx *memoryTxn) appendHistory(entry HistoryEntry) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	entry.Seq = len(tx.b.history[entry.TaskID]) + 1
+	for _, pending := range tx.entries {
+		if pending.TaskID == entry.TaskID {
+			entry.Seq++
+		}
+	}
+	tx.entries = append(tx.entries, entry)
+	return nil
+}
+
+func (tx *memoryTxn) history(id int) ([]HistoryEntry, error) {
+	entries := slices.Clone(tx.b.history[id])
+	for _, pending := range tx.entries {
+		if pending.TaskID == id {
+			entries = append(entries, pending)
+		}
+	}
+	return entries, nil
+}
+
+// changes returns the buffered writes in the order they were first made,
+// items before trashed items and projects, followed by history entries.
+func (tx *memoryTxn) changes() []storeChange {
+	changes := make([]storeChange, 0, len(tx.todos.order)+len(tx.trash.order)+len(tx.projects.order)+len(tx.entries))
+	for _, id := range tx.todos.order {
+		changes = append(changes, storeChange{ID: id, ToDo: tx.todos.writes[id]})
+	}
+	for _, id := range tx.trash.order {
+		changes = append(changes, storeChange{Kind: changeTrash, ID: id, ToDo: tx.trash.writes[id]})
+	}
+	for _, id := range tx.projects.order {
+		changes = append(changes, storeChange{Kind: changeProject, ID: id, Project: tx.projects.writes[id]})
+	}
+	for i := range tx.entries {
+		changes = append(changes, storeChange{Kind: changeHistory, ID: tx.entries[i].TaskID, Entry: &tx.entries[i]})
+	}
+	return changes
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite" // Pure-Go driver, registers "sqlite"; no cgo needed
+)
+
+// sqliteMigrations are applied in order and tracked with PRAGMA user_version,
+// so a migration must never be edited once released; append a new one instead.
+//
+// Each ToDo is stored as a JSON document; the columns queried by the store
+// (and by people poking at the database) are generated from it, so adding a
+// field to ToDo only needs a migration when it should be indexed.
+var sqliteMigrations = []string{
+	// 1: documents, generated query columns, ID counter and trigram full-text search.
+	`CREATE TABLE todos (
+		id           INTEGER PRIMARY KEY,
+		doc          TEXT NOT NULL CHECK (json_valid(doc)),
+		text         TEXT GENERATED ALWAYS AS (json_extract(doc, '$.text')) VIRTUAL,
+		completed    INTEGER GENERATED ALWAYS AS (coalesce(json_extract(doc, '$.completed'), 0)) VIRTUAL,
+		completed_at TEXT GENERATED ALWAYS AS (json_extract(doc, '$.completed_at')) VIRTUAL,
+		completed_by TEXT GENERATED ALWAYS AS (json_extract(doc, '$.completed_by')) VIRTUAL
+	);
+	CREATE INDEX todos_completed ON todos (completed, id);
+	CREATE TABLE store_meta (
+		key   TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	);
+	INSERT INTO store_meta (key, value) VALUES ('count', 0);
+	CREATE VIRTUAL TABLE todos_fts USING fts5(text, content = 'todos', content_rowid = 'id', tokenize = 'trigram');
+	CREATE TRIGGER todos_fts_insert AFTER INSERT ON todos BEGIN
+		INSERT INTO todos_fts (rowid, text) VALUES (new.id, new.text);
+	END;
+	CREATE TRIGGER todos_fts_delete AFTER DELETE ON todos BEGIN
+		INSERT INTO todos_fts (todos_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	END;
+	CREATE TRIGGER todos_fts_update AFTER UPDATE ON todos BEGIN
+		INSERT INTO todos_fts (todos_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		INSERT INTO todos_fts (rowid, text) VALUES (new.id, new.text);
+	END;`,
+	// 2: due-time and overdue columns for the due_before/due_after/overdue filters.
+	`ALTER TABLE todos ADD COLUMN due_at INTEGER GENERATED ALWAYS AS (unixepoch(json_extract(doc, '$.due'))) VIRTUAL;
+	ALTER TABLE todos ADD COLUMN overdue INTEGER GENERATED ALWAYS AS (coalesce(json_extract(doc, '$.overdue'), 0)) VIRTUAL;
+	CREATE INDEX todos_due ON todos (due_at) WHERE due_at IS NOT NULL;
+	CREATE INDEX todos_overdue ON todos (overdue, id);`,
+	// 3: manual ordering key, indexed so appends find the last position cheaply.
+	`ALTER TABLE todos ADD COLUMN position TEXT GENERATED ALWAYS AS (json_extract(doc, '$.position')) VIRTUAL;
+	CREATE INDEX todos_position ON todos (position);`,
+	// 4: projects, their ID counter and the owning project of each item (0 for none).
+	`CREATE TABLE projects (
+		id   INTEGER PRIMARY KEY,
+		doc  TEXT NOT NULL CHECK (json_valid(doc)),
+		name TEXT GENERATED ALWAYS AS (json_extract(doc, '$.name')) VIRTUAL
+	);
+	INSERT INTO store_meta (key, value) VALUES ('project_count', 0);
+	ALTER TABLE todos ADD COLUMN project INTEGER GENERATED ALWAYS AS (coalesce(json_extract(doc, '$.project'), 0)) VIRTUAL;
+	CREATE INDEX todos_project ON todos (project, id);`,
+	// 5: parent of each item (0 for top-level ones), indexed to find children.
+	`ALTER TABLE todos ADD COLUMN parent INTEGER GENERATED ALWAYS AS (coalesce(json_extract(doc, '$.parent'), 0)) VIRTUAL;
+	CREATE INDEX todos_parent ON todos (parent, id);`,
+	// 6: blocked flag for the blocked= filter; dependents are found through json_each(blocked_by).
+	`ALTER TABLE todos ADD COLUMN blocked INTEGER GENERATED ALWAYS AS (coalesce(json_extract(doc, '$.blocked'), 0)) VIRTUAL;
+	CREATE INDEX todos_blocked ON todos (blocked, id);`,
+	// 7: append-only history of every item, kept after the item is deleted.
+	`CREATE TABLE history (
+		task_id INTEGER NOT NULL,
+		seq     INTEGER NOT NULL,
+		doc     TEXT NOT NULL CHECK (json_valid(doc)),
+		PRIMARY KEY (task_id, seq)
+	) WITHOUT ROWID;`,
+	// 8: deleted items awaiting restore or purge.
+	`CREATE TABLE trash (
+		id  INTEGER PRIMARY KEY,
+		doc TEXT NOT NULL CHECK (json_valid(doc))
+	);`,
+}
+
+// sqliteBackend stores ToDo items in an embedded SQLite database.
+type sqliteBackend struct {
+	db   *sql.DB
+	name string
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path and
+// migrates it to the latest schema.
+func NewSQLiteStore(path string) (Store, error) {
+	dsn := "file:" + path + "?" + url.Values{"_pragma": {
+		"busy_timeout(5000)",
+		"journal_mode(WAL)",
+		"synchronous(FULL)",
+	}}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+	// SQLite has a single writer; one connection serialises transactions the
+	// same way the memory backend's mutex does (and keeps :memory: databases shared).
+	db.SetMaxOpenConns(1)
+
+	b := &sqliteBackend{db: db, name: path}
+	if err := b.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newTaskStore(b), nil
+}
+
+// migrate applies every migration newer than the database's user_version.
+func (b *sqliteBackend) migrate(ctx context.Context) error {
+	ctx, span := otel.Tracer("todo-service").Start(ctx, "sqlite.migrate", oteltrace.WithAttributes(
+		semconv.DBSystemSqlite,
+		semconv.DBNameKey.String(b.name),
+	))
+	defer span.End()
+
+	var version int
+	if err := b.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read schema version failed")
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	span.SetAttributes(attribute.Int("db.schema_version.from", version))
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := b.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "migration failed")
+			return fmt.Errorf("apply migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters.
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %d: %w", i+1, err)
+		}
+	}
+	span.SetAttributes(attribute.Int("db.schema_version.to", len(sqliteMigrations)))
+	return nil
+}
+
+func (b *sqliteBackend) view(ctx context.Context, fn func(tx txn) error) error {
+	return b.run(ctx, "view", true, fn)
+}
+
+func (b *sqliteBackend) update(ctx context.Context, op string, fn func(tx txn) error) error {
+	return b.run(ctx, op, false, fn)
+}
+
+// run executes fn inside a database transaction wrapped in a span.
+func (b *sqliteBackend) run(ctx context.Context, op string, readOnly bool, fn func(tx txn) error) (err error) {
+	ctx, span := otel.Tracer("todo-service").Start(ctx, "sqlite."+op, oteltrace.WithAttributes(
+		semconv.DBSystemSqlite,
+		semconv.DBNameKey.String(b.name),
+		attribute.Bool("db.read_only", readOnly),
+	))
+	defer func() {
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	sqlTx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin sqlite transaction: %w", err)
+	}
+	if err := fn(&sqliteTxn{ctx: ctx, tx: sqlTx, readOnly: readOnly}); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	if readOnly {
+		return sqlTx.Rollback()
+	}
+	return sqlTx.Commit()
+}
+
+func (b *sqliteBackend) close() error {
+	return b.db.Close()
+}
+
+// sqliteTxn runs statements in one transaction, each in its own child span.
+type sqliteTxn struct {
+	ctx      context.Context
+	tx       *sql.Tx
+	readOnly bool
+}
+
+// startSpan opens a span for one statement; name is "<OPERATION> <table>".
+func (tx *sqliteTxn) startSpan(name, query string) (context.Context, oteltrace.Span) {
+	operation, table, _ := strings.Cut(name, " ")
+	return otel.Tracer("todo-service").Start(tx.ctx, name, oteltrace.WithSpanKind(oteltrace.SpanKindClient), oteltrace.WithAttributes(
+		semconv.DBSystemSqlite,
+		semconv.DBOperationKey.String(operation),
+		semconv.DBSQLTableKey.String(table),
+		semconv.DBStatementKey.String(query),
+	))
+}
+
+func (tx *sqliteTxn) exec(name, query string, args ...any) (sql.Result, error) {
+	ctx, span := tx.startSpan(name, query)
+	defer span.End()
+	res, err := tx.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, err
+}
+
+// queryDocs runs a SELECT returning a single doc column and decodes every row.
+func (tx *sqliteTxn) queryDocs(name, query string, args ...any) ([]ToDo, error) {
+	return queryDocuments[ToDo](tx, name, query, args...)
+}
+
+// queryDocuments decodes the doc column of every row into a T.
+func queryDocuments[T any](tx *sqliteTxn, name, query string, args ...any) ([]T, error) {
+	ctx, span := tx.startSpan(name, query)
+	defer span.End()
+	rows, err := tx.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	docs := []T{}
+	for rows.Next() {
+		var doc string
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		var v T
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			return nil, fmt.Errorf("decode %T document: %w", v, err)
+		}
+		docs = append(docs, v)
+	}
+	span.SetAttributes(attribute.Int("db.rows", len(docs)))
+	return docs, rows.Err()
+}
+
+func (tx *sqliteTxn) get(id int) (ToDo, error) {
+	todos, err := tx.queryDocs("SELECT todos", "SELECT doc FROM todos WHERE id = ?", id)
+	if err != nil {
+		return ToDo{}, err
+	}
+	if len(todos) == 0 {
+		return ToDo{}, ErrNotFound
+	}
+	return todos[0], nil
+}
+
+func (tx *sqliteTxn) put(todo ToDo) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	doc, err := json.Marshal(todo)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("INSERT todos",
+		"INSERT INTO todos (id, doc) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET doc = excluded.doc",
+		todo.ID, string(doc))
+	return err
+}
+
+func (tx *sqliteTxn) remove(id int) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	_, err := tx.exec("DELETE todos", "DELETE FROM todos WHERE id = ?", id)
+	return err
+}
+
+func (tx *sqliteTxn) nextID() (int, error) {
+	return tx.increment("count")
+}
+
+// increment bumps the store_meta counter key and returns its new value.
+func (tx *sqliteTxn) increment(key string) (int, error) {
+	if tx.readOnly {
+		return 0, errReadOnlyTxn
+	}
+	const query = "UPDATE store_meta SET value = value + 1 WHERE key = ? RETURNING value"
+	ctx, span := tx.startSpan("UPDATE store_meta", query)
+	defer span.End()
+	var id int
+	if err := tx.tx.QueryRowContext(ctx, query, key).Scan(&id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	return id, nil
+}
+
+func (tx *sqliteTxn) all() ([]ToDo, error) {
+	return tx.queryDocs("SELECT todos", "SELECT doc FROM todos ORDER BY id")
+}
+
+func (tx *sqliteTxn) getTrashed(id int) (ToDo, error) {
+	todos, err := tx.queryDocs("SELECT trash", "SELECT doc FROM trash WHERE id = ?", id)
+	if err != nil {
+		return ToDo{}, err
+	}
+	if len(todos) == 0 {
+		return ToDo{}, ErrNotFound
+	}
+	return todos[0], nil
+}
+
+func (tx *sqliteTxn) putTrashed(todo ToDo) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	doc, err := json.Marshal(todo)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("INSERT trash",
+		"INSERT INTO trash (id, doc) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET doc = excluded.doc",
+		todo.ID, string(doc))
+	return err
+}
+
+func (tx *sqliteTxn) removeTrashed(id int) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	_, err := tx.exec("DELETE trash", "DELETE FROM trash WHERE id = ?", id)
+	return err
+}
+
+func (tx *sqliteTxn) allTrashed() ([]ToDo, error) {
+	return tx.queryDocs("SELECT trash", "SELECT doc FROM trash ORDER BY id")
+}
+
+func (tx *sqliteTxn) getProject(id int) (Project, error) {
+	projects, err := queryDocuments[Project](tx, "SELECT projects", "SELECT doc FROM projects WHERE id = ?", id)
+	if err != nil {
+		return Project{}, err
+	}
+	if len(projects) == 0 {
+		return Project{}, ErrProjectNotFound
+	}
+	return projects[0], nil
+}
+
+func (tx *sqliteTxn) putProject(project Project) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	doc, err := json.Marshal(project)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("INSERT projects",
+		"INSERT INTO projects (id, doc) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET doc = excluded.doc",
+		project.ID, string(doc))
+	return err
+}
+
+func (tx *sqliteTxn) removeProject(id int) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	_, err := tx.exec("DELETE projects", "DELETE FROM projects WHERE id = ?", id)
+	return err
+}
+
+func (tx *sqliteTxn) nextProjectID() (int, error) {
+	return tx.increment("project_count")
+}
+
+func (tx *sqliteTxn) allProjects() ([]Project, error) {
+	return queryDocuments[Project](tx, "SELECT projects", "SELECT doc FROM projects ORDER BY id")
+}
+
+func (tx *sqliteTxn) appendHistory(entry HistoryEntry) error {
+	if tx.readOnly {
+		return errReadOnlyTxn
+	}
+	const query = "SELECT coalesce(max(seq), 0) + 1 FROM history WHERE task_id = ?"
+	ctx, span := tx.startSpan("SELECT history", query)
+	err := tx.tx.QueryRowContext(ctx, query, entry.TaskID).Scan(&entry.Seq)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("INSERT history", "INSERT INTO history (task_id, seq, doc) VALUES (?, ?, ?)", entry.TaskID, entry.Seq, string(doc))
+	return err
+}
+
+func (tx *sqliteTxn) history(id int) ([]HistoryEntry, error) {
+	return queryDocuments[HistoryEntry](tx, "SELECT history", "SELECT doc FROM history WHERE task_id = ? ORDER BY seq", id)
+}
+
+// candidates narrows List with the completed, project, parent, blocked and
+// due indexes and filters tags and dependencies with json_each. due_at is
+// truncated to whole seconds, so its bounds are inclusive.
+func (tx *sqliteTxn) candidates(filter ListFilter) ([]ToDo, error) {
+	var where []string
+	var args []any
+	if filter.Completed != nil {
+		where = append(where, "completed = ?")
+		args = append(args, *filter.Completed)
+	}
+	if filter.Project != nil {
+		where = append(where, "project = ?")
+		args = append(args, *filter.Project)
+	}
+	if filter.Parent != nil {
+		where = append(where, "parent = ?")
+		args = append(args, *filter.Parent)
+	}
+	if filter.Blocked != nil {
+		where = append(where, "blocked = ?")
+		args = append(args, *filter.Blocked)
+	}
+	if filter.DependsOn != nil {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(doc, '$.blocked_by') WHERE value = ?)")
+		args = append(args, *filter.DependsOn)
+	}
+	if filter.Overdue != nil {
+		where = append(where, "overdue = ?")
+		args = append(args, *filter.Overdue)
+	}
+	if filter.DueBefore != nil {
+		where = append(where, "due_at <= ?")
+		args = append(args, filter.DueBefore.Unix())
+	}
+	if filter.DueAfter != nil {
+		where = append(where, "due_at >= ?")
+		args = append(args, filter.DueAfter.Unix())
+	}
+	if len(filter.TagsAny) > 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(doc, '$.tags') WHERE value IN ("+placeholders(len(filter.TagsAny))+"))")
+		for _, tag := range filter.TagsAny {
+			args = append(args, tag)
+		}
+	}
+	for _, tag := range filter.TagsAll {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(doc, '$.tags') WHERE value = ?)")
+		args = append(args, tag)
+	}
+	if len(filter.TagsNone) > 0 {
+		where = append(where, "NOT EXISTS (SELECT 1 FROM json_each(doc, '$.tags') WHERE value IN ("+placeholders(len(filter.TagsNone))+"))")
+		for _, tag := range filter.TagsNone {
+			args = append(args, tag)
+		}
+	}
+	if len(where) == 0 {
+		return tx.all()
+	}
+	return tx.queryDocs("SELECT todos", "SELECT doc FROM todos WHERE "+strings.Join(where, " AND ")+" ORDER BY id", args...)
+}
+
+// lastPosition reads the greatest position from the position index.
+func (tx *sqliteTxn) lastPosition() (string, error) {
+	const query = "SELECT coalesce(max(position), '') FROM todos"
+	ctx, span := tx.startSpan("SELECT todos", query)
+	defer span.End()
+	var last string
+	if err := tx.tx.QueryRowContext(ctx, query).Scan(&last); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	return last, nil
+}
+
+// searchCandidates uses the trigram index, which serves case-sensitive GLOB
+// substring queries the same way contains matches them.
+func (tx *sqliteTxn) searchCandidates(query string) ([]ToDo, error) {
+	return tx.queryDocs("SELECT todos_fts",
+		"SELECT doc FROM todos WHERE id IN (SELECT rowid FROM todos_fts WHERE text GLOB ?) ORDER BY id",
+		"*"+globEscape(query)+"*")
+}
+
+// placeholders returns n comma-separated bind parameters.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// globEscape quotes GLOB metacharacters so query matches literally.
+func globEscape(query string) string {
+	var b strings.Builder
+	for _, r := range query {
+		switch r {
+		case '*', '?', '[':
+			b.WriteByte('[')
+			b.WriteRune(r)
+			b.WriteByte(']')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,990 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// storeFactory opens a fresh, empty Store for a conformance test.
+type storeFactory func(t *testing.T) Store
+
+// TestStoreConformance runs the shared Store suite against every backend.
+func TestStoreConformance(t *testing.T) {
+	backends := map[string]storeFactory{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file": func(t *testing.T) Store {
+			s, err := NewFileStore(filepath.Join(t.TempDir(), "todos.json"))
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			return s
+		},
+		"wal": func(t *testing.T) Store {
+			s, err := NewWALStore(filepath.Join(t.TempDir(), "todos.json"), walOptions{CompactBytes: 512})
+			if err != nil {
+				t.Fatalf("NewWALStore: %v", err)
+			}
+			return s
+		},
+		"sqlite": func(t *testing.T) Store {
+			s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "todos.db"))
+			if err != nil {
+				t.Fatalf("NewSQLiteStore: %v", err)
+			}
+			return s
+		},
+	}
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) { runStoreConformance(t, open) })
+	}
+}
+
+func runStoreConformance(t *testing.T, open storeFactory) {
+	ctx := context.Background()
+
+	t.Run("AddAssignsSequentialIDs", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		a, err := s.Add(ctx, ToDo{ID: 42, Text: "first"})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		b, _ := s.Add(ctx, ToDo{Text: "second"})
+		if a.ID != 1 || b.ID != 2 {
+			t.Errorf("got IDs %d, %d, want 1, 2", a.ID, b.ID)
+		}
+		got, err := s.Get(ctx, a.ID)
+		if err != nil || got.Text != "first" {
+			t.Errorf("Get(%d) = %+v, %v", a.ID, got, err)
+		}
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		if _, err := s.Get(ctx, 999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(999) error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		todo, _ := s.Add(ctx, ToDo{Text: "draft"})
+		updated, err := s.Update(ctx, ToDo{ID: todo.ID, Text: "final"}, 0)
+		if err != nil || updated.Text != "final" {
+			t.Fatalf("Update = %+v, %v", updated, err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: 999, Text: "x"}, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update(999) error = %v, want ErrNotFound", err)
+		}
+		if err := s.Delete(ctx, todo.ID, 0); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if err := s.Delete(ctx, todo.ID, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("second Delete error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Get(ctx, todo.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+		}
+		next, _ := s.Add(ctx, ToDo{Text: "after delete"})
+		if next.ID == todo.ID {
+			t.Errorf("deleted ID %d was reused", todo.ID)
+		}
+	})
+
+	t.Run("CompleteAndUncomplete", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		todo, _ := s.Add(ctx, ToDo{Text: "task"})
+		done, err := s.Complete(ctx, todo.ID, "alice", false, 0)
+		if err != nil || !done.Completed || done.CompletedAt == nil || done.CompletedBy != "alice" {
+			t.Fatalf("Complete = %+v, %v", done, err)
+		}
+		again, _ := s.Complete(ctx, todo.ID, "bob", false, 0)
+		if again.CompletedBy != "alice" || !again.CompletedAt.Equal(*done.CompletedAt) {
+			t.Errorf("re-completing changed completion state: %+v", again)
+		}
+		stored, _ := s.Get(ctx, todo.ID)
+		if !stored.Completed {
+			t.Errorf("completion not persisted: %+v", stored)
+		}
+		reopened, err := s.Uncomplete(ctx, todo.ID, 0)
+		if err != nil || reopened.Completed || reopened.CompletedAt != nil || reopened.CompletedBy != "" {
+			t.Errorf("Uncomplete = %+v, %v", reopened, err)
+		}
+		if _, err := s.Complete(ctx, 999, "alice", false, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Complete(999) error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("VersionsAndConditionalWrites", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		todo, _ := s.Add(ctx, ToDo{Text: "v1"})
+		if todo.Version != 1 {
+			t.Fatalf("new item has version %d, want 1", todo.Version)
+		}
+		updated, err := s.Update(ctx, ToDo{ID: todo.ID, Text: "v2"}, todo.Version)
+		if err != nil || updated.Version != 2 {
+			t.Fatalf("conditional Update = %+v, %v", updated, err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: todo.ID, Text: "stale"}, todo.Version); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("stale Update error = %v, want ErrVersionMismatch", err)
+		}
+		if _, err := s.Complete(ctx, todo.ID, "alice", false, todo.Version); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("stale Complete error = %v, want ErrVersionMismatch", err)
+		}
+		if err := s.Delete(ctx, todo.ID, todo.Version); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("stale Delete error = %v, want ErrVersionMismatch", err)
+		}
+		done, _ := s.Complete(ctx, todo.ID, "alice", false, 0)
+		again, _ := s.Complete(ctx, todo.ID, "alice", false, 0)
+		if done.Version != 3 || again.Version != 3 {
+			t.Errorf("versions after complete = %d, %d; a no-op must not bump the version", done.Version, again.Version)
+		}
+		if err := s.Delete(ctx, todo.ID, done.Version); err != nil {
+			t.Errorf("conditional Delete: %v", err)
+		}
+	})
+
+	t.Run("ReplaceKeepsServerFields", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		todo, _ := s.Add(ctx, ToDo{Text: "before"})
+		edited := todo
+		edited.Text = "after"
+		edited.Completed = true
+		edited.Version = 99
+		edited.CompletedBy = "mallory"
+		replaced, err := s.Replace(ctx, edited, "alice", todo.Version)
+		if err != nil {
+			t.Fatalf("Replace: %v", err)
+		}
+		if replaced.Text != "after" || !replaced.Completed || replaced.CompletedBy != "alice" || replaced.Version != 2 {
+			t.Errorf("Replace = %+v", replaced)
+		}
+		if !replaced.CreatedAt.Equal(todo.CreatedAt) {
+			t.Errorf("Replace changed created_at")
+		}
+		if _, err := s.Replace(ctx, edited, "alice", todo.Version); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("stale Replace error = %v, want ErrVersionMismatch", err)
+		}
+	})
+
+	t.Run("ListFiltersByStatus", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		s.Add(ctx, ToDo{Text: "open"})
+		done, _ := s.Add(ctx, ToDo{Text: "done"})
+		s.Complete(ctx, done.ID, "alice", false, 0)
+
+		all, _ := s.List(ctx, ListFilter{})
+		openOnly, _ := parseStatusFilter("open")
+		open, _ := s.List(ctx, openOnly)
+		doneOnly, _ := parseStatusFilter("done")
+		closed, _ := s.List(ctx, doneOnly)
+		if len(all) != 2 || len(open) != 1 || len(closed) != 1 || closed[0].ID != done.ID {
+			t.Errorf("List: all=%v open=%v done=%v", all, open, closed)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		s.Add(ctx, ToDo{Text: "Write code"})
+		s.Add(ctx, ToDo{Text: "Ship code"})
+		s.Add(ctx, ToDo{Text: "Write docs"})
+		results, err := s.Search(ctx, "Write", ListFilter{})
+		if err != nil || len(results) != 2 {
+			t.Errorf("Search(Write) = %v, %v, want 2 results", results, err)
+		}
+		results, _ = s.Search(ctx, "write", ListFilter{})
+		if len(results) != 0 {
+			t.Errorf("Search is case-sensitive, got %v for lower-case query", results)
+		}
+		s.Add(ctx, ToDo{Text: "Glob *chars*?"})
+		results, _ = s.Search(ctx, "*chars*", ListFilter{})
+		if len(results) != 1 {
+			t.Errorf("Search(*chars*) = %v, want the literal match only", results)
+		}
+		results, _ = s.Search(ctx, "nothing", ListFilter{})
+		if results == nil || len(results) != 0 {
+			t.Errorf("Search(nothing) = %#v, want empty slice", results)
+		}
+	})
+
+	t.Run("PrioritiesAndPositions", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		a, _ := s.Add(ctx, ToDo{Text: "a", Priority: "P1"})
+		b, _ := s.Add(ctx, ToDo{Text: "b"})
+		c, _ := s.Add(ctx, ToDo{Text: "c"})
+		if a.Position == "" || !(a.Position < b.Position && b.Position < c.Position) {
+			t.Fatalf("appended positions %q, %q, %q are not increasing", a.Position, b.Position, c.Position)
+		}
+		if got, _ := s.Get(ctx, a.ID); got.Priority != "P1" {
+			t.Errorf("priority = %q, want P1", got.Priority)
+		}
+
+		moved, err := s.Move(ctx, c.ID, a.ID, true, c.Version)
+		if err != nil || moved.Position >= a.Position || moved.Version != c.Version+1 {
+			t.Fatalf("Move(c before a) = %+v, %v", moved, err)
+		}
+		moved, _ = s.Move(ctx, a.ID, b.ID, false, 0)
+		list, _ := s.List(ctx, ListFilter{})
+		slices.SortFunc(list, comparePosition)
+		var order []string
+		for _, todo := range list {
+			order = append(order, todo.Text)
+		}
+		if !slices.Equal(order, []string{"c", "b", "a"}) {
+			t.Errorf("position order = %v, want [c b a]", order)
+		}
+		if got, _ := s.Get(ctx, b.ID); got.Version != b.Version {
+			t.Errorf("moving neighbours rewrote b (version %d, want %d)", got.Version, b.Version)
+		}
+		if _, err := s.Move(ctx, a.ID, 999, false, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Move relative to a missing task error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Move(ctx, a.ID, b.ID, true, a.Version); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("Move with stale version error = %v, want ErrVersionMismatch", err)
+		}
+		d, _ := s.Add(ctx, ToDo{Text: "d"})
+		if d.Position <= moved.Position {
+			t.Errorf("append after moves got position %q, not after %q", d.Position, moved.Position)
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		a, _ := s.Add(ctx, ToDo{Text: "a", Tags: []string{"home", "urgent"}})
+		b, _ := s.Add(ctx, ToDo{Text: "b", Tags: []string{"work"}})
+		s.Add(ctx, ToDo{Text: "c"})
+
+		tagged, err := s.AddTag(ctx, b.ID, "urgent", 0, b.Version)
+		if err != nil || !slices.Equal(tagged.Tags, []string{"urgent", "work"}) || tagged.Version != b.Version+1 {
+			t.Fatalf("AddTag = %+v, %v", tagged, err)
+		}
+		if again, _ := s.AddTag(ctx, b.ID, "urgent", 0, 0); again.Version != tagged.Version {
+			t.Errorf("adding a present tag bumped the version to %d", again.Version)
+		}
+		if _, err := s.AddTag(ctx, b.ID, "later", 2, 0); !errors.Is(err, ErrTooManyTags) {
+			t.Errorf("AddTag beyond the limit error = %v, want ErrTooManyTags", err)
+		}
+		untagged, err := s.RemoveTag(ctx, a.ID, "home", 0)
+		if err != nil || !slices.Equal(untagged.Tags, []string{"urgent"}) {
+			t.Fatalf("RemoveTag = %+v, %v", untagged, err)
+		}
+
+		texts := func(todos []ToDo) []string {
+			var texts []string
+			for _, todo := range todos {
+				texts = append(texts, todo.Text)
+			}
+			return texts
+		}
+		for _, tc := range []struct {
+			filter ListFilter
+			want   []string
+		}{
+			{ListFilter{TagsAny: []string{"work", "home"}}, []string{"b"}},
+			{ListFilter{TagsAll: []string{"urgent", "work"}}, []string{"b"}},
+			{ListFilter{TagsNone: []string{"urgent"}}, []string{"c"}},
+		} {
+			if got, _ := s.List(ctx, tc.filter); !slices.Equal(texts(got), tc.want) {
+				t.Errorf("List(%+v) = %v, want %v", tc.filter, texts(got), tc.want)
+			}
+		}
+		if got, _ := s.Search(ctx, "a", ListFilter{TagsAny: []string{"urgent"}}); !slices.Equal(texts(got), []string{"a"}) {
+			t.Errorf("Search with tag filter = %v", texts(got))
+		}
+		counts, err := s.Tags(ctx, ListFilter{})
+		if want := []TagCount{{"urgent", 2}, {"work", 1}}; err != nil || !slices.Equal(counts, want) {
+			t.Errorf("Tags = %v, %v, want %v", counts, err, want)
+		}
+	})
+
+	t.Run("Projects", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		home, err := s.AddProject(ctx, Project{Name: "Home"})
+		if err != nil || home.ID != 1 || home.Version != 1 || home.CreatedAt.IsZero() {
+			t.Fatalf("AddProject = %+v, %v", home, err)
+		}
+		work, _ := s.AddProject(ctx, Project{Name: "Work"})
+		if _, err := s.AddProject(ctx, Project{Name: "home"}); !errors.Is(err, ErrProjectExists) {
+			t.Errorf("duplicate name error = %v, want ErrProjectExists", err)
+		}
+		if _, err := s.Add(ctx, ToDo{Text: "orphan", Project: 99}); !errors.Is(err, ErrProjectNotFound) {
+			t.Errorf("Add to missing project error = %v, want ErrProjectNotFound", err)
+		}
+
+		a, _ := s.Add(ctx, ToDo{Text: "dishes", Project: home.ID})
+		b, _ := s.Add(ctx, ToDo{Text: "report", Project: home.ID})
+		s.Add(ctx, ToDo{Text: "inbox"})
+		s.Complete(ctx, a.ID, "alice", false, 0)
+		moved, err := s.Update(ctx, ToDo{ID: b.ID, Text: "report", Project: work.ID}, 0)
+		if err != nil || moved.Project != work.ID || moved.Version != b.Version+1 {
+			t.Fatalf("moving between projects = %+v, %v", moved, err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: b.ID, Text: "report", Project: 99}, 0); !errors.Is(err, ErrProjectNotFound) {
+			t.Errorf("move to missing project error = %v, want ErrProjectNotFound", err)
+		}
+
+		none := 0
+		for _, tc := range []struct {
+			project *int
+			want    []string
+		}{
+			{&home.ID, []string{"dishes"}},
+			{&work.ID, []string{"report"}},
+			{&none, []string{"inbox"}},
+		} {
+			got, _ := s.List(ctx, ListFilter{Project: tc.project})
+			var texts []string
+			for _, todo := range got {
+				texts = append(texts, todo.Text)
+			}
+			if !slices.Equal(texts, tc.want) {
+				t.Errorf("List(project %d) = %v, want %v", *tc.project, texts, tc.want)
+			}
+		}
+		if got, _ := s.Search(ctx, "r", ListFilter{Project: &home.ID}); len(got) != 0 {
+			t.Errorf("project-scoped Search = %v, want nothing", got)
+		}
+
+		summary, err := s.GetProject(ctx, home.ID)
+		if want := (ProjectCounts{Total: 1, Completed: 1}); err != nil || summary.Counts != want {
+			t.Errorf("GetProject counts = %+v, %v, want %+v", summary.Counts, err, want)
+		}
+		projects, _ := s.Projects(ctx)
+		if len(projects) != 2 || projects[1].Name != "Work" || projects[1].Counts.Open != 1 {
+			t.Errorf("Projects = %+v", projects)
+		}
+
+		renamed, err := s.RenameProject(ctx, work.ID, "Office", work.Version)
+		if err != nil || renamed.Name != "Office" || renamed.Version != work.Version+1 {
+			t.Errorf("RenameProject = %+v, %v", renamed, err)
+		}
+		if _, err := s.RenameProject(ctx, work.ID, "HOME", 0); !errors.Is(err, ErrProjectExists) {
+			t.Errorf("rename to a taken name error = %v, want ErrProjectExists", err)
+		}
+		if err := s.DeleteProject(ctx, work.ID, 0); !errors.Is(err, ErrProjectNotEmpty) {
+			t.Errorf("deleting a project with tasks error = %v, want ErrProjectNotEmpty", err)
+		}
+		s.Update(ctx, ToDo{ID: b.ID, Text: "report"}, 0)
+		if err := s.DeleteProject(ctx, work.ID, work.Version); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("stale DeleteProject error = %v, want ErrVersionMismatch", err)
+		}
+		if err := s.DeleteProject(ctx, work.ID, 0); err != nil {
+			t.Fatalf("DeleteProject: %v", err)
+		}
+		if _, err := s.GetProject(ctx, work.ID); !errors.Is(err, ErrProjectNotFound) {
+			t.Errorf("GetProject after delete error = %v, want ErrProjectNotFound", err)
+		}
+	})
+
+	t.Run("Subtasks", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		root, _ := s.Add(ctx, ToDo{Text: "release"})
+		a, _ := s.Add(ctx, ToDo{Text: "build", Parent: root.ID})
+		b, _ := s.Add(ctx, ToDo{Text: "test", Parent: root.ID})
+		leaf, _ := s.Add(ctx, ToDo{Text: "unit", Parent: b.ID})
+		if _, err := s.Add(ctx, ToDo{Text: "orphan", Parent: 99}); !errors.Is(err, ErrParentNotFound) {
+			t.Errorf("Add under a missing parent error = %v, want ErrParentNotFound", err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: root.ID, Text: "release", Parent: leaf.ID}, 0); !errors.Is(err, ErrCycle) {
+			t.Errorf("parenting a task under its descendant error = %v, want ErrCycle", err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: a.ID, Text: "build", Parent: a.ID}, 0); !errors.Is(err, ErrCycle) {
+			t.Errorf("parenting a task under itself error = %v, want ErrCycle", err)
+		}
+
+		s.Complete(ctx, a.ID, "alice", false, 0)
+		got, _ := s.Get(ctx, root.ID)
+		if want := (TaskProgress{Done: 1, Total: 2, Percent: 50}); got.Progress == nil || *got.Progress != want || got.Completed {
+			t.Errorf("root after one child done = %+v, progress %+v", got, got.Progress)
+		}
+
+		// Completing the last leaf cascades up through its parent to the root
+		s.Complete(ctx, leaf.ID, "bob", false, 0)
+		got, _ = s.Get(ctx, root.ID)
+		if !got.Completed || got.CompletedBy != "bob" || got.Progress.Percent != 100 {
+			t.Errorf("root after every child done = %+v", got)
+		}
+		s.Uncomplete(ctx, leaf.ID, 0)
+		if got, _ = s.Get(ctx, root.ID); got.Completed {
+			t.Errorf("root still completed after a leaf reopened")
+		}
+
+		if err := s.Delete(ctx, b.ID, 0); !errors.Is(err, ErrHasSubtasks) {
+			t.Errorf("deleting a parent error = %v, want ErrHasSubtasks", err)
+		}
+		tree, err := s.Tree(ctx, root.ID)
+		if err != nil || len(tree.Children) != 2 || tree.Children[1].ID != b.ID || len(tree.Children[1].Children) != 1 || tree.Children[1].Children[0].ID != leaf.ID {
+			t.Errorf("Tree = %+v, %v", tree, err)
+		}
+		top := 0
+		if roots, _ := s.List(ctx, ListFilter{Parent: &top}); len(roots) != 1 || roots[0].ID != root.ID {
+			t.Errorf("List(top-level) = %v", roots)
+		}
+
+		s.(*taskStore).completion.AutoCompleteParents = false
+		s.Complete(ctx, leaf.ID, "bob", false, 0)
+		if got, _ = s.Get(ctx, b.ID); got.Completed || got.Progress.Done != 1 {
+			t.Errorf("parent with auto-completion off = %+v", got)
+		}
+	})
+
+	t.Run("Dependencies", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		design, _ := s.Add(ctx, ToDo{Text: "design"})
+		build, err := s.Add(ctx, ToDo{Text: "build", BlockedBy: []int{design.ID}})
+		if err != nil || !build.Blocked {
+			t.Fatalf("Add blocked item = %+v, %v", build, err)
+		}
+		ship, _ := s.Add(ctx, ToDo{Text: "ship", BlockedBy: []int{build.ID}})
+		if _, err := s.Add(ctx, ToDo{Text: "lost", BlockedBy: []int{99}}); !errors.Is(err, ErrBlockerNotFound) {
+			t.Errorf("Add blocked by a missing item error = %v, want ErrBlockerNotFound", err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: design.ID, Text: "design", BlockedBy: []int{ship.ID}}, 0); !errors.Is(err, ErrDependencyCycle) {
+			t.Errorf("transitive cycle error = %v, want ErrDependencyCycle", err)
+		}
+		if _, err := s.Update(ctx, ToDo{ID: design.ID, Text: "design", BlockedBy: []int{design.ID}}, 0); !errors.Is(err, ErrDependencyCycle) {
+			t.Errorf("self-dependency error = %v, want ErrDependencyCycle", err)
+		}
+
+		if _, err := s.Complete(ctx, build.ID, "alice", false, 0); !errors.Is(err, ErrBlocked) {
+			t.Errorf("Complete blocked item error = %v, want ErrBlocked", err)
+		}
+		if _, err := s.Replace(ctx, ToDo{ID: build.ID, Text: "build", Completed: true, BlockedBy: []int{design.ID}}, "alice", 0); !errors.Is(err, ErrBlocked) {
+			t.Errorf("Replace completing a blocked item error = %v, want ErrBlocked", err)
+		}
+		s.Complete(ctx, design.ID, "alice", false, 0)
+		if got, _ := s.Get(ctx, build.ID); got.Blocked || got.Version != build.Version+1 {
+			t.Errorf("item after its blocker completed = %+v", got)
+		}
+		s.Uncomplete(ctx, design.ID, 0)
+		yes := true
+		if blocked, _ := s.List(ctx, ListFilter{Blocked: &yes}); len(blocked) != 2 {
+			t.Errorf("List(blocked) = %v, want build and ship", blocked)
+		}
+		forced, err := s.Complete(ctx, build.ID, "alice", true, 0)
+		if err != nil || !forced.Completed {
+			t.Errorf("forced Complete = %+v, %v", forced, err)
+		}
+		if got, _ := s.Get(ctx, ship.ID); got.Blocked {
+			t.Errorf("ship still blocked after build was completed")
+		}
+
+		s.Uncomplete(ctx, build.ID, 0)
+		if err := s.Delete(ctx, build.ID, 0); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if got, _ := s.Get(ctx, ship.ID); got.Blocked || got.BlockedBy != nil {
+			t.Errorf("dependency on a deleted item kept: %+v", got)
+		}
+	})
+
+	t.Run("Recurrence", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		clock := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		s.(*taskStore).now = func() time.Time { return clock }
+
+		due := time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC)
+		first, _ := s.Add(ctx, ToDo{Text: "water plants", Due: &due, Tags: []string{"home"}, Recurrence: "FREQ=WEEKLY;COUNT=3"})
+		if first.Occurrence != 1 {
+			t.Errorf("first occurrence numbered %d, want 1", first.Occurrence)
+		}
+		done, err := s.Complete(ctx, first.ID, "alice", false, 0)
+		if err != nil || done.NextOccurrence == 0 {
+			t.Fatalf("Complete recurring item = %+v, %v", done, err)
+		}
+		second, _ := s.Get(ctx, done.NextOccurrence)
+		if second.Completed || second.Occurrence != 2 || second.Version != 1 || second.Text != first.Text ||
+			!slices.Equal(second.Tags, first.Tags) || second.Recurrence != first.Recurrence || !second.Due.Equal(due.AddDate(0, 0, 7)) {
+			t.Errorf("next occurrence = %+v", second)
+		}
+
+		// Reopening and completing again does not create another occurrence.
+		s.Uncomplete(ctx, first.ID, 0)
+		if again, _ := s.Complete(ctx, first.ID, "alice", false, 0); again.NextOccurrence != second.ID {
+			t.Errorf("recompleted item links %d, want %d", again.NextOccurrence, second.ID)
+		}
+
+		// Completing late skips the occurrences that have already passed.
+		clock = due.AddDate(0, 0, 15)
+		completed, _ := s.Complete(ctx, second.ID, "alice", false, 0)
+		if completed.NextOccurrence != 0 {
+			t.Errorf("series of 3 continued to occurrence %d", completed.NextOccurrence)
+		}
+		if all, _ := s.List(ctx, ListFilter{}); len(all) != 2 {
+			t.Errorf("List = %d items, want 2", len(all))
+		}
+
+		daily, _ := s.Add(ctx, ToDo{Text: "stand-up", Due: &due, Recurrence: "FREQ=DAILY"})
+		done, _ = s.Complete(ctx, daily.ID, "alice", false, 0)
+		next, _ := s.Get(ctx, done.NextOccurrence)
+		if next.Occurrence != 17 || !next.Due.Equal(due.AddDate(0, 0, 16)) || next.Overdue {
+			t.Errorf("late completion created %+v", next)
+		}
+		cleared, _ := s.Update(ctx, ToDo{ID: next.ID, Text: next.Text, Due: next.Due}, 0)
+		if cleared.Occurrence != 0 || cleared.Recurrence != "" {
+			t.Errorf("Update clearing the rule = %+v", cleared)
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		clock := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		s.(*taskStore).now = func() time.Time { return clock }
+
+		aliceCtx := withActor(ctx, "alice")
+		parent, _ := s.Add(aliceCtx, ToDo{Text: "release"})
+		child, _ := s.Add(aliceCtx, ToDo{Text: "draft notes", Parent: parent.ID})
+		clock = clock.Add(time.Hour)
+		traceID := oteltrace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+		traced := oteltrace.ContextWithSpanContext(withActor(ctx, "bob"), oteltrace.NewSpanContext(oteltrace.SpanContextConfig{TraceID: traceID}))
+		s.Update(traced, ToDo{ID: child.ID, Text: "write notes", Parent: parent.ID}, 0)
+		s.Complete(aliceCtx, child.ID, "alice", false, 0) // Completes the parent too
+
+		entries, err := s.History(ctx, child.ID)
+		if err != nil || len(entries) != 3 {
+			t.Fatalf("History = %+v, %v, want 3 entries", entries, err)
+		}
+		created, edited, completed := entries[0], entries[1], entries[2]
+		if created.Seq != 1 || created.Op != "add" || created.Actor != "alice" || created.Before != nil || created.After.Text != "draft notes" {
+			t.Errorf("creation entry = %+v", created)
+		}
+		if edited.Seq != 2 || edited.Actor != "bob" || !edited.At.Equal(clock) || edited.Before.Text != "draft notes" || edited.After.Text != "write notes" || edited.After.Version != 2 || edited.TraceID != traceID.String() {
+			t.Errorf("edit entry = %+v", edited)
+		}
+		if completed.Op != "complete" || !completed.After.Completed {
+			t.Errorf("completion entry = %+v", completed)
+		}
+		parentHistory, _ := s.History(ctx, parent.ID)
+		if last := parentHistory[len(parentHistory)-1]; last.Op != "complete" || last.Actor != "alice" || !last.After.Completed {
+			t.Errorf("cascaded completion entry = %+v", last)
+		}
+
+		restored, err := s.Restore(withActor(ctx, "carol"), child.ID, 1, 0)
+		if err != nil || restored.Text != "draft notes" || restored.Completed || restored.Version != 4 {
+			t.Errorf("Restore(version 1) = %+v, %v", restored, err)
+		}
+		if got, _ := s.Get(ctx, parent.ID); got.Completed {
+			t.Errorf("parent still completed after its subtask was restored to open")
+		}
+		if _, err := s.Restore(ctx, child.ID, 9, 0); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("Restore(unknown version) error = %v, want ErrRevisionNotFound", err)
+		}
+
+		s.Delete(ctx, child.ID, 0)
+		entries, err = s.History(ctx, child.ID)
+		if err != nil || len(entries) != 5 || entries[3].Actor != "carol" || entries[4].Op != "delete" || entries[4].After != nil {
+			t.Errorf("History after delete = %+v, %v", entries, err)
+		}
+		if _, err := s.History(ctx, 99); !errors.Is(err, ErrNotFound) {
+			t.Errorf("History(missing) error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		clock := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		s.(*taskStore).now = func() time.Time { return clock }
+
+		parent, _ := s.Add(ctx, ToDo{Text: "move house"})
+		child, _ := s.Add(ctx, ToDo{Text: "pack books", Parent: parent.ID})
+		other, _ := s.Add(ctx, ToDo{Text: "book van", BlockedBy: []int{child.ID}})
+		if err := s.Delete(ctx, child.ID, 0); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := s.Get(ctx, child.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(trashed) error = %v, want ErrNotFound", err)
+		}
+		trash, _ := s.Trash(ctx)
+		if len(trash) != 1 || trash[0].ID != child.ID || trash[0].DeletedAt == nil || !trash[0].DeletedAt.Equal(clock) || trash[0].Version != child.Version+1 {
+			t.Fatalf("Trash = %+v", trash)
+		}
+
+		clock = clock.Add(time.Hour)
+		s.Delete(ctx, parent.ID, 0)
+		if _, err := s.Untrash(ctx, child.ID); !errors.Is(err, ErrRestoreConflict) {
+			t.Errorf("Untrash with the parent in the trash error = %v, want ErrRestoreConflict", err)
+		}
+		if _, err := s.Untrash(ctx, parent.ID); err != nil {
+			t.Fatalf("Untrash(parent): %v", err)
+		}
+		restored, err := s.Untrash(ctx, child.ID)
+		if err != nil || restored.DeletedAt != nil || restored.Version != child.Version+2 || restored.Parent != parent.ID {
+			t.Errorf("Untrash = %+v, %v", restored, err)
+		}
+		if got, _ := s.Get(ctx, other.ID); got.BlockedBy != nil {
+			t.Errorf("dependency on a deleted item came back: %+v", got)
+		}
+		if _, err := s.Untrash(ctx, child.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Untrash(live item) error = %v, want ErrNotFound", err)
+		}
+
+		s.Delete(ctx, other.ID, 0) // Deleted at 13:00
+		clock = clock.Add(time.Hour)
+		s.Delete(ctx, child.ID, 0) // Deleted at 14:00
+		if n, err := s.PurgeTrash(ctx, clock.Add(-time.Minute)); err != nil || n != 1 {
+			t.Errorf("PurgeTrash = %d, %v, want 1", n, err)
+		}
+		if trash, _ := s.Trash(ctx); len(trash) != 1 || trash[0].ID != child.ID {
+			t.Errorf("Trash after purge = %+v", trash)
+		}
+		if _, err := s.Untrash(ctx, other.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Untrash(purged) error = %v, want ErrNotFound", err)
+		}
+		if next, _ := s.Add(ctx, ToDo{Text: "new"}); next.ID != 4 {
+			t.Errorf("ID after purge = %d, want 4", next.ID)
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		var commits [][]HistoryEntry
+		s.Watch(func(_ context.Context, changes []HistoryEntry) { commits = append(commits, changes) })
+
+		parent, _ := s.Add(ctx, ToDo{Text: "release"})
+		child, _ := s.Add(ctx, ToDo{Text: "notes", Parent: parent.ID})
+		s.Complete(ctx, child.ID, "alice", false, 0) // Completes the parent too
+		s.Complete(ctx, child.ID, "alice", false, 0) // No change, no commit
+		if _, err := s.Update(ctx, ToDo{ID: 99, Text: "missing"}, 0); err == nil {
+			t.Fatal("update of a missing item succeeded")
+		}
+		if len(commits) != 3 || len(commits[2]) != 2 || commits[2][0].TaskID != child.ID || commits[2][1].TaskID != parent.ID || !commits[2][1].After.Completed {
+			t.Errorf("watched commits = %+v", commits)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		var commits [][]HistoryEntry
+		s.Watch(func(_ context.Context, changes []HistoryEntry) { commits = append(commits, changes) })
+		kept, _ := s.Add(ctx, ToDo{Text: "keep"})
+
+		err := s.Batch(ctx, func(ctx context.Context) error {
+			added, err := s.Add(ctx, ToDo{Text: "first"})
+			if err != nil {
+				return err
+			}
+			if got, err := s.Get(ctx, added.ID); err != nil || got.Text != "first" {
+				t.Errorf("Get inside the batch = %+v, %v", got, err)
+			}
+			if _, err := s.Update(ctx, ToDo{ID: added.ID, Text: "first, edited"}, 1); err != nil {
+				return err
+			}
+			return s.Delete(ctx, kept.ID, 0)
+		})
+		if err != nil {
+			t.Fatalf("Batch: %v", err)
+		}
+		if len(commits) != 4 || commits[1][0].Op != "add" || commits[2][0].Op != "update" || commits[3][0].Op != "delete" {
+			t.Errorf("watched commits = %+v", commits)
+		}
+		history, _ := s.History(ctx, kept.ID+1)
+		if len(history) != 2 || history[1].Seq != 2 || history[1].After.Text != "first, edited" {
+			t.Errorf("history of the batch's item = %+v", history)
+		}
+
+		// A failed change rolls back the whole batch, even if fn ignores it
+		err = s.Batch(ctx, func(ctx context.Context) error {
+			s.Add(ctx, ToDo{Text: "discarded"})
+			s.Complete(ctx, 99, "alice", false, 0)
+			return nil
+		})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Batch with a failed change = %v, want ErrNotFound", err)
+		}
+		if todos, _ := s.List(ctx, ListFilter{}); len(todos) != 1 || todos[0].Text != "first, edited" {
+			t.Errorf("items after rollback = %+v", todos)
+		}
+		if len(commits) != 4 {
+			t.Errorf("rolled back batch reached watchers: %+v", commits[4:])
+		}
+		if added, _ := s.Add(ctx, ToDo{Text: "after"}); added.ID != kept.ID+2 {
+			t.Errorf("ID after rollback = %d, want %d", added.ID, kept.ID+2)
+		}
+	})
+
+	t.Run("DueDatesAndOverdue", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		clock := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		s.(*taskStore).now = func() time.Time { return clock }
+
+		berlin, _ := time.LoadLocation("Europe/Berlin")
+		soon := time.Date(2026, 10, 1, 15, 0, 0, 0, berlin) // 13:00 UTC
+		later := clock.Add(48 * time.Hour)
+		past := clock.Add(-time.Hour)
+		a, _ := s.Add(ctx, ToDo{Text: "soon", Due: &soon, DueZone: "Europe/Berlin"})
+		b, _ := s.Add(ctx, ToDo{Text: "later", Due: &later})
+		c, _ := s.Add(ctx, ToDo{Text: "late already", Due: &past})
+		s.Add(ctx, ToDo{Text: "no due date"})
+		if a.Overdue || b.Overdue || !c.Overdue {
+			t.Fatalf("overdue on add = %v, %v, %v, want false, false, true", a.Overdue, b.Overdue, c.Overdue)
+		}
+		got, _ := s.Get(ctx, a.ID)
+		if got.Due == nil || !got.Due.Equal(soon) || got.DueZone != "Europe/Berlin" {
+			t.Errorf("due round trip = %v (%q), want %v", got.Due, got.DueZone, soon)
+		}
+
+		cutoff := clock.Add(24 * time.Hour)
+		due, _ := s.List(ctx, ListFilter{DueBefore: &cutoff})
+		if len(due) != 2 || due[0].ID != a.ID || due[1].ID != c.ID {
+			t.Errorf("List(due before %v) = %v", cutoff, due)
+		}
+		after, _ := s.List(ctx, ListFilter{DueAfter: &cutoff})
+		if len(after) != 1 || after[0].ID != b.ID {
+			t.Errorf("List(due after %v) = %v", cutoff, after)
+		}
+
+		clock = clock.Add(2 * time.Hour)
+		flagged, err := s.FlagOverdue(ctx)
+		if err != nil || len(flagged) != 1 || flagged[0].ID != a.ID || flagged[0].Version != a.Version+1 {
+			t.Fatalf("FlagOverdue = %v, %v, want item %d", flagged, err, a.ID)
+		}
+		if again, _ := s.FlagOverdue(ctx); len(again) != 0 {
+			t.Errorf("second FlagOverdue = %v, want nothing", again)
+		}
+		yes := true
+		overdue, _ := s.List(ctx, ListFilter{Overdue: &yes})
+		if len(overdue) != 2 {
+			t.Errorf("List(overdue) = %v, want 2 items", overdue)
+		}
+		done, _ := s.Complete(ctx, c.ID, "alice", false, 0)
+		moved, _ := s.Update(ctx, ToDo{ID: a.ID, Text: "soon", Due: &later}, 0)
+		if done.Overdue || moved.Overdue {
+			t.Errorf("overdue after complete/reschedule = %v, %v, want false", done.Overdue, moved.Overdue)
+		}
+	})
+}
+
+func TestFileStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "todos.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	project, _ := s.AddProject(ctx, Project{Name: "errands"})
+	a, _ := s.Add(ctx, ToDo{Text: "keep", Project: project.ID})
+	b, _ := s.Add(ctx, ToDo{Text: "drop"})
+	s.Complete(ctx, a.ID, "alice", false, 0)
+	s.Delete(ctx, b.ID, 0)
+	s.Close()
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if summary, err := reopened.GetProject(ctx, project.ID); err != nil || summary.Name != "errands" || summary.Counts.Total != 1 {
+		t.Errorf("GetProject after reopen = %+v, %v", summary, err)
+	}
+	if next, _ := reopened.AddProject(ctx, Project{Name: "chores"}); next.ID != 2 {
+		t.Errorf("project ID counter not restored: got %d want 2", next.ID)
+	}
+	got, err := reopened.Get(ctx, a.ID)
+	if err != nil || !got.Completed || got.Text != "keep" {
+		t.Errorf("Get after reopen = %+v, %v", got, err)
+	}
+	if _, err := reopened.Get(ctx, b.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted item survived reopen: %v", err)
+	}
+	next, _ := reopened.Add(ctx, ToDo{Text: "new"})
+	if next.ID != 3 {
+		t.Errorf("ID counter not restored: got %d want 3", next.ID)
+	}
+	if entries, _ := reopened.History(ctx, a.ID); len(entries) != 2 || entries[1].Op != "complete" {
+		t.Errorf("History after reopen = %+v", entries)
+	}
+	if trash, _ := reopened.Trash(ctx); len(trash) != 1 || trash[0].ID != b.ID {
+		t.Errorf("Trash after reopen = %+v", trash)
+	}
+}
+
+func TestWALStore_ReplayAndTornTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "todos.json")
+	opts := walOptions{} // No compaction: everything stays in the log
+	s, err := NewWALStore(path, opts)
+	if err != nil {
+		t.Fatalf("NewWALStore: %v", err)
+	}
+	project, _ := s.AddProject(ctx, Project{Name: "errands"})
+	a, _ := s.Add(ctx, ToDo{Text: "first", Project: project.ID})
+	b, _ := s.Add(ctx, ToDo{Text: "second"})
+	s.Complete(ctx, a.ID, "alice", false, 0)
+	s.Delete(ctx, b.ID, 0)
+	// Simulate a crash: drop the store without Close so nothing is compacted.
+	s.(*taskStore).backend.(*walBackend).log.Close()
+
+	// Append half of a record to mimic a write torn by the crash.
+	f, err := os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2, 3})
+	f.Close()
+	before, _ := os.Stat(path + ".wal")
+
+	s, err = NewWALStore(path, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	after, _ := os.Stat(path + ".wal")
+	if after.Size() != before.Size()-7 {
+		t.Errorf("torn tail not truncated: size %d, want %d", after.Size(), before.Size()-7)
+	}
+	got, err := s.Get(ctx, a.ID)
+	if err != nil || !got.Completed {
+		t.Errorf("Get after replay = %+v, %v", got, err)
+	}
+	if _, err := s.Get(ctx, b.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted item replayed: %v", err)
+	}
+	if summary, err := s.GetProject(ctx, project.ID); err != nil || summary.Counts.Completed != 1 {
+		t.Errorf("GetProject after replay = %+v, %v", summary, err)
+	}
+	if next, _ := s.Add(ctx, ToDo{Text: "third"}); next.ID != 3 {
+		t.Errorf("ID counter not replayed: got %d want 3", next.ID)
+	}
+	if entries, _ := s.History(ctx, b.ID); len(entries) != 2 || entries[1].Op != "delete" {
+		t.Errorf("History after replay = %+v", entries)
+	}
+	if trash, _ := s.Trash(ctx); len(trash) != 1 || trash[0].ID != b.ID {
+		t.Errorf("Trash after replay = %+v", trash)
+	}
+
+	// A garbled header claiming a huge record is torn too, not allocated.
+	s.(*taskStore).backend.(*walBackend).log.Close()
+	before, _ = os.Stat(path + ".wal")
+	f, err = os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	f.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, '{'})
+	f.Close()
+	s, err = NewWALStore(path, opts)
+	if err != nil {
+		t.Fatalf("reopen after oversized header: %v", err)
+	}
+	defer s.Close()
+	if after, _ := os.Stat(path + ".wal"); after.Size() != before.Size() {
+		t.Errorf("oversized record not truncated: size %d, want %d", after.Size(), before.Size())
+	}
+	if got, err := s.Get(ctx, 3); err != nil || got.Text != "third" {
+		t.Errorf("Get after oversized header = %+v, %v", got, err)
+	}
+}
+
+func TestWALStore_Compaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "todos.json")
+	s, err := NewWALStore(path, walOptions{CompactBytes: 256})
+	if err != nil {
+		t.Fatalf("NewWALStore: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		s.Add(ctx, ToDo{Text: "compact me"})
+	}
+	info, _ := os.Stat(path + ".wal")
+	if info.Size() >= 256 {
+		t.Errorf("log not compacted: %d bytes", info.Size())
+	}
+	snap, err := readSnapshot(path)
+	if err != nil || snap.Seq == 0 || len(snap.Todos) == 0 {
+		t.Fatalf("snapshot = %+v, %v", snap, err)
+	}
+	s.Close()
+
+	reopened, err := NewWALStore(path, walOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	todos, _ := reopened.List(ctx, ListFilter{})
+	if len(todos) != 20 {
+		t.Errorf("got %d items after reopen, want 20", len(todos))
+	}
+}
+
+func TestSQLiteStore_ReopenKeepsSchemaAndData(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "todos.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	a, _ := s.Add(ctx, ToDo{Text: "persisted"})
+	s.Complete(ctx, a.ID, "alice", false, 0)
+	s.Close()
+
+	reopened, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	var version int
+	reopened.(*taskStore).backend.(*sqliteBackend).db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != len(sqliteMigrations) {
+		t.Errorf("user_version = %d, want %d", version, len(sqliteMigrations))
+	}
+	done, _ := parseStatusFilter("done")
+	todos, err := reopened.List(ctx, done)
+	if err != nil || len(todos) != 1 || todos[0].CompletedBy != "alice" {
+		t.Errorf("List(done) after reopen = %+v, %v", todos, err)
+	}
+	if entries, _ := reopened.History(ctx, a.ID); len(entries) != 2 || entries[1].Seq != 2 {
+		t.Errorf("History after reopen = %+v", entries)
+	}
+}
+
+func TestLoadCompletionRules(t *testing.T) {
+	env := map[string]string{"TODO_AUTO_COMPLETE_PARENTS": "false"}
+	rules, err := loadCompletionRules(func(k string) string { return env[k] })
+	if err != nil || rules.AutoCompleteParents {
+		t.Errorf("loadCompletionRules = %+v, %v", rules, err)
+	}
+	env["TODO_AUTO_COMPLETE_PARENTS"] = "sometimes"
+	if _, err := loadCompletionRules(func(k string) string { return env[k] }); err == nil {
+		t.Error("expected an error for an invalid TODO_AUTO_COMPLETE_PARENTS")
+	}
+}
+
+func TestTopoSort(t *testing.T) {
+	todos := []ToDo{
+		{ID: 1, Priority: "P3"},
+		{ID: 2, Priority: "P0", BlockedBy: []int{3}},
+		{ID: 3, Priority: "P2", BlockedBy: []int{9}}, // 9 is outside the set
+		{ID: 4, Priority: "P1"},
+	}
+	order, _ := parseSortOrder("priority")
+	var ids []int
+	for _, todo := range topoSort(todos, order.compare) {
+		ids = append(ids, todo.ID)
+	}
+	if want := []int{4, 3, 2, 1}; !slices.Equal(ids, want) {
+		t.Errorf("topoSort = %v, want %v", ids, want)
+	}
+}
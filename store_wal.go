@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// walHeaderSize is the framing in front of every log record:
+// a 4-byte payload length followed by a 4-byte CRC-32C of the payload.
+const walHeaderSize = 8
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walOptions controls when the log is compacted into a snapshot.
+type walOptions struct {
+	CompactBytes    int64         // Compact once the log grows past this size
+	CompactInterval time.Duration // Compact a non-empty log at least this often; 0 disables
+}
+
+func defaultWALOptions() walOptions {
+	return walOptions{
+		CompactBytes:    4 << 20,
+		CompactInterval: 5 * time.Minute,
+	}
+}
+
+// walRecord is one committed transaction in the log.
+type walRecord struct {
+	idCounters
+	Seq     uint64        `json:"seq"`
+	Op      string        `json:"op"`
+	Changes []storeChange `json:"changes"`
+}
+
+// walBackend serves reads from memory and makes every transaction durable by
+// appending it to an fsync'd log before applying it. The log is periodically
+// folded into a snapshot at path (same format as the file backend) and reset.
+type walBackend struct {
+	*memoryBackend
+	snapshotPath string
+	logPath      string
+	opts         walOptions
+
+	log  *os.File
+	size int64
+	seq  uint64
+
+	compactions  metric.Int64Counter
+	registration metric.Registration
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewWALStore opens (or creates) a write-ahead-logged store. The snapshot
+// lives at path and the log at path+".wal"; both are replayed on startup.
+func NewWALStore(path string, opts walOptions) (Store, error) {
+	b := &walBackend{
+		memoryBackend: newMemoryBackend(),
+		snapshotPath:  path,
+		logPath:       path + ".wal",
+		opts:          opts,
+		stop:          make(chan struct{}),
+	}
+	if err := b.recover(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := b.initMetrics(); err != nil {
+		b.log.Close()
+		return nil, err
+	}
+	b.persist = b.append
+	if opts.CompactInterval > 0 {
+		b.wg.Add(1)
+		go b.compactLoop()
+	}
+	return newTaskStore(b), nil
+}
+
+// recover rebuilds data and count from the snapshot and the log, truncating
+// a torn or corrupt tail left behind by a crash mid-append.
+func (b *walBackend) recover(ctx context.Context) error {
+	start := time.Now()
+	ctx, span := otel.Tracer("todo-service").Start(ctx, "wal.replay")
+	defer span.End()
+
+	snap, err := readSnapshot(b.snapshotPath)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "snapshot load failed")
+		return err
+	}
+	b.load(snap)
+	b.seq = snap.Seq
+
+	b.log, err = os.OpenFile(b.logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "log open failed")
+		return fmt.Errorf("open wal %s: %w", b.logPath, err)
+	}
+
+	info, err := b.log.Stat()
+	if err != nil {
+		b.log.Close()
+		return err
+	}
+	replayed, skipped, good, tornErr := b.replay(b.log, info.Size())
+	if tornErr != nil {
+		log.Warn().Err(tornErr).Str("wal", b.logPath).Int64("offset", good).Int64("size", info.Size()).
+			Msg("Truncating torn tail of write-ahead log")
+		span.AddEvent("wal.truncated", oteltrace.WithAttributes(attribute.Int64("wal.offset", good), attribute.Int64("wal.discarded_bytes", info.Size()-good)))
+		if err := b.log.Truncate(good); err != nil {
+			b.log.Close()
+			return fmt.Errorf("truncate wal %s: %w", b.logPath, err)
+		}
+		if err := b.log.Sync(); err != nil {
+			b.log.Close()
+			return err
+		}
+	}
+	b.size = good
+
+	duration := time.Since(start)
+	span.SetAttributes(
+		attribute.Int("wal.records_replayed", replayed),
+		attribute.Int("wal.records_skipped", skipped),
+		attribute.Int64("wal.size_bytes", b.size),
+		attribute.Int("store.items", len(b.data)),
+	)
+	if replayDuration, err := otel.Meter("todo-service").Float64Histogram(
+		"todo_wal_replay_duration_milliseconds",
+		metric.WithDescription("Time spent replaying the write-ahead log on startup"),
+		metric.WithUnit("ms"),
+	); err == nil {
+		replayDuration.Record(ctx, float64(duration.Milliseconds()))
+	}
+	log.Info().Str("wal", b.logPath).Int("records", replayed).Int("items", len(b.data)).
+		Dur("duration", duration).Msg("Write-ahead log replayed")
+	return nil
+}
+
+// replay applies every intact record after the snapshot. It returns the
+// offset just past the last good record and, if the log ends in a partial
+// or corrupt record, the reason it stopped. size is the length of the log,
+// which bounds the record lengths it trusts.
+func (b *walBackend) replay(r io.Reader, size int64) (replayed, skipped int, good int64, tornErr error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return replayed, skipped, good, nil
+			}
+			return replayed, skipped, good, fmt.Errorf("partial record header: %w", err)
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		sum := binary.BigEndian.Uint32(header[4:])
+		if rest := size - good - walHeaderSize; int64(length) > rest {
+			// A torn or garbled header; checked before allocating the payload
+			return replayed, skipped, good, fmt.Errorf("partial record payload: length %d exceeds the %d bytes left", length, rest)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return replayed, skipped, good, fmt.Errorf("partial record payload: %w", err)
+		}
+		if crc32.Checksum(payload, walCRCTable) != sum {
+			return replayed, skipped, good, errors.New("record checksum mismatch")
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return replayed, skipped, good, fmt.Errorf("decode record: %w", err)
+		}
+		good += int64(walHeaderSize) + int64(length)
+		if rec.Seq <= b.seq {
+			// Already folded into the snapshot; compaction crashed before resetting the log.
+			skipped++
+			continue
+		}
+		b.apply(rec.Changes, rec.idCounters)
+		b.seq = rec.Seq
+		replayed++
+	}
+}
+
+// append writes a transaction to the log and fsyncs it. It runs as the
+// memory backend's persist hook, so the backend lock is held.
+func (b *walBackend) append(op string, changes []storeChange, ids idCounters) error {
+	rec := walRecord{idCounters: ids, Seq: b.seq + 1, Op: op, Changes: changes}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, walCRCTable))
+	copy(frame[walHeaderSize:], payload)
+
+	if _, err := b.log.Write(frame); err != nil {
+		// Drop whatever part of the frame reached the file so the log stays well-formed.
+		b.log.Truncate(b.size)
+		return fmt.Errorf("append wal: %w", err)
+	}
+	if err := b.log.Sync(); err != nil {
+		b.log.Truncate(b.size)
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	b.seq = rec.Seq
+	b.size += int64(len(frame))
+
+	if b.opts.CompactBytes > 0 && b.size >= b.opts.CompactBytes {
+		if err := b.compact(op, pendingSnapshot(b.memoryBackend, changes, ids)); err != nil {
+			// The record is durable in the log; compaction is retried later.
+			log.Error().Err(err).Str("wal", b.logPath).Msg("Write-ahead log compaction failed")
+		}
+	}
+	return nil
+}
+
+// compact writes snap (which must include every logged record) and resets
+// the log. The backend lock must be held.
+func (b *walBackend) compact(trigger string, snap fileSnapshot) error {
+	ctx, span := otel.Tracer("todo-service").Start(context.Background(), "wal.compact")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("wal.trigger", trigger),
+		attribute.Int64("wal.size_bytes", b.size),
+		attribute.Int("store.items", len(snap.Todos)),
+	)
+
+	snap.Seq = b.seq
+	if err := writeSnapshot(b.snapshotPath, snap); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "snapshot write failed")
+		return err
+	}
+	if err := b.log.Truncate(0); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "log truncate failed")
+		return err
+	}
+	if err := b.log.Sync(); err != nil {
+		return err
+	}
+	b.size = 0
+	b.compactions.Add(ctx, 1, metric.WithAttributes(attribute.String("trigger", trigger)))
+	log.Info().Str("wal", b.logPath).Uint64("seq", b.seq).Int("items", len(snap.Todos)).Msg("Write-ahead log compacted")
+	return nil
+}
+
+// compactLoop compacts a non-empty log on a fixed interval.
+func (b *walBackend) compactLoop() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.opts.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			if b.size > 0 {
+				if err := b.compact("interval", pendingSnapshot(b.memoryBackend, nil, b.ids)); err != nil {
+					log.Error().Err(err).Str("wal", b.logPath).Msg("Write-ahead log compaction failed")
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+func (b *walBackend) initMetrics() error {
+	m := otel.Meter("todo-service")
+	var err error
+	b.compactions, err = m.Int64Counter(
+		"todo_wal_compactions_total",
+		metric.WithDescription("Total number of write-ahead log compactions"),
+		metric.WithUnit("{compactions}"),
+	)
+	if err != nil {
+		return err
+	}
+	logSize, err := m.Int64ObservableGauge(
+		"todo_wal_size_bytes",
+		metric.WithDescription("Current size of the write-ahead log"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return err
+	}
+	b.registration, err = m.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		o.ObserveInt64(logSize, b.size)
+		return nil
+	}, logSize)
+	return err
+}
+
+// close stops background compaction, folds the log into a final snapshot and closes it.
+func (b *walBackend) close() error {
+	close(b.stop)
+	b.wg.Wait()
+	if b.registration != nil {
+		b.registration.Unregister()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	if b.size > 0 {
+		err = b.compact("close", pendingSnapshot(b.memoryBackend, nil, b.ids))
+	}
+	return errors.Join(err, b.log.Close())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeTag puts a tag in canonical form: NFC, trimmed and lower-case.
+// Tags may contain spaces but no commas (the filter separator) or control
+// characters, and must fit the "tags" length constraint.
+func normalizeTag(path, tag string) (string, *fieldError) {
+	tag = strings.ToLower(strings.TrimSpace(norm.NFC.String(tag)))
+	if strings.IndexFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) >= 0 {
+		return "", &fieldError{Path: path, Message: "must not contain commas or control characters", Code: "invalid_tag"}
+	}
+	c := validation.Fields["tags"]
+	n := utf8.RuneCountInString(tag)
+	switch {
+	case n == 0:
+		return "", &fieldError{Path: path, Message: "must not be empty", Code: "empty"}
+	case n < c.MinLength:
+		return "", &fieldError{Path: path, Message: fmt.Sprintf("must be at least %d characters", c.MinLength), Code: "too_short"}
+	case c.MaxLength > 0 && n > c.MaxLength:
+		return "", &fieldError{Path: path, Message: fmt.Sprintf("must be at most %d characters", c.MaxLength), Code: "too_long"}
+	}
+	return tag, nil
+}
+
+// decodeTags reads the tags member of a task document: an array of strings
+// or null. The result is normalised, sorted and de-duplicated.
+func decodeTags(raw json.RawMessage, todo *ToDo) *fieldError {
+	var values []string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return &fieldError{Message: "must be an array of strings or null", Code: "invalid_type"}
+	}
+	tags := make([]string, 0, len(values))
+	for i, value := range values {
+		tag, ferr := normalizeTag("/tags/"+strconv.Itoa(i), value)
+		if ferr != nil {
+			return ferr
+		}
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+	if limit := validation.Fields["tags"].MaxItems; limit > 0 && len(tags) > limit {
+		return &fieldError{Message: fmt.Sprintf("must have at most %d tags", limit), Code: "too_many"}
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	todo.Tags = tags
+	return nil
+}
+
+// parseTagFilter reads a comma-separated tag list query parameter.
+func parseTagFilter(query url.Values, name string) ([]string, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	var tags []string
+	for _, part := range strings.Split(value, ",") {
+		tag, ferr := normalizeTag(name, part)
+		if ferr != nil {
+			return nil, fmt.Errorf("%s: tag %q %s", name, part, ferr.Message)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// maxTagLabels caps how many distinct tags become values of the tag metric
+// attribute, since tags are free-form; the rest are recorded as "_other".
+const maxTagLabels = 50
+
+// labelLimiter hands out metric attribute values, passing the first limit
+// distinct values through and folding later ones into "_other".
+type labelLimiter struct {
+	mu    sync.Mutex
+	limit int
+	seen  map[string]struct{}
+}
+
+func newLabelLimiter(limit int) *labelLimiter {
+	return &labelLimiter{limit: limit, seen: map[string]struct{}{}}
+}
+
+func (l *labelLimiter) label(value string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[value]; ok {
+		return value
+	}
+	if len(l.seen) >= l.limit {
+		return "_other"
+	}
+	l.seen[value] = struct{}{}
+	return value
+}
+
+var tagLabels = newLabelLimiter(maxTagLabels)
+
+// countTagOperation records an add or remove of tags in todo_tag_operations_total.
+func countTagOperation(ctx context.Context, operation string, tags ...string) {
+	for _, tag := range tags {
+		tagCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("operation", operation),
+			attribute.String("tag", tagLabels.label(tag)),
+		))
+	}
+}
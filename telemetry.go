@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"slices"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/metric"
@@ -92,6 +95,24 @@ func initMetrics() {
 		log.Fatal().Err(err).Msg("Failed to create error counter metric")
 	}
 
+	deprecatedCounter, err = meter.Int64Counter(
+		"todo_deprecated_route_requests_total",
+		metric.WithDescription("Total number of requests served through deprecated verb-style routes"),
+		metric.WithUnit("{requests}"),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create deprecated route counter metric")
+	}
+
+	tagCounter, err = meter.Int64Counter(
+		"todo_tag_operations_total",
+		metric.WithDescription("Total number of tags added to or removed from tasks, by tag (capped)"),
+		metric.WithUnit("{operations}"),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create tag counter metric")
+	}
+
 	// Expose metrics via HTTP endpoint
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -116,15 +137,86 @@ func logWithTrace(ctx context.Context) *zerolog.Event {
 	return event
 }
 
-// handleError logs an error with trace context and writes an HTTP error response.
-func handleError(ctx context.Context, w http.ResponseWriter, statusCode int, message string, err error) {
-	logEntry := logWithTrace(ctx).Int("status_code", statusCode)
+// handleError logs an error with trace context, counts it against the handler
+// and writes an RFC 7807 problem response from the error catalogue.
+func handleError(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string, problem problemType, err error) {
+	handleFieldErrors(ctx, w, r, handler, problem, err, nil)
+}
+
+// handleFieldErrors is handleError for failures tied to fields of a document,
+// listed in the problem's errors member by JSON Pointer.
+func handleFieldErrors(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string, problem problemType, err error, fieldErrs []fieldError) {
+	writeProblemContext(ctx, w, r, problem, recordProblem(ctx, handler, problem, err, fieldErrs), fieldErrs)
+}
+
+// recordProblem logs and counts a failed request and returns the detail to
+// report to the client.
+func recordProblem(ctx context.Context, handler string, problem problemType, err error, fieldErrs []fieldError) string {
+	logEntry := logWithTrace(ctx).Int("status_code", problem.status).Str("problem", problem.slug)
 	if err != nil {
 		logEntry = logEntry.Err(err) // Log the actual error if provided
-	} else {
-		logEntry = logEntry.Str("error", message) // Log the message as error string if no error object
 	}
-	logEntry.Msg(message) // Use the user-facing message as the log message
+	if len(fieldErrs) > 0 {
+		logEntry = logEntry.Interface("field_errors", fieldErrs)
+	}
+	logEntry.Msg(problem.title) // Use the catalogue title as the log message
 
-	http.Error(w, message, statusCode)
+	// Validation failures are counted once per distinct field error code
+	reasons := []string{}
+	for _, fe := range fieldErrs {
+		if fe.Code != "" && !slices.Contains(reasons, fe.Code) {
+			reasons = append(reasons, fe.Code)
+		}
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, problem.slug)
+	}
+	for _, reason := range reasons {
+		errorCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("handler", handler),
+			attribute.String("reason", reason),
+		))
+	}
+
+	// Internal errors are described by the catalogue only; their causes stay in the logs
+	if err != nil && problem.status < http.StatusInternalServerError {
+		return err.Error()
+	}
+	return ""
+}
+
+// writeProblem writes a problem response without logging or counting it,
+// for responses produced outside the instrumented handlers.
+func writeProblem(w http.ResponseWriter, r *http.Request, problem problemType, detail string, fieldErrs []fieldError) {
+	writeProblemContext(r.Context(), w, r, problem, detail, fieldErrs)
+}
+
+func writeProblemContext(ctx context.Context, w http.ResponseWriter, r *http.Request, problem problemType, detail string, fieldErrs []fieldError) {
+	writeProblemBody(w, problem.status, newProblemDetails(ctx, problem, detail, r.URL.RequestURI(), fieldErrs))
+}
+
+// writeProblemBody writes a problem body, which may extend problemDetails
+// with members of its own.
+func writeProblemBody(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newProblemDetails builds the problem body for a failure, carrying the
+// trace ID of ctx so it can be looked up in Jaeger.
+func newProblemDetails(ctx context.Context, problem problemType, detail, instance string, fieldErrs []fieldError) problemDetails {
+	body := problemDetails{
+		Type:     problem.URI(),
+		Title:    problem.title,
+		Status:   problem.status,
+		Detail:   detail,
+		Instance: instance,
+		Errors:   fieldErrs,
+	}
+	if spanCtx := oteltrace.SpanContextFromContext(ctx); spanCtx.HasTraceID() {
+		body.TraceID = spanCtx.TraceID().String()
+	}
+	return body
 }
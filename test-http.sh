@@ -2,7 +2,7 @@
 
 # Validate and list all ToDos
 echo "Listing all ToDos:"
-response=$(curl -s http://localhost:8080/list)
+response=$(curl -s http://localhost:8080/todos)
 if ! echo "$response" | jq empty 2>/dev/null; then
   echo "Error: Invalid JSON response from API"
   exit 1
@@ -12,17 +12,17 @@ echo ""
 
 # Add new ToDos
 echo "Adding new ToDos:"
-curl -s -X POST localhost:8080/add -d '{"text":"Write code"}' -H "Content-Type: application/json" | jq
-curl -s -X POST localhost:8080/add -d '{"text":"Test code"}' -H "Content-Type: application/json" | jq
-curl -s -X POST localhost:8080/add -d '{"text":"Run code"}' -H "Content-Type: application/json" | jq
-curl -s -X POST localhost:8080/add -d '{"text":"Ship code"}' -H "Content-Type: application/json" | jq
-curl -s -X POST localhost:8080/add -d '{"text":"Shit histograms"}' -H "Content-Type: application/json" | jq
-curl -s -X POST localhost:8080/add -d '{"text":"Build something"}' -H "Content-Type: application/json" | jq
+curl -s -X POST localhost:8080/todos -d '{"text":"Write code"}' -H "Content-Type: application/json" | jq
+curl -s -X POST localhost:8080/todos -d '{"text":"Test code"}' -H "Content-Type: application/json" | jq
+curl -s -X POST localhost:8080/todos -d '{"text":"Run code"}' -H "Content-Type: application/json" | jq
+curl -s -X POST localhost:8080/todos -d '{"text":"Ship code"}' -H "Content-Type: application/json" | jq
+curl -s -X POST localhost:8080/todos -d '{"text":"Shit histograms"}' -H "Content-Type: application/json" | jq
+curl -s -X POST localhost:8080/todos -d '{"text":"Build something"}' -H "Content-Type: application/json" | jq
 echo ""
 
 # List all ToDos again
 echo "Listing all ToDos after adding:"
-response=$(curl -s http://localhost:8080/list)
+response=$(curl -s http://localhost:8080/todos)
 if ! echo "$response" | jq empty 2>/dev/null; then
   echo "Error: Invalid JSON response from API"
   exit 1
@@ -37,7 +37,7 @@ echo "$response" | jq -c '.[]' | while read -r todo; do
   text=$(echo "$todo" | jq -r '.text')
   if [[ $text == *"Shit"* ]]; then
     echo "Deleting ToDo with ID $id and text '$text'"
-    curl -s -X DELETE "http://localhost:8080/delete?id=$id" | jq
+    curl -s -X DELETE "http://localhost:8080/todos/$id" | jq
   fi
 done
 echo ""
@@ -49,10 +49,10 @@ echo "$response" | jq -c '.[]' | while read -r todo; do
   text=$(echo "$todo" | jq -r '.text')
   if [[ $text == *"Write"* ]]; then
     echo "Updating ToDo with ID $id and text '$text' to mark as complete"
-    curl -s -X PUT "http://localhost:8080/update?id=$id" \
+    curl -s -X PUT "http://localhost:8080/todos/$id" \
       -d "{\"text\":\"$text\"}" \
       -H "Content-Type: application/json" | jq
-    curl -s -X POST "http://localhost:8080/complete?id=$id" | jq
+    curl -s -X POST "http://localhost:8080/todos/$id/complete" | jq
   fi
 done
 echo ""
@@ -65,7 +65,7 @@ echo "$response" | jq -c '.[]' | while read -r todo; do
   if [[ $text == *"Build"* ]]; then
     new_text="Build and rebuild"
     echo "Updating ToDo with ID $id and text '$text' to '$new_text'"
-    curl -s -X PUT "http://localhost:8080/update?id=$id" \
+    curl -s -X PUT "http://localhost:8080/todos/$id" \
       -d "{\"text\":\"$new_text\"}" \
       -H "Content-Type: application/json" | jq
   fi
@@ -74,7 +74,7 @@ echo ""
 
 # List all ToDos after updates
 echo "Listing all ToDos after updates:"
-response=$(curl -s http://localhost:8080/list)
+response=$(curl -s http://localhost:8080/todos)
 if ! echo "$response" | jq empty 2>/dev/null; then
   echo "Error: Invalid JSON response from API"
   exit 1
@@ -85,7 +85,7 @@ echo ""
 # Test the "search" endpoint
 echo "Testing the 'search' endpoint:"
 search_query="Write"
-response=$(curl -s "http://localhost:8080/search?q=$search_query")
+response=$(curl -s "http://localhost:8080/todos/search?q=$search_query")
 if ! echo "$response" | jq empty 2>/dev/null; then
   echo "Error: Invalid JSON response from 'search' endpoint"
   exit 1
@@ -97,7 +97,7 @@ echo ""
 # Test the "get" endpoint
 echo "Testing the 'get' endpoint:"
 todo_id=1
-response=$(curl -s "http://localhost:8080/get?id=$todo_id")
+response=$(curl -s "http://localhost:8080/todos/$todo_id")
 if ! echo "$response" | jq empty 2>/dev/null; then
   echo "Error: Invalid JSON response from 'get' endpoint"
   exit 1
@@ -108,7 +108,7 @@ echo ""
 
 # Test invalid ID for "get" endpoint
 echo "Testing invalid ID for 'get' endpoint:"
-response=$(curl -s -o /dev/null -w "%{http_code}" "http://localhost:8080/get?id=invalid")
+response=$(curl -s -o /dev/null -w "%{http_code}" "http://localhost:8080/todos/invalid")
 if [ "$response" -ne 400 ]; then
   echo "Test failed: Expected 400, got $response"
 else
@@ -117,9 +117,18 @@ fi
 
 # Test non-existent ID for "get" endpoint
 echo "Testing non-existent ID for 'get' endpoint:"
-response=$(curl -s -o /dev/null -w "%{http_code}" "http://localhost:8080/get?id=999")
+response=$(curl -s -o /dev/null -w "%{http_code}" "http://localhost:8080/todos/999")
 if [ "$response" -ne 404 ]; then
   echo "Test failed: Expected 404, got $response"
 else
   echo "Test passed: Non-existent ID for 'get' endpoint"
-fi
\ No newline at end of file
+fi
+
+# Test method enforcement on the resource API
+echo "Testing unsupported method on '/todos/{id}':"
+response=$(curl -s -o /dev/null -w "%{http_code}" -X POST "http://localhost:8080/todos/1")
+if [ "$response" -ne 405 ]; then
+  echo "Test failed: Expected 405, got $response"
+else
+  echo "Test passed: POST on '/todos/{id}' is not allowed"
+fi
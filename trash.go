@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRestoreConflict is returned when restoring an item from the trash
+// whose project or parent no longer exists.
+var ErrRestoreConflict = errors.New("task's project or parent no longer exists")
+
+// Deleted items are moved to the trash, a separate collection keyed by the
+// item's ID, with DeletedAt set. Trashed items are invisible to every other
+// Store method; they keep their ID, which is never reused, until they are
+// restored or purged.
+
+// Trash returns the items in the trash, ordered by ID.
+func (s *taskStore) Trash(ctx context.Context) ([]ToDo, error) {
+	var todos []ToDo
+	err := s.view(ctx, func(tx txn) error {
+		var err error
+		todos, err = tx.allTrashed()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortByID(todos)
+	return todos, nil
+}
+
+// Untrash moves an item from the trash back into the store. Its project and
+// parent must exist again; dependencies on items that are gone are dropped.
+func (s *taskStore) Untrash(ctx context.Context, id int) (ToDo, error) {
+	var todo ToDo
+	err := s.update(ctx, "untrash", func(tx txn) error {
+		var err error
+		if todo, err = tx.getTrashed(id); err != nil {
+			return err
+		}
+		if err := checkProject(tx, todo.Project); errors.Is(err, ErrProjectNotFound) {
+			return ErrRestoreConflict
+		} else if err != nil {
+			return err
+		}
+		if err := checkParent(tx, id, todo.Parent); errors.Is(err, ErrParentNotFound) {
+			return ErrRestoreConflict
+		} else if err != nil {
+			return err
+		}
+		var blockers []int
+		for _, b := range todo.BlockedBy {
+			if _, err := tx.get(b); err == nil {
+				blockers = append(blockers, b)
+			} else if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		todo.BlockedBy = blockers
+		if err := refreshBlocked(tx, &todo); err != nil {
+			return err
+		}
+		todo.DeletedAt = nil
+		todo.Version++
+		s.refreshOverdue(&todo)
+		if err := tx.removeTrashed(id); err != nil {
+			return err
+		}
+		if err := tx.put(todo); err != nil {
+			return err
+		}
+		return s.refreshAncestors(tx, todo.Parent, !todo.Completed, todo.CompletedBy)
+	})
+	if err != nil {
+		return ToDo{}, err
+	}
+	return todo, nil
+}
+
+// PurgeTrash permanently removes the items deleted at or before cutoff and
+// returns how many it removed. Their history is kept.
+func (s *taskStore) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	purged := 0
+	err := s.update(ctx, "purge", func(tx txn) error {
+		todos, err := tx.allTrashed()
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if todo.DeletedAt == nil || todo.DeletedAt.After(cutoff) {
+				continue
+			}
+			if err := tx.removeTrashed(todo.ID); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// trashItem moves an item from the store to the trash.
+func (s *taskStore) trashItem(tx txn, todo ToDo) error {
+	now := s.now().UTC()
+	todo.DeletedAt = &now
+	todo.Version++
+	if err := tx.remove(todo.ID); err != nil {
+		return err
+	}
+	return tx.putTrashed(todo)
+}
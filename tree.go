@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var (
+	// ErrParentNotFound is returned when an item's parent does not exist.
+	ErrParentNotFound = errors.New("parent task not found")
+	// ErrCycle is returned when a parent assignment would make an item its own ancestor.
+	ErrCycle = errors.New("parent would create a cycle")
+	// ErrHasSubtasks is returned when deleting an item that still has children.
+	ErrHasSubtasks = errors.New("task has subtasks")
+)
+
+// completionRules control how completion cascades up task trees.
+type completionRules struct {
+	// AutoCompleteParents completes a parent once all of its children are
+	// done, and reopens it when an open child is added or a child is reopened.
+	AutoCompleteParents bool
+}
+
+func defaultCompletionRules() completionRules {
+	return completionRules{AutoCompleteParents: true}
+}
+
+// loadCompletionRules applies TODO_AUTO_COMPLETE_PARENTS over the defaults.
+func loadCompletionRules(getenv func(string) string) (completionRules, error) {
+	rules := defaultCompletionRules()
+	if v := getenv("TODO_AUTO_COMPLETE_PARENTS"); v != "" {
+		auto, err := strconv.ParseBool(v)
+		if err != nil {
+			return rules, fmt.Errorf("TODO_AUTO_COMPLETE_PARENTS must be true or false, got %q", v)
+		}
+		rules.AutoCompleteParents = auto
+	}
+	return rules, nil
+}
+
+// TaskProgress summarises the completion of a task's direct children.
+type TaskProgress struct {
+	Done    int `json:"done"`
+	Total   int `json:"total"`
+	Percent int `json:"percent"` // Done as a whole percentage of Total, rounded down
+}
+
+// progressOf returns the progress of a task with the given children, or nil
+// for a task without any.
+func progressOf(children []ToDo) *TaskProgress {
+	if len(children) == 0 {
+		return nil
+	}
+	p := &TaskProgress{Total: len(children)}
+	for _, child := range children {
+		if child.Completed {
+			p.Done++
+		}
+	}
+	p.Percent = p.Done * 100 / p.Total
+	return p
+}
+
+// TaskTree is a task with its subtasks, as served by GET /todos/{id}?expand=children.
+type TaskTree struct {
+	ToDo
+	Children []TaskTree `json:"children"`
+}
+
+// Tree returns an item with all of its descendants, children in ID order.
+func (s *taskStore) Tree(ctx context.Context, id int) (TaskTree, error) {
+	var tree TaskTree
+	err := s.view(ctx, func(tx txn) error {
+		root, err := tx.get(id)
+		if err != nil {
+			return err
+		}
+		todos, err := tx.all()
+		if err != nil {
+			return err
+		}
+		sortByID(todos)
+		children := map[int][]ToDo{}
+		for _, todo := range todos {
+			if todo.Parent != 0 {
+				children[todo.Parent] = append(children[todo.Parent], todo)
+			}
+		}
+		var build func(todo ToDo) TaskTree
+		build = func(todo ToDo) TaskTree {
+			node := TaskTree{ToDo: todo, Children: []TaskTree{}}
+			for _, child := range children[todo.ID] {
+				node.Children = append(node.Children, build(child))
+			}
+			return node
+		}
+		tree = build(root)
+		return nil
+	})
+	return tree, err
+}
+
+// childrenOf returns the direct children of an item.
+func childrenOf(tx txn, id int) ([]ToDo, error) {
+	filter := ListFilter{Parent: &id}
+	todos, err := listCandidates(tx, filter)
+	if err != nil {
+		return nil, err
+	}
+	children := todos[:0:0]
+	for _, todo := range todos {
+		if filter.matches(todo) {
+			children = append(children, todo)
+		}
+	}
+	return children, nil
+}
+
+// checkParent fails unless parent is 0 or an existing item that is neither
+// id itself nor one of its descendants.
+func checkParent(tx txn, id, parent int) error {
+	for p := parent; p != 0; {
+		if p == id {
+			return ErrCycle
+		}
+		todo, err := tx.get(p)
+		if errors.Is(err, ErrNotFound) {
+			return ErrParentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		p = todo.Parent
+	}
+	return nil
+}
+
+// refreshAncestors recomputes the progress of parent after one of its
+// children changed and applies the completion rules: with auto-completion
+// on, the parent is completed (by the given actor) once every child is done
+// and reopened when childOpened; blocked parents are not auto-completed.
+// Changes propagate up the tree until an ancestor is left unchanged.
+func (s *taskStore) refreshAncestors(tx txn, parent int, childOpened bool, by string) error {
+	for parent != 0 {
+		current, err := tx.get(parent)
+		if err != nil {
+			return err
+		}
+		children, err := childrenOf(tx, parent)
+		if err != nil {
+			return err
+		}
+		todo := current
+		todo.Progress = progressOf(children)
+		if s.completion.AutoCompleteParents {
+			switch {
+			case todo.Progress != nil && todo.Progress.Done == todo.Progress.Total && !todo.Blocked:
+				s.markCompleted(&todo, by)
+			case childOpened:
+				markOpen(&todo)
+			}
+		}
+		s.refreshOverdue(&todo)
+		if reflect.DeepEqual(todo, current) {
+			return nil
+		}
+		if todo.Completed && !current.Completed {
+			if err := s.recur(tx, &todo); err != nil {
+				return err
+			}
+		}
+		todo.Version++
+		if err := tx.put(todo); err != nil {
+			return err
+		}
+		if todo.Completed != current.Completed {
+			if err := refreshDependents(tx, todo.ID, false); err != nil {
+				return err
+			}
+		}
+		childOpened = current.Completed && !todo.Completed
+		parent = todo.Parent
+	}
+	return nil
+}
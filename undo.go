@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNothingToUndo is returned when a session has no operation left to undo or redo.
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrUndoConflict is returned when an operation cannot be reversed
+	// because its task changed since; the operation is dropped.
+	ErrUndoConflict = errors.New("task changed since the operation")
+)
+
+// undoConfig bounds the undo log.
+type undoConfig struct {
+	Depth    int // Operations kept per session for undo (and as many for redo)
+	Sessions int // Sessions kept; the least recently active one is dropped beyond this
+}
+
+func defaultUndoConfig() undoConfig {
+	return undoConfig{Depth: 20, Sessions: 1000}
+}
+
+// loadUndoConfig applies TODO_UNDO_DEPTH over the defaults.
+func loadUndoConfig(getenv func(string) string) (undoConfig, error) {
+	cfg := defaultUndoConfig()
+	if v := getenv("TODO_UNDO_DEPTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("TODO_UNDO_DEPTH must be a positive integer, got %q", v)
+		}
+		cfg.Depth = n
+	}
+	return cfg, nil
+}
+
+// sessionKey is the context key of the client session whose changes are
+// recorded for undo.
+type sessionKey struct{}
+
+// withSession returns a context whose store changes are recorded in the
+// undo log of session.
+func withSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// sessionFrom returns the session set by withSession, or "" when changes
+// are not recorded.
+func sessionFrom(ctx context.Context) string {
+	session, _ := ctx.Value(sessionKey{}).(string)
+	return session
+}
+
+// undoEntry is one recorded operation, as the versions of the task before
+// and after it; Before is 0 when the operation created the task and After
+// is 0 when it deleted it. The versions name revisions in the task's history.
+// Effects are the tasks created along with the task's last change, e.g. the
+// next occurrence of a completed recurring task, as adds; replaying the
+// entry in either direction moves them to the trash. Completion cascaded to
+// parent tasks needs no record, as replaying the change cascades again.
+type undoEntry struct {
+	Op      string
+	TaskID  int
+	Before  int
+	After   int
+	Effects []undoEntry
+}
+
+// undoResult reports one reversed (or reapplied) operation; Task is the
+// task as it is now, absent when it is in the trash.
+type undoResult struct {
+	Op     string `json:"op"`
+	TaskID int    `json:"task_id"`
+	Task   *ToDo  `json:"task,omitempty"`
+}
+
+// sessionLog holds a session's undo and redo stacks, most recent last.
+type sessionLog struct {
+	undo, redo []undoEntry
+	active     time.Time
+}
+
+// undoLog is an inverse-operation log on top of a Store. Operations are
+// reversed through the store's history (Restore) and trash (Delete and
+// Untrash), so every undo is itself a recorded change, and conflicts are
+// detected against the revisions the operations left behind.
+type undoLog struct {
+	store Store // Undo and redo write here directly, so they are not recorded
+	cfg   undoConfig
+	now   func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*sessionLog
+}
+
+func newUndoLog(s Store, cfg undoConfig) *undoLog {
+	return &undoLog{store: s, cfg: cfg, now: time.Now, sessions: map[string]*sessionLog{}}
+}
+
+// session returns the log of a session, creating it and evicting the least
+// recently active session when there are too many. The caller must hold mu.
+func (l *undoLog) session(id string) *sessionLog {
+	s, ok := l.sessions[id]
+	if !ok {
+		if len(l.sessions) >= l.cfg.Sessions {
+			oldest := ""
+			for id, s := range l.sessions {
+				if oldest == "" || s.active.Before(l.sessions[oldest].active) {
+					oldest = id
+				}
+			}
+			delete(l.sessions, oldest)
+		}
+		s = &sessionLog{}
+		l.sessions[id] = s
+	}
+	s.active = l.now()
+	return s
+}
+
+// undoBatchKey is the context key of the operations made in an undoStore
+// batch, which are recorded once it commits.
+type undoBatchKey struct{}
+
+// record pushes an operation made in the session of ctx and clears its redo stack.
+func (l *undoLog) record(ctx context.Context, e undoEntry) {
+	id := sessionFrom(ctx)
+	if id == "" {
+		return
+	}
+	if pending, ok := ctx.Value(undoBatchKey{}).(*[]undoEntry); ok {
+		*pending = append(*pending, e)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.session(id)
+	s.undo = push(s.undo, e, l.cfg.Depth)
+	s.redo = nil
+}
+
+// push appends e, dropping the oldest entries beyond depth.
+func push(stack []undoEntry, e undoEntry, depth int) []undoEntry {
+	stack = append(stack, e)
+	if len(stack) > depth {
+		stack = stack[len(stack)-depth:]
+	}
+	return stack
+}
+
+// undo reverses up to steps of the session's most recent operations,
+// newest first. It stops at the first operation whose task has changed
+// since, dropping it, and returns what it reversed with an ErrUndoConflict.
+func (l *undoLog) undo(ctx context.Context, session string, steps int) ([]undoResult, error) {
+	return l.replay(ctx, session, steps, false)
+}
+
+// redo reapplies up to steps of the operations most recently undone.
+func (l *undoLog) redo(ctx context.Context, session string, steps int) ([]undoResult, error) {
+	return l.replay(ctx, session, steps, true)
+}
+
+func (l *undoLog) replay(ctx context.Context, session string, steps int, forward bool) ([]undoResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.session(session)
+	from, to := &s.undo, &s.redo
+	if forward {
+		from, to = to, from
+	}
+	if len(*from) == 0 {
+		return nil, ErrNothingToUndo
+	}
+	results := []undoResult{}
+	for range steps {
+		if len(*from) == 0 {
+			break
+		}
+		e := (*from)[len(*from)-1]
+		*from = (*from)[:len(*from)-1]
+		task, err := l.apply(ctx, &e, forward)
+		if err != nil {
+			return results, fmt.Errorf("%w: %s of task %d: %w", ErrUndoConflict, e.Op, e.TaskID, err)
+		}
+		*to = push(*to, e, l.cfg.Depth)
+		results = append(results, undoResult{Op: e.Op, TaskID: e.TaskID, Task: task})
+	}
+	return results, nil
+}
+
+// apply moves the task of e from its After state back to its Before state
+// (or forward, the other way round), trashing the tasks in e.Effects and
+// recording those the move creates in their place. Every task must still
+// have the content e left it with, in the store or, when e deleted it, in
+// the trash; the versions may differ, as undo and redo are changes of their
+// own. The changes are made in one Batch, so a conflict leaves all alone.
+func (l *undoLog) apply(ctx context.Context, e *undoEntry, forward bool) (*ToDo, error) {
+	from, to := e.After, e.Before
+	if forward {
+		from, to = to, from
+	}
+	var task *ToDo
+	err := l.store.Batch(ctx, func(ctx context.Context) error {
+		current, trashed, err := l.check(ctx, e.TaskID, from, to)
+		if err != nil {
+			return err
+		}
+		var effects []ToDo
+		for _, effect := range e.Effects {
+			if _, err := l.store.Get(ctx, effect.TaskID); errors.Is(err, ErrNotFound) {
+				continue // Deleted since
+			}
+			todo, _, err := l.check(ctx, effect.TaskID, effect.After, 0)
+			if err != nil {
+				return err
+			}
+			effects = append(effects, todo)
+		}
+		for _, todo := range effects {
+			if err := l.store.Delete(ctx, todo.ID, todo.Version); err != nil {
+				return err
+			}
+		}
+
+		var changes []HistoryEntry
+		ctx = withChangeLog(ctx, &changes)
+		switch {
+		case to == 0:
+			err = l.store.Delete(ctx, e.TaskID, current.Version)
+		case trashed:
+			var todo ToDo
+			todo, err = l.store.Untrash(ctx, e.TaskID)
+			task = &todo
+		default:
+			var todo ToDo
+			todo, err = l.store.Restore(ctx, e.TaskID, to, current.Version)
+			task = &todo
+		}
+		e.Effects = createdBy(changes, e.TaskID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// check returns task id, which must have the content of version from, in
+// the store or, when from is 0, in the trash with the content of version to.
+func (l *undoLog) check(ctx context.Context, id, from, to int) (todo ToDo, trashed bool, err error) {
+	current, trashed, err := l.find(ctx, id)
+	if err != nil {
+		return ToDo{}, false, err
+	}
+	expect := from
+	if from == 0 {
+		expect = to // Deleting leaves the content as it was
+	}
+	rev, err := l.revision(ctx, id, expect)
+	if err != nil {
+		return ToDo{}, false, err
+	}
+	if trashed != (from == 0) || !sameContent(current, rev) {
+		return ToDo{}, false, ErrVersionMismatch
+	}
+	return current, trashed, nil
+}
+
+// createdBy returns the tasks other than id created by changes, as adds.
+func createdBy(changes []HistoryEntry, id int) []undoEntry {
+	var created []undoEntry
+	for _, c := range changes {
+		if c.Before == nil && c.After != nil && c.TaskID != id {
+			created = append(created, undoEntry{Op: "add", TaskID: c.TaskID, After: c.After.Version})
+		}
+	}
+	return created
+}
+
+// find returns item id from the store, or from the trash with trashed set.
+func (l *undoLog) find(ctx context.Context, id int) (todo ToDo, trashed bool, err error) {
+	todo, err = l.store.Get(ctx, id)
+	if !errors.Is(err, ErrNotFound) {
+		return todo, false, err
+	}
+	trash, err := l.store.Trash(ctx)
+	if err != nil {
+		return ToDo{}, false, err
+	}
+	for _, todo := range trash {
+		if todo.ID == id {
+			return todo, true, nil
+		}
+	}
+	return ToDo{}, false, ErrNotFound
+}
+
+// revision returns item id as it was at version, from its history.
+func (l *undoLog) revision(ctx context.Context, id, version int) (ToDo, error) {
+	entries, err := l.store.History(ctx, id)
+	if err != nil {
+		return ToDo{}, err
+	}
+	for _, entry := range entries {
+		if rev := entry.After; rev != nil && rev.Version == version {
+			return *rev, nil
+		}
+	}
+	return ToDo{}, ErrRevisionNotFound
+}
+
+// sameContent reports whether todo has the editable fields and completion
+// state of rev, the parts of a task that Restore sets.
+func sameContent(todo, rev ToDo) bool {
+	restored := todo
+	applyEdits(&restored, rev)
+	restored.Completed, restored.CompletedAt, restored.CompletedBy = rev.Completed, rev.CompletedAt, rev.CompletedBy
+	return reflect.DeepEqual(restored, todo)
+}
+
+// wrap returns a Store that records the changes made in a client session
+// (see withSession) to l.
+func (l *undoLog) wrap(s Store) Store {
+	return &undoStore{Store: s, log: l}
+}
+
+// undoStore records adds, edits, completions, tag changes and deletes for
+// undo. Other operations (moves, restores, projects) are passed through.
+type undoStore struct {
+	Store
+	log *undoLog
+}
+
+// undoRetries bounds how often a change is retried when the item changes
+// between reading its version and writing it.
+const undoRetries = 3
+
+// mutate runs fn for a change to item id at a known version, so the change
+// can be recorded from the exact state it started from. Unconditional
+// changes (ifVersion 0) are pinned to the version just read and retried if
+// the item changes in between.
+func (s *undoStore) mutate(ctx context.Context, op string, id, ifVersion int, fn func(ctx context.Context, ifVersion int) (ToDo, error)) (ToDo, error) {
+	if sessionFrom(ctx) == "" {
+		return fn(ctx, ifVersion)
+	}
+	for attempt := 1; ; attempt++ {
+		before := ifVersion
+		if before == 0 {
+			current, err := s.Store.Get(ctx, id)
+			if err != nil {
+				return ToDo{}, err
+			}
+			before = current.Version
+		}
+		var changes []HistoryEntry
+		todo, err := fn(withChangeLog(ctx, &changes), before)
+		if errors.Is(err, ErrVersionMismatch) && ifVersion == 0 && attempt < undoRetries {
+			continue
+		}
+		if err != nil {
+			return ToDo{}, err
+		}
+		if op == "delete" {
+			s.log.record(ctx, undoEntry{Op: op, TaskID: id, Before: before, Effects: createdBy(changes, id)})
+		} else if todo.Version != before {
+			s.log.record(ctx, undoEntry{Op: op, TaskID: id, Before: before, After: todo.Version, Effects: createdBy(changes, id)})
+		}
+		return todo, nil
+	}
+}
+
+// Batch records the operations of a batch only if it commits, each as an
+// entry of its own.
+func (s *undoStore) Batch(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, nested := ctx.Value(undoBatchKey{}).(*[]undoEntry); nested {
+		return s.Store.Batch(ctx, fn)
+	}
+	var pending []undoEntry
+	if err := s.Store.Batch(context.WithValue(ctx, undoBatchKey{}, &pending), fn); err != nil {
+		return err
+	}
+	for _, e := range pending {
+		s.log.record(ctx, e)
+	}
+	return nil
+}
+
+func (s *undoStore) Add(ctx context.Context, todo ToDo) (ToDo, error) {
+	added, err := s.Store.Add(ctx, todo)
+	if err == nil {
+		s.log.record(ctx, undoEntry{Op: "add", TaskID: added.ID, After: added.Version})
+	}
+	return added, err
+}
+
+func (s *undoStore) Update(ctx context.Context, edited ToDo, ifVersion int) (ToDo, error) {
+	return s.mutate(ctx, "update", edited.ID, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return s.Store.Update(ctx, edited, v) })
+}
+
+func (s *undoStore) Replace(ctx context.Context, edited ToDo, by string, ifVersion int) (ToDo, error) {
+	return s.mutate(ctx, "update", edited.ID, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return s.Store.Replace(ctx, edited, by, v) })
+}
+
+func (s *undoStore) Delete(ctx context.Context, id int, ifVersion int) error {
+	_, err := s.mutate(ctx, "delete", id, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return ToDo{}, s.Store.Delete(ctx, id, v) })
+	return err
+}
+
+func (s *undoStore) Complete(ctx context.Context, id int, by string, force bool, ifVersion int) (ToDo, error) {
+	return s.mutate(ctx, "complete", id, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return s.Store.Complete(ctx, id, by, force, v) })
+}
+
+func (s *undoStore) Uncomplete(ctx context.Context, id int, ifVersion int) (ToDo, error) {
+	return s.mutate(ctx, "uncomplete", id, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return s.Store.Uncomplete(ctx, id, v) })
+}
+
+func (s *undoStore) AddTag(ctx context.Context, id int, tag string, maxTags int, ifVersion int) (ToDo, error) {
+	return s.mutate(ctx, "tag", id, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return s.Store.AddTag(ctx, id, tag, maxTags, v) })
+}
+
+func (s *undoStore) RemoveTag(ctx context.Context, id int, tag string, ifVersion int) (ToDo, error) {
+	return s.mutate(ctx, "untag", id, ifVersion, func(ctx context.Context, v int) (ToDo, error) { return s.Store.RemoveTag(ctx, id, tag, v) })
+}
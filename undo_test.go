@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUndoLog(t *testing.T) {
+	inner := NewMemoryStore()
+	log := newUndoLog(inner, undoConfig{Depth: 3, Sessions: 2})
+	s := log.wrap(inner)
+	ctx := withSession(context.Background(), "alice")
+
+	todo, _ := s.Add(ctx, ToDo{Text: "Buy milk"})
+	todo.Text = "Buy oat milk"
+	s.Update(ctx, todo, 0)
+	s.Complete(ctx, todo.ID, "alice", false, 0)
+
+	results, err := log.undo(ctx, "alice", 2)
+	if err != nil || len(results) != 2 || results[0].Op != "complete" || results[1].Op != "update" {
+		t.Fatalf("undo 2 = %+v, %v", results, err)
+	}
+	if got, _ := inner.Get(ctx, todo.ID); got.Text != "Buy milk" || got.Completed {
+		t.Errorf("after undo: %+v", got)
+	}
+	if _, err := log.redo(ctx, "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := inner.Get(ctx, todo.ID); got.Text != "Buy oat milk" {
+		t.Errorf("after redo: %+v", got)
+	}
+
+	// A change made outside the session conflicts with undoing the redone update.
+	got, _ := inner.Get(ctx, todo.ID)
+	got.Text = "Buy soy milk"
+	s.Update(context.Background(), got, 0)
+	if _, err := log.undo(ctx, "alice", 1); !errors.Is(err, ErrUndoConflict) || !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("undo after a concurrent change = %v", err)
+	}
+	if got, _ := inner.Get(ctx, todo.ID); got.Text != "Buy soy milk" {
+		t.Errorf("conflicting undo changed the task: %+v", got)
+	}
+
+	// Undoing a delete restores the task from the trash; a new change clears redo.
+	s.Delete(ctx, todo.ID, 0)
+	if _, err := log.undo(ctx, "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inner.Get(ctx, todo.ID); err != nil {
+		t.Errorf("undone delete: %v", err)
+	}
+	s.Add(ctx, ToDo{Text: "Walk the dog"})
+	if _, err := log.redo(ctx, "alice", 1); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("redo after a new change = %v", err)
+	}
+
+	// Undoing an add trashes the task; undo stops at the add of the task
+	// changed outside the session.
+	results, err = log.undo(ctx, "alice", 10)
+	if !errors.Is(err, ErrUndoConflict) || len(results) != 1 || results[0].Op != "add" || results[0].Task != nil {
+		t.Fatalf("undo all = %+v, %v", results, err)
+	}
+	if trash, _ := inner.Trash(ctx); len(trash) != 1 {
+		t.Errorf("trash after undoing add = %+v", trash)
+	}
+
+	// Each session keeps its last Depth operations.
+	for range 4 {
+		s.Add(ctx, ToDo{Text: "Chore"})
+	}
+	if results, _ := log.undo(ctx, "alice", 10); len(results) != 3 {
+		t.Errorf("undid %d operations, want the last 3", len(results))
+	}
+
+	// The least recently active session is evicted beyond Sessions.
+	clock := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+	s.Add(withSession(ctx, "bob"), ToDo{Text: "b"})
+	log.redo(ctx, "alice", 1)
+	s.Add(withSession(ctx, "carol"), ToDo{Text: "c"})
+	if _, err := log.undo(ctx, "bob", 1); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("evicted session could still undo: %v", err)
+	}
+	if _, err := log.undo(ctx, "carol", 1); err != nil {
+		t.Errorf("undo in a kept session: %v", err)
+	}
+}
+
+func TestUndoCompletionSideEffects(t *testing.T) {
+	inner := NewMemoryStore()
+	log := newUndoLog(inner, defaultUndoConfig())
+	s := log.wrap(inner)
+	ctx := withSession(context.Background(), "alice")
+
+	// Undoing the completion of a recurring task trashes its next occurrence,
+	// and redoing it creates a new one.
+	due := time.Now().Add(24 * time.Hour).UTC()
+	daily, _ := s.Add(ctx, ToDo{Text: "stand-up", Due: &due, Recurrence: "FREQ=DAILY"})
+	done, _ := s.Complete(ctx, daily.ID, "alice", false, 0)
+	if _, err := log.undo(ctx, "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := inner.Get(ctx, daily.ID); got.Completed || got.NextOccurrence != 0 {
+		t.Errorf("after undo: %+v", got)
+	}
+	if _, err := inner.Get(ctx, done.NextOccurrence); !errors.Is(err, ErrNotFound) {
+		t.Errorf("next occurrence %d still in the store: %v", done.NextOccurrence, err)
+	}
+	if _, err := log.redo(ctx, "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	redone, _ := inner.Get(ctx, daily.ID)
+	if !redone.Completed || redone.NextOccurrence == 0 || redone.NextOccurrence == done.NextOccurrence {
+		t.Fatalf("after redo: %+v", redone)
+	}
+
+	// A next occurrence edited since cannot be trashed, so the undo conflicts
+	// and leaves both tasks alone.
+	next, _ := inner.Get(ctx, redone.NextOccurrence)
+	next.Text = "stand-up with the new team"
+	s.Update(context.Background(), next, 0)
+	if _, err := log.undo(ctx, "alice", 1); !errors.Is(err, ErrUndoConflict) {
+		t.Errorf("undo after the next occurrence changed = %v", err)
+	}
+	if got, _ := inner.Get(ctx, daily.ID); !got.Completed {
+		t.Errorf("conflicting undo reopened the task: %+v", got)
+	}
+
+	// Undoing the completion of a parent's last open subtask reopens the
+	// parent it completed.
+	parent, _ := s.Add(ctx, ToDo{Text: "Move house"})
+	child, _ := s.Add(ctx, ToDo{Text: "Pack boxes", Parent: parent.ID})
+	s.Complete(ctx, child.ID, "alice", false, 0)
+	if got, _ := inner.Get(ctx, parent.ID); !got.Completed {
+		t.Fatalf("parent not completed with its last subtask: %+v", got)
+	}
+	if _, err := log.undo(ctx, "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := inner.Get(ctx, parent.ID); got.Completed {
+		t.Errorf("parent still completed after undo: %+v", got)
+	}
+}
+
+func TestLoadUndoConfig(t *testing.T) {
+	env := map[string]string{"TODO_UNDO_DEPTH": "5"}
+	if cfg, err := loadUndoConfig(func(key string) string { return env[key] }); err != nil || cfg.Depth != 5 {
+		t.Errorf("config = %+v, %v", cfg, err)
+	}
+	env["TODO_UNDO_DEPTH"] = "0"
+	if _, err := loadUndoConfig(func(key string) string { return env[key] }); err == nil {
+		t.Error("zero undo depth was accepted")
+	}
+}
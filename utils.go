@@ -1,6 +1,14 @@
 package main
 
-import "strings" // Import strings package for Contains
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // contains checks if the query string is present in the text.
 // Updated to use strings.Contains for simplicity and correctness.
@@ -13,3 +21,127 @@ func contains(text, query string) bool {
 	// return strings.Contains(strings.ToLower(text), strings.ToLower(query))
 	return strings.Contains(text, query)
 }
+
+// requestActor identifies who is performing a request, taken from the X-User header.
+func requestActor(r *http.Request) string {
+	if user := strings.TrimSpace(r.Header.Get("X-User")); user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+// requestSession identifies the client session whose changes can be undone,
+// taken from the X-Session-ID header; without one, each actor is a session.
+func requestSession(r *http.Request) string {
+	if session := strings.TrimSpace(r.Header.Get("X-Session-ID")); session != "" {
+		return session
+	}
+	return requestActor(r)
+}
+
+// withClient attributes the store changes made by a request to its actor
+// (in the task history) and its session (in the undo log).
+func withClient(ctx context.Context, r *http.Request) context.Context {
+	return withSession(withActor(ctx, requestActor(r)), requestSession(r))
+}
+
+// parseStatusFilter converts the "status" query parameter (open, done or all) into a ListFilter.
+func parseStatusFilter(status string) (ListFilter, error) {
+	var filter ListFilter
+	switch status {
+	case "", "all":
+	case "open":
+		open := false
+		filter.Completed = &open
+	case "done":
+		done := true
+		filter.Completed = &done
+	default:
+		return filter, fmt.Errorf("unknown status %q, expected open, done or all", status)
+	}
+	return filter, nil
+}
+
+// parseListFilter builds a ListFilter from the status, project, parent,
+// overdue, blocked, due_before, due_after and tags_any/tags_all/tags_none
+// query parameters.
+func parseListFilter(query url.Values) (ListFilter, error) {
+	filter, err := parseStatusFilter(query.Get("status"))
+	if err != nil {
+		return filter, err
+	}
+	if filter.Project, err = parseIDParam(query, "project"); err != nil {
+		return filter, err
+	}
+	if filter.Parent, err = parseIDParam(query, "parent"); err != nil {
+		return filter, err
+	}
+	if filter.Overdue, err = parseBoolParam(query, "overdue"); err != nil {
+		return filter, err
+	}
+	if filter.Blocked, err = parseBoolParam(query, "blocked"); err != nil {
+		return filter, err
+	}
+	if filter.DueBefore, err = parseTimeParam(query, "due_before"); err != nil {
+		return filter, err
+	}
+	if filter.DueAfter, err = parseTimeParam(query, "due_after"); err != nil {
+		return filter, err
+	}
+	if filter.TagsAny, err = parseTagFilter(query, "tags_any"); err != nil {
+		return filter, err
+	}
+	if filter.TagsAll, err = parseTagFilter(query, "tags_all"); err != nil {
+		return filter, err
+	}
+	if filter.TagsNone, err = parseTagFilter(query, "tags_none"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseBoolParam reads an optional true/false query parameter.
+func parseBoolParam(query url.Values, name string) (*bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false, got %q", name, v)
+	}
+	return &b, nil
+}
+
+// parseIDParam reads an optional ID query parameter; "none" stands for 0.
+func parseIDParam(query url.Values, name string) (*int, error) {
+	switch v := query.Get(name); v {
+	case "":
+		return nil, nil
+	case "none":
+		none := 0
+		return &none, nil
+	default:
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%s must be an ID or none, got %q", name, v)
+		}
+		return &id, nil
+	}
+}
+
+// parseTimeParam reads an optional RFC 3339 timestamp or a plain date,
+// which stands for midnight UTC at the start of that day.
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, v); err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date, got %q", name, v)
+		}
+	}
+	return &t, nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// fieldConstraint bounds one field of a task payload. Lengths are counted
+// in characters after normalisation, per element for list fields; 0 leaves
+// a bound unset.
+type fieldConstraint struct {
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+	MaxItems  int `json:"max_items"` // List fields only
+}
+
+// validationConfig holds the limits applied to request bodies.
+type validationConfig struct {
+	MaxBodyBytes int64                      // Larger bodies are rejected with 413
+	Fields       map[string]fieldConstraint // Per-field constraints, keyed by JSON member name
+}
+
+// validation is the active configuration; main loads it from the environment.
+var validation = defaultValidationConfig()
+
+func defaultValidationConfig() validationConfig {
+	return validationConfig{
+		MaxBodyBytes: 64 << 10,
+		Fields: map[string]fieldConstraint{
+			"text": {MinLength: 1, MaxLength: 500},
+			"tags": {MinLength: 1, MaxLength: 50, MaxItems: 20},
+			"name": {MinLength: 1, MaxLength: 100}, // Project names
+		},
+	}
+}
+
+// loadValidationConfig applies TODO_MAX_BODY_BYTES and TODO_FIELD_CONSTRAINTS
+// over the defaults. TODO_FIELD_CONSTRAINTS is a JSON object of constraints by
+// field, e.g. {"text":{"max_length":280}}; bounds it leaves out keep their defaults.
+func loadValidationConfig(getenv func(string) string) (validationConfig, error) {
+	cfg := defaultValidationConfig()
+	if v := getenv("TODO_MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("TODO_MAX_BODY_BYTES must be a positive integer, got %q", v)
+		}
+		cfg.MaxBodyBytes = n
+	}
+	if v := getenv("TODO_FIELD_CONSTRAINTS"); v != "" {
+		var overrides map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(v), &overrides); err != nil {
+			return cfg, fmt.Errorf("TODO_FIELD_CONSTRAINTS: %w", err)
+		}
+		for name, raw := range overrides {
+			c, ok := cfg.Fields[name]
+			if !ok {
+				return cfg, fmt.Errorf("TODO_FIELD_CONSTRAINTS: field %q has no constraints", name)
+			}
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&c); err != nil {
+				return cfg, fmt.Errorf("TODO_FIELD_CONSTRAINTS: %s: %w", name, err)
+			}
+			if c.MinLength < 0 || c.MaxLength < 0 || c.MaxItems < 0 || (c.MaxLength > 0 && c.MinLength > c.MaxLength) {
+				return cfg, fmt.Errorf("TODO_FIELD_CONSTRAINTS: %s: invalid length bounds %d..%d", name, c.MinLength, c.MaxLength)
+			}
+			cfg.Fields[name] = c
+		}
+	}
+	return cfg, nil
+}
+
+// taskInput is the body of POST /todos and PUT /todos/{id}. Editable
+// members are decoded with the task document rules in patch.go; the
+// server-managed ones are accepted by the decoder only so they can be
+// rejected by name, and anything else is an unknown field.
+type taskInput struct {
+	Text       json.RawMessage `json:"text"`
+	Due        json.RawMessage `json:"due"`
+	DueZone    json.RawMessage `json:"due_zone"`
+	Priority   json.RawMessage `json:"priority"`
+	Tags       json.RawMessage `json:"tags"`
+	Project    json.RawMessage `json:"project"`
+	Parent     json.RawMessage `json:"parent"`
+	BlockedBy  json.RawMessage `json:"blocked_by"`
+	Recurrence json.RawMessage `json:"recurrence"`
+
+	ID             json.RawMessage `json:"id"`
+	Version        json.RawMessage `json:"version"`
+	CreatedAt      json.RawMessage `json:"created_at"`
+	Completed      json.RawMessage `json:"completed"`
+	CompletedAt    json.RawMessage `json:"completed_at"`
+	CompletedBy    json.RawMessage `json:"completed_by"`
+	Overdue        json.RawMessage `json:"overdue"`
+	Position       json.RawMessage `json:"position"`
+	Progress       json.RawMessage `json:"progress"`
+	Blocked        json.RawMessage `json:"blocked"`
+	Occurrence     json.RawMessage `json:"occurrence"`
+	NextOccurrence json.RawMessage `json:"next_occurrence"`
+	DeletedAt      json.RawMessage `json:"deleted_at"`
+}
+
+// decodeTaskInput reads and validates a task body. pathID is the {id} of a
+// PUT, which an id member may repeat; for POST it is 0 and any id is
+// rejected. Validation failures are returned as validationErrors.
+func decodeTaskInput(w http.ResponseWriter, r *http.Request, pathID int) (ToDo, error) {
+	var in taskInput
+	if err := decodeJSONBody(w, r, &in); err != nil {
+		return ToDo{}, err
+	}
+	return in.task(pathID)
+}
+
+// task validates the input as the task with ID pathID (0 for a new task)
+// and returns it, or the validationErrors it fails with.
+func (in taskInput) task(pathID int) (ToDo, error) {
+	var errs validationErrors
+	if in.ID != nil {
+		var id int
+		if pathID == 0 || json.Unmarshal(in.ID, &id) != nil || id != pathID {
+			errs = append(errs, fieldError{Path: "/id", Message: "task IDs are assigned by the server", Code: "client_id"})
+		}
+	}
+	for name, raw := range map[string]json.RawMessage{
+		"version":         in.Version,
+		"created_at":      in.CreatedAt,
+		"completed":       in.Completed,
+		"completed_at":    in.CompletedAt,
+		"completed_by":    in.CompletedBy,
+		"overdue":         in.Overdue,
+		"position":        in.Position,
+		"progress":        in.Progress,
+		"blocked":         in.Blocked,
+		"occurrence":      in.Occurrence,
+		"next_occurrence": in.NextOccurrence,
+		"deleted_at":      in.DeletedAt,
+	} {
+		if raw != nil {
+			errs = append(errs, fieldError{Path: "/" + name, Message: "field is managed by the server", Code: "read_only"})
+		}
+	}
+
+	todo := ToDo{ID: pathID}
+	for name, raw := range map[string]json.RawMessage{
+		"text":       in.Text,
+		"due":        in.Due,
+		"due_zone":   in.DueZone,
+		"priority":   in.Priority,
+		"tags":       in.Tags,
+		"project":    in.Project,
+		"parent":     in.Parent,
+		"blocked_by": in.BlockedBy,
+		"recurrence": in.Recurrence,
+	} {
+		if raw == nil {
+			if slices.Contains(requiredFields, name) {
+				errs = append(errs, fieldError{Path: "/" + name, Message: "field is required", Code: "required"})
+				continue
+			}
+			raw = json.RawMessage("null")
+		}
+		if ferr := documentFields[name].decode(raw, &todo); ferr != nil {
+			if ferr.Path == "" {
+				ferr.Path = "/" + name
+			}
+			errs = append(errs, *ferr)
+		}
+	}
+	if ferr := normalizeDue(&todo); ferr != nil {
+		errs = append(errs, *ferr)
+	}
+
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b fieldError) int { return strings.Compare(a.Path, b.Path) })
+		return ToDo{}, errs
+	}
+	return todo, nil
+}
+
+// taskFromDocument validates a task document assembled by another API, as
+// the body of POST /todos (id 0) or PUT /todos/{id}.
+func taskFromDocument(doc map[string]any, id int) (ToDo, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return ToDo{}, err
+	}
+	var in taskInput
+	if err := json.Unmarshal(data, &in); err != nil {
+		return ToDo{}, err
+	}
+	return in.task(id)
+}
+
+// moveInput is the body of POST /todos/{id}/move: exactly one of before or
+// after, naming the task to place the moved one next to.
+type moveInput struct {
+	Before *int `json:"before"`
+	After  *int `json:"after"`
+}
+
+// anchor validates the body for moving task id and returns the anchor task
+// and whether to place it before the anchor.
+func (in moveInput) anchor(id int) (int, bool, error) {
+	if (in.Before == nil) == (in.After == nil) {
+		return 0, false, validationErrors{{Path: "", Message: "exactly one of before or after is required", Code: "required"}}
+	}
+	anchor, path := in.After, "/after"
+	if in.Before != nil {
+		anchor, path = in.Before, "/before"
+	}
+	if *anchor == id {
+		return 0, false, validationErrors{{Path: path, Message: "cannot move a task relative to itself", Code: "invalid_value"}}
+	}
+	return *anchor, in.Before != nil, nil
+}
+
+// restoreInput is the body of POST /todos/{id}/restore.
+type restoreInput struct {
+	Version *int `json:"version"`
+}
+
+// version validates the body and returns the version to restore.
+func (in restoreInput) version() (int, error) {
+	switch {
+	case in.Version == nil:
+		return 0, validationErrors{{Path: "/version", Message: "field is required", Code: "required"}}
+	case *in.Version <= 0:
+		return 0, validationErrors{{Path: "/version", Message: "must be a positive version", Code: "invalid_value"}}
+	}
+	return *in.Version, nil
+}
+
+// projectInput is the body of POST /projects and PUT /projects/{id}.
+type projectInput struct {
+	Name *string `json:"name"`
+}
+
+// decodeProjectInput reads and validates a project body; the name is
+// normalised like task text.
+func decodeProjectInput(w http.ResponseWriter, r *http.Request) (Project, error) {
+	var in projectInput
+	if err := decodeJSONBody(w, r, &in); err != nil {
+		return Project{}, err
+	}
+	if in.Name == nil {
+		return Project{}, validationErrors{{Path: "/name", Message: "field is required", Code: "required"}}
+	}
+	name, ferr := normalizeText("name", *in.Name)
+	if ferr != nil {
+		return Project{}, validationErrors{*ferr}
+	}
+	return Project{Name: name}, nil
+}
+
+// decodeJSONBody strictly decodes a single JSON value from a request body
+// into v, within the body size limit and rejecting unknown fields.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, validation.MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("request body must contain a single JSON value")
+	}
+	return nil
+}
+
+// decodeError turns the decoder's unknown-field failure into a
+// validationError; anything else is a malformed body.
+func decodeError(err error) error {
+	if quoted, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		name, _ := strconv.Unquote(quoted)
+		return validationErrors{{Path: "/" + escapePointer(name), Message: "unknown field", Code: "unknown_field"}}
+	}
+	return err
+}
+
+// normalizeText applies the rules shared by every text field: NFC
+// normalisation, trimmed surrounding whitespace, no control characters and
+// the field's configured length bounds.
+func normalizeText(field, s string) (string, *fieldError) {
+	path := "/" + field
+	s = strings.TrimSpace(norm.NFC.String(s))
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return "", &fieldError{Path: path, Message: "must not contain control characters", Code: "control_characters"}
+	}
+	c := validation.Fields[field]
+	n := utf8.RuneCountInString(s)
+	switch {
+	case n == 0 && c.MinLength > 0:
+		return "", &fieldError{Path: path, Message: "must not be empty", Code: "empty"}
+	case n < c.MinLength:
+		return "", &fieldError{Path: path, Message: fmt.Sprintf("must be at least %d characters", c.MinLength), Code: "too_short"}
+	case c.MaxLength > 0 && n > c.MaxLength:
+		return "", &fieldError{Path: path, Message: fmt.Sprintf("must be at most %d characters", c.MaxLength), Code: "too_long"}
+	}
+	return s, nil
+}
+
+// normalizeDue checks that due_zone and recurrence come with a due time and
+// renders the due time in that zone.
+func normalizeDue(todo *ToDo) *fieldError {
+	if todo.Recurrence != "" && todo.Due == nil {
+		return &fieldError{Path: "/recurrence", Message: "requires due to be set", Code: "requires_due"}
+	}
+	if todo.DueZone == "" {
+		return nil
+	}
+	if todo.Due == nil {
+		return &fieldError{Path: "/due_zone", Message: "requires due to be set", Code: "requires_due"}
+	}
+	loc, err := time.LoadLocation(todo.DueZone)
+	if err != nil {
+		return &fieldError{Path: "/due_zone", Message: fmt.Sprintf("unknown time zone %q", todo.DueZone), Code: "invalid_format"}
+	}
+	due := todo.Due.In(loc)
+	todo.Due = &due
+	return nil
+}
+
+// readBody reads a raw request body up to the configured size limit.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, validation.MaxBodyBytes))
+}
+
+// handleBodyError reports a failure from decodeTaskInput or readBody.
+func handleBodyError(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string, err error) {
+	var tooLarge *http.MaxBytesError
+	var invalid validationErrors
+	switch {
+	case errors.As(err, &tooLarge):
+		handleError(ctx, w, r, handler, problemPayloadTooLarge,
+			fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit))
+	case errors.As(err, &invalid):
+		handleFieldErrors(ctx, w, r, handler, problemValidation, nil, invalid)
+	default:
+		handleError(ctx, w, r, handler, problemInvalidBody, err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// The WebSocket API (GET /ws) carries commands and change notifications
+// over one connection. Every client message is a command, answered by one
+// ack or error reply carrying the command's id; a subscribed connection
+// also receives an event message for every task change, like the
+// GET /todos/events stream. Commands run the same Store operations as the
+// HTTP handlers, attributed to the X-User and X-Session-ID of the handshake.
+
+// wsMessage is a command sent by the client.
+type wsMessage struct {
+	ID          string          `json:"id"`            // Echoed in the reply
+	Type        string          `json:"type"`          // subscribe, add, update, delete or complete
+	TaskID      int             `json:"task_id"`       // update, delete and complete
+	Task        json.RawMessage `json:"task"`          // add and update, as in POST /todos and PUT /todos/{id}
+	Version     int             `json:"version"`       // Like If-Match: apply only to this version of the task
+	Force       bool            `json:"force"`         // complete even while blocked
+	LastEventID *int64          `json:"last_event_id"` // subscribe: resume after this event
+}
+
+// wsReply is a message sent by the server: the ack or error answering a
+// command, or an event or reset on a subscribed connection.
+type wsReply struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Task    *ToDo           `json:"task,omitempty"`
+	Error   *problemDetails `json:"error,omitempty"`
+	EventID int64           `json:"event_id,omitempty"`
+	Event   string          `json:"event,omitempty"`  // add, update, complete or delete
+	Change  json.RawMessage `json:"change,omitempty"` // As in the data of GET /todos/events
+}
+
+// wsInstance is the problem instance reported for failed commands.
+const wsInstance = "/ws"
+
+var (
+	// errInvalidMessage is reported for a message that is not a valid command.
+	errInvalidMessage = errors.New("invalid message")
+	// errMissingTaskID is reported for a command on a task without a task_id.
+	errMissingTaskID = errors.New("task_id must be a positive integer")
+)
+
+// wsConn is the server side of one WebSocket connection.
+type wsConn struct {
+	conn *websocket.Conn
+	sub  *feedSubscriber // Set once the client subscribes
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	// The server's timeouts would cut the connection once it is hijacked
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		logWithTrace(r.Context()).Err(err).Msg("WebSocket handshake failed") // Accept has responded
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(validation.MaxBodyBytes)
+
+	ctx := withClient(r.Context(), r)
+	c := &wsConn{conn: conn}
+	defer c.unsubscribe()
+	logWithTrace(ctx).Str("event", "ws_connect").Str("actor", actorFrom(ctx)).Msg("WebSocket client connected")
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			if status := websocket.CloseStatus(err); status != websocket.StatusNormalClosure && status != websocket.StatusGoingAway {
+				logWithTrace(ctx).Err(err).Msg("WebSocket connection failed")
+			}
+			return
+		}
+		reply, subscribed := c.handle(ctx, data)
+		if err := wsjson.Write(ctx, conn, reply); err != nil {
+			return
+		}
+		if subscribed != nil {
+			go c.forward(ctx, subscribed)
+		}
+	}
+}
+
+// wsSubscription is what a subscribe command starts forwarding once it is acknowledged.
+type wsSubscription struct {
+	sub     *feedSubscriber
+	backlog []feedEvent
+	reset   bool
+}
+
+// handle runs one command in a span of its own and returns its reply.
+func (c *wsConn) handle(ctx context.Context, data []byte) (wsReply, *wsSubscription) {
+	start := time.Now()
+	var msg wsMessage
+	err := decodeWSMessage(data, &msg)
+	name := "message"
+	if err == nil && wsCommands[msg.Type] {
+		name = msg.Type
+	}
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		handlerLatency.Record(ctx, float64(duration), metric.WithAttributes(attribute.String("handler", "ws_"+name)))
+	}()
+
+	tr := otel.Tracer("todo-service")
+	ctx, span := tr.Start(ctx, "ws."+name, oteltrace.WithAttributes(attribute.String("ws.message.id", msg.ID)))
+	defer span.End()
+	if err != nil {
+		return c.fail(ctx, span, msg, err), nil
+	}
+	if !wsCommands[msg.Type] {
+		return c.fail(ctx, span, msg, fmt.Errorf("%w: unknown type %q", errInvalidMessage, msg.Type)), nil
+	}
+	if msg.Type != "subscribe" && msg.Type != "add" {
+		if msg.TaskID <= 0 {
+			return c.fail(ctx, span, msg, errMissingTaskID), nil
+		}
+		span.SetAttributes(attribute.Int("todo.id", msg.TaskID))
+	}
+
+	reply := wsReply{Type: "ack", ID: msg.ID}
+	switch msg.Type {
+	case "subscribe":
+		if c.sub != nil {
+			return c.fail(ctx, span, msg, fmt.Errorf("%w: already subscribed", errInvalidMessage)), nil
+		}
+		lastID := int64(-1)
+		if msg.LastEventID != nil {
+			lastID = *msg.LastEventID
+		}
+		s := &wsSubscription{}
+		s.sub, s.backlog, s.reset = changeFeed.subscribe(ctx, lastID)
+		c.sub = s.sub
+		span.SetAttributes(attribute.Int("feed.backlog", len(s.backlog)), attribute.Bool("feed.reset", s.reset))
+		logWithTrace(ctx).Str("event", "ws_subscribe").Int64("last_event_id", lastID).Msg("WebSocket client subscribed")
+		return reply, s
+	case "add":
+		todo, err := decodeWSTask(msg.Task, 0)
+		if err != nil {
+			return c.fail(ctx, span, msg, err), nil
+		}
+		added, err := store.Add(ctx, todo)
+		if err != nil {
+			return c.fail(ctx, span, msg, err), nil
+		}
+		taskCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("source", "websocket")))
+		countTagOperation(ctx, "add", added.Tags...)
+		span.SetAttributes(attribute.Int("todo.id", added.ID))
+		logWithTrace(ctx).Str("event", "task_added").Int("todo_id", added.ID).Str("todo_text", added.Text).Msg("Added task")
+		reply.Task = &added
+	case "update":
+		todo, err := decodeWSTask(msg.Task, msg.TaskID)
+		if err != nil {
+			return c.fail(ctx, span, msg, err), nil
+		}
+		updated, err := store.Update(ctx, todo, msg.Version)
+		if err != nil {
+			return c.fail(ctx, span, msg, err), nil
+		}
+		logWithTrace(ctx).Str("event", "update_task").Int("todo_id", updated.ID).Str("todo_text", updated.Text).Msg("Updated task")
+		reply.Task = &updated
+	case "delete":
+		if err := store.Delete(ctx, msg.TaskID, msg.Version); err != nil {
+			return c.fail(ctx, span, msg, err), nil
+		}
+		logWithTrace(ctx).Str("event", "delete_task").Int("todo_id", msg.TaskID).Msg("Moved task to trash")
+	case "complete":
+		completed, err := store.Complete(ctx, msg.TaskID, actorFrom(ctx), msg.Force, msg.Version)
+		if err != nil {
+			return c.fail(ctx, span, msg, err), nil
+		}
+		logWithTrace(ctx).Str("event", "complete_task").Int("todo_id", completed.ID).Str("actor", completed.CompletedBy).Msg("Completed task")
+		reply.Task = &completed
+	}
+	return reply, nil
+}
+
+// wsCommands are the message types a client may send.
+var wsCommands = map[string]bool{"subscribe": true, "add": true, "update": true, "delete": true, "complete": true}
+
+// fail logs and counts a failed command like handleError and returns its
+// error reply, mapping the error as the HTTP handlers do.
+func (c *wsConn) fail(ctx context.Context, span oteltrace.Span, msg wsMessage, err error) wsReply {
+	var problem problemType
+	var fieldErrs []fieldError
+	var invalid validationErrors
+	switch {
+	case errors.As(err, &invalid):
+		problem, fieldErrs = problemValidation, invalid
+	case errors.Is(err, errInvalidMessage):
+		problem = problemInvalidBody
+	case errors.Is(err, errMissingTaskID):
+		problem = problemInvalidID
+	default:
+		problem, fieldErrs = taskStoreProblem(err)
+	}
+	span.SetStatus(codes.Error, problem.title)
+	name := msg.Type
+	if !wsCommands[name] {
+		name = "message"
+	}
+	body := newProblemDetails(ctx, problem, recordProblem(ctx, "ws_"+name, problem, err, fieldErrs), wsInstance, fieldErrs)
+	return wsReply{Type: "error", ID: msg.ID, Error: &body}
+}
+
+// decodeWSMessage strictly decodes a client message.
+func decodeWSMessage(data []byte, msg *wsMessage) error {
+	if err := decodeStrict(data, msg); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidMessage, err)
+	}
+	return nil
+}
+
+// decodeWSTask validates the task document of an add (id 0) or update command.
+func decodeWSTask(doc json.RawMessage, id int) (ToDo, error) {
+	var in taskInput
+	if doc != nil {
+		if err := decodeStrict(doc, &in); err != nil {
+			if errors.As(err, new(validationErrors)) {
+				return ToDo{}, err
+			}
+			return ToDo{}, fmt.Errorf("%w: task: %w", errInvalidMessage, err)
+		}
+	}
+	return in.task(id)
+}
+
+// decodeStrict decodes a single JSON value into v, rejecting unknown fields.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("message must contain a single JSON value")
+	}
+	return nil
+}
+
+// forward sends a subscription's events until the connection or the
+// subscription ends. A client that falls behind, or is connected while the
+// service shuts down, is disconnected and should resubscribe with the last
+// event_id it received.
+func (c *wsConn) forward(ctx context.Context, s *wsSubscription) {
+	if s.reset {
+		if err := wsjson.Write(ctx, c.conn, wsReply{Type: "reset"}); err != nil {
+			return
+		}
+	}
+	for _, e := range s.backlog {
+		if err := c.send(ctx, e); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.sub.done:
+			c.conn.Close(websocket.StatusTryAgainLater, "event stream ended; resubscribe with last_event_id")
+			return
+		case e := <-s.sub.events:
+			if err := c.send(ctx, e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send writes an event message in a span linked to the one that made the change.
+func (c *wsConn) send(ctx context.Context, e feedEvent) error {
+	ctx, span := startEventSpan(ctx, "ws.event", e)
+	defer span.End()
+	return wsjson.Write(ctx, c.conn, wsReply{Type: "event", EventID: e.ID, Event: e.Type, Change: e.Data})
+}
+
+func (c *wsConn) unsubscribe() {
+	if c.sub != nil {
+		changeFeed.unsubscribe(c.sub)
+	}
+}